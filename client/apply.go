@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+// step is a change that apply makes to a job on the server.
+type step struct {
+	action string
+	// job is the job as defined, or as on the server when it is deleted.
+	job     *models.Job
+	etag    string
+	changes models.Changes
+}
+
+// apply makes the jobs on the server those defined in the files of a dir,
+// by creating and updating them and, with -prune, deleting the others. It
+// prints the plan before applying it, and only the plan with -dry-run.
+func apply(c *JobClient, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("apply", flag.ContinueOnError)
+	path := flags.String("f", ".", "the file or dir of job definitions")
+	prune := flags.Bool("prune", false, "delete the jobs that are not defined")
+	dryRun := flags.Bool("dry-run", false, "only show the plan")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	defined, err := readJobFiles(*path)
+	if err != nil {
+		return err
+	}
+	current, err := c.Index()
+	if err != nil {
+		return err
+	}
+	steps := plan(defined, current, *prune)
+	if len(steps) == 0 {
+		fmt.Fprintln(out, "No changes")
+		return nil
+	}
+	printPlan(out, steps)
+	if *dryRun {
+		return nil
+	}
+	for _, s := range steps {
+		switch s.action {
+		case "create":
+			_, err = c.Create(s.job)
+		case "update":
+			_, err = c.Update(s.job, s.etag)
+		case "delete":
+			_, err = c.Destroy(s.job.Slug, s.etag)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%sd %s\n", s.action, s.job.Slug)
+	}
+	return nil
+}
+
+// readJobFiles reads the jobs defined in a file, or in the job files of a
+// dir and its subdirs.
+func readJobFiles(path string) ([]*models.Job, error) {
+	jobs := []*models.Job{}
+	files := map[string]string{}
+	err := filepath.Walk(path, func(name string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || name != path && !models.IsJobFile(name) {
+			return err
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		defined, err := models.ParseJobFile(name, data)
+		if err != nil {
+			return err
+		}
+		for _, job := range defined {
+			if other, ok := files[job.Slug]; ok {
+				return fmt.Errorf("Job %s is defined in both %s and %s", job.Slug, other, name)
+			}
+			files[job.Slug] = name
+		}
+		jobs = append(jobs, defined...)
+		return nil
+	})
+	return jobs, err
+}
+
+// plan returns the steps that make the current jobs those defined, in the
+// order of the slugs.
+func plan(defined []*models.Job, current []models.Job, prune bool) []*step {
+	existing := map[string]*models.Job{}
+	for i := range current {
+		existing[current[i].Slug] = &current[i]
+	}
+	steps := []*step{}
+	for _, job := range defined {
+		j, ok := existing[job.Slug]
+		delete(existing, job.Slug)
+		if !ok {
+			steps = append(steps, &step{action: "create", job: job})
+			continue
+		}
+		if changes := j.Changes(job); len(changes) > 0 {
+			steps = append(steps, &step{action: "update", job: job, etag: etag(j), changes: changes})
+		}
+	}
+	if prune {
+		for _, j := range existing {
+			steps = append(steps, &step{action: "delete", job: j, etag: etag(j)})
+		}
+	}
+	sort.Slice(steps, func(a, b int) bool {
+		return steps[a].job.Slug < steps[b].job.Slug
+	})
+	return steps
+}
+
+// etag is the entity tag of the job on the server, its version.
+func etag(job *models.Job) string {
+	return fmt.Sprintf(`W/"%d"`, job.Version)
+}
+
+func printPlan(out io.Writer, steps []*step) {
+	signs := map[string]string{"create": "+", "update": "~", "delete": "-"}
+	for _, s := range steps {
+		fmt.Fprintf(out, "%s %s %s\n", signs[s.action], s.action, s.job.Slug)
+		names := make([]string, 0, len(s.changes))
+		for name := range s.changes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(out, "    %s: %s -> %s\n", name, planValue(s.changes[name].Before), planValue(s.changes[name].After))
+		}
+	}
+}
+
+func planValue(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andersjanmyr/jobs/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func job(name, command string, version int) *models.Job {
+	j := models.NewJob(name)
+	j.Command = command
+	j.Version = version
+	return j
+}
+
+func TestPlan(t *testing.T) {
+	current := []models.Job{*job("Build", "make", 2), *job("Lint", "golint", 1), *job("Old", "true", 5)}
+	tests := []struct {
+		name    string
+		defined []*models.Job
+		prune   bool
+		steps   []string
+	}{
+		{"unchanged", []*models.Job{job("Build", "make", 0), job("Lint", "golint", 0)}, false, []string{}},
+		{"changed", []*models.Job{job("Build", "make all", 0)}, false, []string{`update build W/"2"`}},
+		{"new", []*models.Job{job("Deploy", "make deploy", 0)}, false, []string{`create deploy`}},
+		{"prune", []*models.Job{job("Build", "make", 0)}, true, []string{`delete lint W/"1"`, `delete old W/"5"`}},
+		{"all", []*models.Job{job("Build", "make all", 0), job("Deploy", "make", 0), job("Lint", "golint", 0)}, true,
+			[]string{`update build W/"2"`, `create deploy`, `delete old W/"5"`}},
+	}
+	for _, test := range tests {
+		steps := []string{}
+		for _, s := range plan(test.defined, current, test.prune) {
+			steps = append(steps, strings.TrimSpace(s.action+" "+s.job.Slug+" "+s.etag))
+		}
+		assert.Equal(t, test.steps, steps, test.name)
+	}
+}
+
+func TestPlanChanges(t *testing.T) {
+	steps := plan([]*models.Job{job("Build", "make all", 0)}, []models.Job{*job("Build", "make", 2)}, false)
+	assert.Equal(t, models.Changes{"Command": {Before: "make", After: "make all"}}, steps[0].changes)
+}
+
+func TestReadJobFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		slugs []string
+		err   string
+	}{
+		{"dir", map[string]string{
+			"build.yaml":      "name: Build\ncommand: make\n---\nname: Lint\n",
+			"sub/deploy.toml": "name = \"Deploy\"\n",
+			"README.md":       "# Jobs\n",
+		}, []string{"build", "lint", "deploy"}, ""},
+		{"duplicate", map[string]string{
+			"a.yaml": "name: Build\n",
+			"b.json": `{"Name": "Build"}`,
+		}, nil, "Job build is defined in both DIR/a.yaml and DIR/b.json"},
+		{"invalid", map[string]string{
+			"a.yaml": "name: Build\ncomand: make\n",
+		}, nil, `DIR/a.yaml: json: unknown field "comand"`},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "jobs")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir) // errcheck-ignore
+		for name, content := range test.files {
+			path := filepath.Join(dir, name)
+			assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+			assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0644))
+		}
+		jobs, err := readJobFiles(dir)
+		if test.err != "" {
+			assert.EqualError(t, err, strings.Replace(test.err, "DIR", dir, -1), test.name)
+			continue
+		}
+		assert.Nil(t, err, test.name)
+		slugs := []string{}
+		for _, j := range jobs {
+			slugs = append(slugs, j.Slug)
+		}
+		assert.Equal(t, test.slugs, slugs, test.name)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+// authorize adds the credentials of the client to the request: the token
+// as a bearer token or, if sign is set, a signature made with it.
+func (c *JobClient) authorize(req *http.Request, body []byte) error {
+	if c.token == "" {
+		return nil
+	}
+	if !c.sign {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		return nil
+	}
+	keyID, secret, err := models.SplitToken(c.token)
+	if err != nil {
+		return err
+	}
+	date := time.Now().UTC().Format(models.SignatureDateFormat)
+	signature := models.Signature(secret, req.Method, req.URL.RequestURI(), date, body)
+	req.Header.Set("X-Jobs-Date", date)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s, Signature=%s",
+		models.SignatureScheme, keyID, signature))
+	return nil
+}
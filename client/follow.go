@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+	"github.com/pkg/errors"
+)
+
+// Follow writes the log of a run to w as it is produced and returns the
+// run when it has finished. A dropped connection is resumed from the last
+// line received.
+func (c *JobClient) Follow(slug string, runID uint, w io.Writer) (*models.Run, error) {
+	url := fmt.Sprintf("%s/%s/runs/%d/log/stream", c.baseUrl, slug, runID)
+	lastID := ""
+	for {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Request parsing failed %s", url))
+		}
+		if err := c.authorize(req, nil); err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Request failed %s", url))
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close() // errcheck-ignore
+			return nil, fmt.Errorf("Request failed %s: %s", url, resp.Status)
+		}
+		run, err := readEvents(resp.Body, w, &lastID)
+		resp.Body.Close() // errcheck-ignore
+		if run != nil || err != nil {
+			return run, err
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// readEvents copies log events to w until the end event, which returns the
+// run, or until the stream breaks off, which returns nil.
+func readEvents(r io.Reader, w io.Writer, lastID *string) (*models.Run, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var id, event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			switch event {
+			case "log":
+				var logLine models.LogLine
+				if err := json.Unmarshal([]byte(data), &logLine); err != nil {
+					return nil, err
+				}
+				fmt.Fprintln(w, logLine.Text)
+				*lastID = id
+			case "end":
+				var run models.Run
+				if err := json.Unmarshal([]byte(data), &run); err != nil {
+					return nil, err
+				}
+				return &run, nil
+			case "error":
+				return nil, fmt.Errorf("Log stream failed: %s", data)
+			}
+			id, event, data = "", "", ""
+		}
+	}
+	return nil, nil
+}
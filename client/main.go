@@ -1,52 +1,218 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/andersjanmyr/jobs/models"
 	"github.com/pkg/errors"
 )
 
+// JobClient calls the API with token, as a bearer token or, if sign is set,
+// to sign its requests with.
 type JobClient struct {
 	baseUrl string
+	token   string
+	sign    bool
 }
 
-func (c *JobClient) request(method, path string) (io.ReadCloser, error) {
+// request calls the path with the body, if any, as JSON. With an etag, the
+// job is only changed if it still has it.
+func (c *JobClient) request(method, path string, body []byte, etag string) (io.ReadCloser, error) {
 	url := fmt.Sprintf("%s/%s", c.baseUrl, strings.TrimLeft(path, "/"))
-	req, err := http.NewRequest(method, url, nil)
+	resp, err := c.do(method, url, body, etag)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (c *JobClient) do(method, url string, body []byte, etag string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("Request parsing failed %s", url))
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	if err := c.authorize(req, body); err != nil {
+		return nil, err
+	}
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("Request failed %s", url))
 	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close() // errcheck-ignore
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Request failed %s: %s %s", url, resp.Status, strings.TrimSpace(string(msg)))
+	}
 
-	return resp.Body, nil
+	return resp, nil
 }
+
+// Index returns all the jobs, following the links to the next pages.
 func (c *JobClient) Index() ([]models.Job, error) {
-	rc, err := c.request(http.MethodGet, "/")
+	jobs := []models.Job{}
+	url := c.baseUrl + "/"
+	for url != "" {
+		resp, err := c.do(http.MethodGet, url, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		page, err := models.ParseJobs(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, page...)
+		url = c.nextPage(resp)
+	}
+	return jobs, nil
+}
+
+// nextPage returns the URL of the page linked as next, or "" on the last
+// page.
+func (c *JobClient) nextPage(resp *http.Response) string {
+	for _, link := range strings.Split(resp.Header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		if len(parts) < 2 || strings.TrimSpace(parts[1]) != `rel="next"` {
+			continue
+		}
+		next, err := resp.Request.URL.Parse(strings.Trim(strings.TrimSpace(parts[0]), "<>"))
+		if err != nil {
+			return ""
+		}
+		return next.String()
+	}
+	return ""
+}
+
+// Cancel stops a run of the job. It fails if the run has already finished,
+// and returns the run still running if its worker is yet to stop it.
+func (c *JobClient) Cancel(slug string, runID uint) (*models.Run, error) {
+	rc, err := c.request(http.MethodDelete, fmt.Sprintf("/%s/runs/%d", slug, runID), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close() // errcheck-ignore
+	var run models.Run
+	if err := json.NewDecoder(rc).Decode(&run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (c *JobClient) Create(job *models.Job) (*models.Job, error) {
+	body, err := json.Marshal(job.Definition())
 	if err != nil {
 		return nil, err
 	}
-	jobs, err := models.ParseJobs(rc)
-	return jobs, err
+	return readJob(c.request(http.MethodPost, "/", body, ""))
 }
 
-func (c *JobClient) Create()  {}
-func (c *JobClient) Show()    {}
-func (c *JobClient) Update()  {}
-func (c *JobClient) Destroy() {}
+func (c *JobClient) Show() {}
 
-func main() {
-	client := JobClient{baseUrl: "http://localhost:5555/jobs"}
-	data, err := client.Index()
+// Update replaces the definition of the job, if it still has the etag.
+func (c *JobClient) Update(job *models.Job, etag string) (*models.Job, error) {
+	body, err := json.Marshal(job.Definition())
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	fmt.Printf("%#v\n", data)
+	return readJob(c.request(http.MethodPut, "/"+job.Slug+"?replace=true", body, etag))
+}
+
+// Destroy deletes the job, if it still has the etag.
+func (c *JobClient) Destroy(slug, etag string) (*models.Job, error) {
+	return readJob(c.request(http.MethodDelete, "/"+slug, nil, etag))
+}
+
+func readJob(rc io.ReadCloser, err error) (*models.Job, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close() // errcheck-ignore
+	var job models.Job
+	if err := json.NewDecoder(rc).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
 
+func main() {
+	client := JobClient{
+		baseUrl: "http://localhost:5555/jobs",
+		token:   os.Getenv("JOBS_TOKEN"),
+		sign:    os.Getenv("JOBS_SIGN") != "",
+	}
+	if len(os.Args) < 2 {
+		data, err := client.Index()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%#v\n", data)
+		return
+	}
+	if err := command(&client, os.Args[1], os.Args[2:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// command runs the command of the client with its args.
+func command(c *JobClient, name string, args []string, out io.Writer) error {
+	switch name {
+	case "apply":
+		return apply(c, args, out)
+	case "cancel":
+		slug, id, err := runArgs(name, args)
+		if err != nil {
+			return err
+		}
+		run, err := c.Cancel(slug, id)
+		if err != nil {
+			return err
+		}
+		if !run.State.Finished() {
+			fmt.Fprintf(out, "Run %d of %s is being cancelled\n", run.ID, slug)
+			return nil
+		}
+		fmt.Fprintf(out, "Run %d of %s is %s\n", run.ID, slug, run.State)
+		return nil
+	case "follow":
+		slug, id, err := runArgs(name, args)
+		if err != nil {
+			return err
+		}
+		run, err := c.Follow(slug, id, out)
+		if err != nil {
+			return err
+		}
+		if run.State != models.Succeeded {
+			return fmt.Errorf("Run %d of %s %s", run.ID, slug, run.State)
+		}
+		return nil
+	}
+	return fmt.Errorf("Unknown command: %s", name)
+}
+
+// runArgs parses the slug and run id of `<command> <slug> <id>`.
+func runArgs(name string, args []string) (string, uint, error) {
+	if len(args) != 2 {
+		return "", 0, fmt.Errorf("Usage: %s <slug> <id>", name)
+	}
+	id, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid run id: %s", args[1])
+	}
+	return args[0], uint(id), nil
 }
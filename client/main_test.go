@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandCancel(t *testing.T) {
+	var method, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		_, _ = w.Write([]byte(`{"ID": 7, "JobSlug": "deploy", "State": "cancelled"}`))
+	}))
+	defer server.Close()
+	c := &JobClient{baseUrl: server.URL + "/jobs"}
+
+	var out bytes.Buffer
+	err := command(c, "cancel", []string{"deploy", "7"}, &out)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "DELETE", method)
+	assert.Equal(t, "/jobs/deploy/runs/7", path)
+	assert.Equal(t, "Run 7 of deploy is cancelled\n", out.String())
+}
+
+func TestCommandCancelAccepted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"ID": 7, "JobSlug": "deploy", "State": "running"}`))
+	}))
+	defer server.Close()
+	c := &JobClient{baseUrl: server.URL + "/jobs"}
+
+	var out bytes.Buffer
+	err := command(c, "cancel", []string{"deploy", "7"}, &out)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "Run 7 of deploy is being cancelled\n", out.String())
+}
+
+func TestCommandFollow(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/jobs/deploy/runs/7/log/stream", r.URL.Path)
+		_, _ = w.Write([]byte("id: 1\nevent: log\ndata: {\"Text\": \"deploying\"}\n\n" +
+			"event: end\ndata: {\"ID\": 7, \"State\": \"failed\"}\n\n"))
+	}))
+	defer server.Close()
+	c := &JobClient{baseUrl: server.URL + "/jobs"}
+
+	var out bytes.Buffer
+	err := command(c, "follow", []string{"deploy", "7"}, &out)
+
+	assert.EqualError(t, err, "Run 7 of deploy failed")
+	assert.Equal(t, "deploying\n", out.String())
+}
+
+func TestCommandErrors(t *testing.T) {
+	c := &JobClient{baseUrl: "http://localhost:0/jobs"}
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"cancel", []string{"deploy"}, "Usage: cancel <slug> <id>"},
+		{"cancel", []string{"deploy", "last"}, "Invalid run id: last"},
+		{"follow", []string{}, "Usage: follow <slug> <id>"},
+		{"restart", []string{}, "Unknown command: restart"},
+	}
+	for _, test := range tests {
+		assert.EqualError(t, command(c, test.name, test.args, &bytes.Buffer{}), test.err)
+	}
+}
@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+// allow writes a 403, unless the role of the user of the request allows
+// the required role on what.
+func allow(w http.ResponseWriter, r *http.Request, role, required models.Role, what string) bool {
+	if role.Allows(required) {
+		return true
+	}
+	writeError(w, models.Forbidden(actor(r), required, what), http.StatusForbidden)
+	return false
+}
+
+// allowGlobal writes a 403, unless the user of the request has the role on
+// everything.
+func allowGlobal(w http.ResponseWriter, r *http.Request, access *models.Access, required models.Role) bool {
+	return allow(w, r, access.TeamRole(actor(r), ""), required, "all jobs")
+}
+
+// allowTeam writes a 403, unless the user may add jobs to the team, or,
+// without a team, add jobs at all.
+func allowTeam(w http.ResponseWriter, r *http.Request, access *models.Access, team string) bool {
+	what := "all jobs"
+	if team != "" {
+		what = "team " + team
+	}
+	return allow(w, r, access.TeamRole(actor(r), team), models.Editor, what)
+}
+
+// findJob returns the job of the slug in the path, if the user of the
+// request has the role on it. Otherwise it writes a 404 or 403.
+func findJob(w http.ResponseWriter, r *http.Request, jobs models.JobRepo, access *models.Access, role models.Role) *models.Job {
+	job, _ := jobs.FindOne(getSlug(r))
+	if job == nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	if !allow(w, r, access.Role(actor(r), job), role, "job "+job.Slug) {
+		return nil
+	}
+	return job
+}
+
+// allowPipeline writes a 403, unless the user of the request has the role
+// on every job of the pipeline.
+func allowPipeline(w http.ResponseWriter, r *http.Request, jobs models.JobRepo, access *models.Access, pipeline *models.Pipeline, role models.Role) bool {
+	if slug := deniedJob(actor(r), jobs, access, pipeline, role); slug != "" {
+		writeError(w, models.Forbidden(actor(r), role, "job "+slug), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// deniedJob returns the slug of a job of the pipeline the user does not
+// have the role on, if there is one.
+func deniedJob(user string, jobs models.JobRepo, access *models.Access, pipeline *models.Pipeline, role models.Role) string {
+	for _, n := range pipeline.Nodes {
+		if !allowsJob(user, jobs, access, n.Job, role) {
+			return n.Job
+		}
+	}
+	return ""
+}
+
+// allowsJob tells if the user has the role on the job of the slug. A job
+// that no longer exists only allows the roles on all jobs.
+func allowsJob(user string, jobs models.JobRepo, access *models.Access, slug string, role models.Role) bool {
+	job, _ := jobs.FindOne(slug)
+	if job == nil {
+		job = &models.Job{Slug: slug}
+	}
+	return access.Role(user, job).Allows(role)
+}
+
+// GrantController manages the roles of users. Admins grant roles on what
+// they are admins of, and users see their own grants.
+type GrantController struct {
+	repo   models.GrantRepo
+	jobs   models.JobRepo
+	access *models.Access
+	audit  models.AuditLog
+}
+
+func NewGrantController(repo models.GrantRepo, jobs models.JobRepo, access *models.Access, audit models.AuditLog) *GrantController {
+	gc := GrantController{
+		repo:   repo,
+		jobs:   jobs,
+		access: access,
+		audit:  audit,
+	}
+	return &gc
+}
+
+func (c *GrantController) Index(w http.ResponseWriter, r *http.Request) {
+	grants, err := c.repo.Find()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user := actor(r)
+	visible := []*models.Grant{}
+	for _, g := range grants {
+		if g.User == user || c.role(user, g).Allows(models.Admin) {
+			visible = append(visible, g)
+		}
+	}
+	writeJson(w, visible)
+}
+
+func (c *GrantController) Create(w http.ResponseWriter, r *http.Request) {
+	grant, err := models.ParseGrant(r.Body)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if grant.User == "" {
+		writeError(w, models.Required("Grant", "User"), http.StatusBadRequest)
+		return
+	}
+	if !c.allow(w, r, grant) {
+		return
+	}
+	g, err := c.repo.Add(grant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(c.audit, r, "grant.create", fmt.Sprintf("grants/%d", g.ID), nil, models.Snapshot(g))
+	w.WriteHeader(http.StatusCreated)
+	writeJson(w, g)
+}
+
+func (c *GrantController) Show(w http.ResponseWriter, r *http.Request) {
+	g := c.findGrant(r)
+	if g == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if g.User != actor(r) && !c.allow(w, r, g) {
+		return
+	}
+	writeJson(w, g)
+}
+
+func (c *GrantController) Update(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Grants cannot be updated", http.StatusMethodNotAllowed)
+}
+
+func (c *GrantController) Destroy(w http.ResponseWriter, r *http.Request) {
+	g := c.findGrant(r)
+	if g == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !c.allow(w, r, g) {
+		return
+	}
+	before := models.Snapshot(g)
+	g, err := c.repo.Delete(g.ID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	audit(c.audit, r, "grant.destroy", fmt.Sprintf("grants/%d", g.ID), before, nil)
+	writeJson(w, g)
+}
+
+func (c *GrantController) findGrant(r *http.Request) *models.Grant {
+	id, err := strconv.ParseUint(getSlug(r), 10, 64)
+	if err != nil {
+		return nil
+	}
+	g, _ := c.repo.FindOne(uint(id))
+	return g
+}
+
+// role returns the role of the user on what the grant is on.
+func (c *GrantController) role(user string, g *models.Grant) models.Role {
+	if g.Job == "" {
+		return c.access.TeamRole(user, g.Team)
+	}
+	job, _ := c.jobs.FindOne(g.Job)
+	if job == nil {
+		job = &models.Job{Slug: g.Job}
+	}
+	return c.access.Role(user, job)
+}
+
+// allow writes a 403, unless the user is an admin of what the grant is on.
+func (c *GrantController) allow(w http.ResponseWriter, r *http.Request, g *models.Grant) bool {
+	what := "all jobs"
+	if g.Job != "" {
+		what = "job " + g.Job
+	} else if g.Team != "" {
+		what = "team " + g.Team
+	}
+	return allow(w, r, c.role(actor(r), g), models.Admin, what)
+}
+
+func (c *GrantController) New(w http.ResponseWriter, r *http.Request)  {}
+func (c *GrantController) Edit(w http.ResponseWriter, r *http.Request) {}
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/andersjanmyr/jobs/models"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+type ArtifactController struct {
+	jobs      models.JobRepo
+	runs      models.RunRepo
+	artifacts models.ArtifactRepo
+	blobs     models.BlobStore
+	access    *models.Access
+}
+
+func NewArtifactController(jobs models.JobRepo, runs models.RunRepo, artifacts models.ArtifactRepo, blobs models.BlobStore, access *models.Access) *ArtifactController {
+	ac := ArtifactController{
+		jobs:      jobs,
+		runs:      runs,
+		artifacts: artifacts,
+		blobs:     blobs,
+		access:    access,
+	}
+	return &ac
+}
+
+func (c *ArtifactController) Index(w http.ResponseWriter, r *http.Request) {
+	if findJob(w, r, c.jobs, c.access, models.Viewer) == nil {
+		return
+	}
+	run := findRun(c.runs, r)
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	artifacts, err := c.artifacts.Find(run.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, artifacts)
+}
+
+// Show downloads the artifact, with its checksum in the
+// X-Checksum-Sha256 header.
+func (c *ArtifactController) Show(w http.ResponseWriter, r *http.Request) {
+	if findJob(w, r, c.jobs, c.access, models.Viewer) == nil {
+		return
+	}
+	run := findRun(c.runs, r)
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	artifact, _ := c.artifacts.FindOne(run.ID, mux.Vars(r)["name"])
+	if artifact == nil {
+		http.NotFound(w, r)
+		return
+	}
+	blob, err := c.blobs.Get(artifact.Key())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close() // errcheck-ignore
+	contentType := mime.TypeByExtension(path.Ext(artifact.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(artifact.Name)))
+	w.Header().Set("X-Checksum-Sha256", artifact.SHA256)
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Warn("Failed to send artifact ", artifact.Name, " of run ", run.ID, ": ", err)
+	}
+}
+
+// Archive downloads all the artifacts of the run as a tar.gz.
+func (c *ArtifactController) Archive(w http.ResponseWriter, r *http.Request) {
+	if findJob(w, r, c.jobs, c.access, models.Viewer) == nil {
+		return
+	}
+	run := findRun(c.runs, r)
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	artifacts, err := c.artifacts.Find(run.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s-%d.tar.gz\"", run.JobSlug, run.ID))
+	// Once the archive has started there is no way to report an error,
+	// other than to cut it short.
+	gz := gzip.NewWriter(w)
+	archive := tar.NewWriter(gz)
+	for _, artifact := range artifacts {
+		if err := c.archive(archive, artifact); err != nil {
+			log.Warn("Failed to archive artifact ", artifact.Name, " of run ", run.ID, ": ", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return
+	}
+	gz.Close() // errcheck-ignore
+}
+
+func (c *ArtifactController) archive(archive *tar.Writer, artifact *models.Artifact) error {
+	blob, err := c.blobs.Get(artifact.Key())
+	if err != nil {
+		return err
+	}
+	defer blob.Close() // errcheck-ignore
+	header := &tar.Header{
+		Name:    artifact.Name,
+		Mode:    0644,
+		Size:    artifact.Size,
+		ModTime: artifact.CreatedAt,
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(archive, blob)
+	return err
+}
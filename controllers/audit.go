@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestID gives each request an id in the X-Request-ID header, unless it
+// has one, and returns it in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			b := make([]byte, 8)
+			_, _ = rand.Read(b)
+			id = hex.EncodeToString(b)
+			r.Header.Set("X-Request-ID", id)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// audit appends an event of the request to the audit log. A failure is
+// only logged, as the change has already been made.
+func audit(events models.AuditLog, r *http.Request, action, resource string, before, after map[string]interface{}) {
+	event := &models.AuditEvent{
+		Actor:     actor(r),
+		Action:    action,
+		Resource:  resource,
+		Changes:   models.Diff(before, after),
+		RequestID: r.Header.Get("X-Request-ID"),
+		SourceIP:  sourceIP(r),
+	}
+	if err := events.Append(event); err != nil {
+		log.Error("Failed to audit ", action, " of ", resource, " by ", event.Actor, ": ", err)
+	}
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+const defaultAuditLimit = 1000
+
+// AuditController lets admins query the audit log.
+type AuditController struct {
+	events models.AuditLog
+	access *models.Access
+}
+
+func NewAuditController(events models.AuditLog, access *models.Access) *AuditController {
+	return &AuditController{
+		events: events,
+		access: access,
+	}
+}
+
+// Index lists the audit events matching the actor, action, resource, since
+// and until query params, oldest first. With format=jsonl, or an Accept
+// header of application/x-ndjson, all the events are exported as JSON
+// Lines, unless a limit is given.
+func (c *AuditController) Index(w http.ResponseWriter, r *http.Request) {
+	if !allowGlobal(w, r, c.access, models.Admin) {
+		return
+	}
+	lines := r.URL.Query().Get("format") == "jsonl" ||
+		strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+	filter, err := parseAuditFilter(r, lines)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := c.events.Find(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !lines {
+		writeJson(w, events)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	for _, e := range events {
+		if err := encoder.Encode(e); err != nil {
+			log.Warn("Failed to export audit events: ", err)
+			return
+		}
+	}
+}
+
+func parseAuditFilter(r *http.Request, all bool) (*models.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := &models.AuditFilter{
+		Actor:    query.Get("actor"),
+		Action:   query.Get("action"),
+		Resource: strings.Trim(query.Get("resource"), "/"),
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s: %s", name, value)
+			}
+			*t = parsed
+		}
+	}
+	defaultLimit := defaultAuditLimit
+	if all {
+		defaultLimit = 0
+	}
+	limit, err := queryInt(r, "limit", defaultLimit)
+	if err != nil {
+		return nil, err
+	}
+	filter.Limit = limit
+	return filter, nil
+}
@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+// MaxClockSkew is how far the date of a signed request may be from the
+// time it is received.
+const MaxClockSkew = 5 * time.Minute
+
+// Authenticator requires each request to have a bearer token or to be
+// signed with one, see models.Token. The user of the token is passed on in
+// the X-User header.
+type Authenticator struct {
+	tokens models.TokenRepo
+	cipher *models.Cipher
+	now    func() time.Time
+}
+
+func NewAuthenticator(tokens models.TokenRepo, cipher *models.Cipher) *Authenticator {
+	return &Authenticator{
+		tokens: tokens,
+		cipher: cipher,
+		now:    time.Now,
+	}
+}
+
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := a.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="jobs"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		r.Header.Set("X-User", token.User)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (*models.Token, error) {
+	auth := r.Header.Get("Authorization")
+	scheme, credentials := auth, ""
+	if i := strings.Index(auth, " "); i >= 0 {
+		scheme, credentials = auth[:i], strings.TrimSpace(auth[i+1:])
+	}
+	switch scheme {
+	case "Bearer":
+		return a.bearer(credentials)
+	case models.SignatureScheme:
+		return a.signed(r, credentials)
+	case "":
+		return nil, fmt.Errorf("Authorization required")
+	}
+	return nil, fmt.Errorf("Unsupported authorization scheme: %s", scheme)
+}
+
+func (a *Authenticator) bearer(credentials string) (*models.Token, error) {
+	keyID, secret, err := models.SplitToken(credentials)
+	if err != nil {
+		return nil, err
+	}
+	token, err := a.find(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return token, token.Verify(secret)
+}
+
+// signed checks the signature of the request, of the form
+// "Credential=<key id>, Signature=<hex>".
+func (a *Authenticator) signed(r *http.Request, credentials string) (*models.Token, error) {
+	fields := map[string]string{}
+	for _, field := range strings.Split(credentials, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	date := r.Header.Get("X-Jobs-Date")
+	t, err := time.Parse(models.SignatureDateFormat, date)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid X-Jobs-Date: %q", date)
+	}
+	if skew := a.now().Sub(t); skew > MaxClockSkew || skew < -MaxClockSkew {
+		return nil, fmt.Errorf("Request date is too far from the server time: %s", date)
+	}
+	token, err := a.find(fields["Credential"])
+	if err != nil {
+		return nil, err
+	}
+	secret, err := token.Secret(a.cipher)
+	if err != nil {
+		return nil, err
+	}
+	// The body is read to be hashed, and replaced for the handler.
+	var body []byte
+	if r.Body != nil {
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			return nil, err
+		}
+		r.Body.Close() // errcheck-ignore
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	expected := models.Signature(secret, r.Method, r.URL.RequestURI(), date, body)
+	if !hmac.Equal([]byte(expected), []byte(fields["Signature"])) {
+		return nil, fmt.Errorf("Invalid signature")
+	}
+	return token, nil
+}
+
+// find returns the token of the key id, if it can be used.
+func (a *Authenticator) find(keyID string) (*models.Token, error) {
+	token, _ := a.tokens.FindOne(keyID)
+	if token == nil {
+		return nil, models.ErrInvalidToken
+	}
+	if err := token.Valid(a.now()); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// TokenController manages the tokens of the authenticated user. The secret
+// of a token is only returned when it is created.
+type TokenController struct {
+	repo   models.TokenRepo
+	cipher *models.Cipher
+	audit  models.AuditLog
+}
+
+func NewTokenController(repo models.TokenRepo, cipher *models.Cipher, audit models.AuditLog) *TokenController {
+	tc := TokenController{
+		repo:   repo,
+		cipher: cipher,
+		audit:  audit,
+	}
+	return &tc
+}
+
+func (c *TokenController) Index(w http.ResponseWriter, r *http.Request) {
+	tokens, err := c.repo.Find()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user := actor(r)
+	owned := []*models.Token{}
+	for _, t := range tokens {
+		if t.User == user {
+			owned = append(owned, t)
+		}
+	}
+	writeJson(w, owned)
+}
+
+// Create creates a token for the authenticated user.
+func (c *TokenController) Create(w http.ResponseWriter, r *http.Request) {
+	parsed, err := models.ParseToken(r.Body)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if parsed.Name == "" {
+		writeError(w, models.Required("Token", "Name"), http.StatusBadRequest)
+		return
+	}
+	token, err := models.NewToken(parsed.Name, actor(r), parsed.ExpiresAt, c.cipher)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t, err := c.repo.Add(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The secret of the token is only shown to its user, as "***".
+	after := models.Snapshot(t)
+	after["Token"] = "***"
+	audit(c.audit, r, "token.create", "tokens/"+t.KeyID, nil, after)
+	w.WriteHeader(http.StatusCreated)
+	writeJson(w, t)
+}
+
+func (c *TokenController) Show(w http.ResponseWriter, r *http.Request) {
+	t := c.findToken(r)
+	if t == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, t)
+}
+
+func (c *TokenController) Update(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "Tokens cannot be updated", http.StatusMethodNotAllowed)
+}
+
+// Destroy revokes the token.
+func (c *TokenController) Destroy(w http.ResponseWriter, r *http.Request) {
+	t := c.findToken(r)
+	if t == nil {
+		http.NotFound(w, r)
+		return
+	}
+	before := models.Snapshot(t)
+	if t.RevokedAt == nil {
+		now := time.Now()
+		t.RevokedAt = &now
+	}
+	t, err := c.repo.Update(t)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(c.audit, r, "token.revoke", "tokens/"+t.KeyID, before, models.Snapshot(t))
+	writeJson(w, t)
+}
+
+// findToken returns the token of the key id in the path, if it belongs to
+// the authenticated user.
+func (c *TokenController) findToken(r *http.Request) *models.Token {
+	t, _ := c.repo.FindOne(getSlug(r))
+	if t == nil || t.User != actor(r) {
+		return nil
+	}
+	return t
+}
+
+func (c *TokenController) New(w http.ResponseWriter, r *http.Request)  {}
+func (c *TokenController) Edit(w http.ResponseWriter, r *http.Request) {}
@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+// writeError writes a validation error as 422 Unprocessable Entity, with
+// the errors of each field, a forbidden error as 403 Forbidden, and any
+// other error with status.
+func writeError(w http.ResponseWriter, err error, status int) {
+	switch e := err.(type) {
+	case *models.ValidationError:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		writeJson(w, e)
+		return
+	case *models.ForbiddenError:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		writeJson(w, e)
+		return
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+// jobETag is the entity tag of a job, its version. It is weak, as it
+// changes with the definition of the job, not when the job last or next
+// runs, which are also in its body.
+func jobETag(job *models.Job) string {
+	return fmt.Sprintf(`W/"%d"`, job.Version)
+}
+
+// jobsETag is the weak entity tag of a list of jobs, a hash of their slugs
+// and versions.
+func jobsETag(jobs []*models.Job) string {
+	h := sha256.New()
+	for _, j := range jobs {
+		fmt.Fprintf(h, "%s:%d\n", j.Slug, j.Version)
+	}
+	return `W/"` + hex.EncodeToString(h.Sum(nil))[:16] + `"`
+}
+
+// etagMatch tells if the header, "*" or a list of entity tags, has the tag,
+// compared weakly, without their W/ prefixes.
+func etagMatch(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified sets the ETag header, and writes a 304 if the If-None-Match
+// header has the tag.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if header := r.Header.Get("If-None-Match"); header != "" && etagMatch(header, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// preconditionFailed writes a 412, unless the If-Match and If-None-Match
+// headers allow changing the job, which is nil if it does not exist. The
+// tags are weak, but compare the version of the job, so If-Match is still
+// exact about the definition it changes.
+func preconditionFailed(w http.ResponseWriter, r *http.Request, job *models.Job) bool {
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	switch {
+	case ifMatch != "" && (job == nil || !etagMatch(ifMatch, jobETag(job))):
+	case ifNoneMatch != "" && job != nil && etagMatch(ifNoneMatch, jobETag(job)):
+	default:
+		return false
+	}
+	writeConflict(w, job)
+	return true
+}
+
+// writeConflict writes a 412 with the current ETag of the job, if it
+// exists.
+func writeConflict(w http.ResponseWriter, job *models.Job) {
+	message := "Job does not exist"
+	if job != nil {
+		w.Header().Set("ETag", jobETag(job))
+		message = fmt.Sprintf("%s, its ETag is %s", models.ErrVersionConflict, jobETag(job))
+	}
+	http.Error(w, message, http.StatusPreconditionFailed)
+}
@@ -2,30 +2,146 @@ package controllers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/andersjanmyr/jobs/models"
 	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
 )
 
 type JobController struct {
-	repo models.JobRepo
+	repo     models.JobRepo
+	versions models.JobVersionRepo
+	access   *models.Access
+	audit    models.AuditLog
+	// Secrets, when set, has the secrets scoped to jobs, which are deleted
+	// with their jobs.
+	Secrets models.SecretRepo
 }
 
-func NewJobController(repo models.JobRepo) *JobController {
+func NewJobController(repo models.JobRepo, versions models.JobVersionRepo, access *models.Access, audit models.AuditLog) *JobController {
 	jc := JobController{
-		repo: repo,
+		repo:     repo,
+		versions: versions,
+		access:   access,
+		audit:    audit,
 	}
 	return &jc
 }
 
+// Index lists a page of the jobs the user may view, and links to the next
+// page in the Link header.
 func (c *JobController) Index(w http.ResponseWriter, r *http.Request) {
-	jobs, err := c.repo.Find()
+	query, err := parseJobQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	visible, next, err := c.findVisible(actor(r), query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	writeJson(w, jobs)
+	if next != "" {
+		u := *r.URL
+		values := u.Query()
+		values.Set("after", next)
+		u.RawQuery = values.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+	}
+	if notModified(w, r, jobsETag(visible)) {
+		return
+	}
+	writeJson(w, visible)
+}
+
+// findVisible finds a page of the jobs that the user may view, and the
+// cursor of the next page, if there are more. Pages of the repo are read
+// until the page is full, since the user may not view all jobs.
+func (c *JobController) findVisible(user string, query *models.JobQuery) ([]*models.Job, string, error) {
+	q := *query
+	if q.Limit > 0 {
+		// One more job tells if there is a next page.
+		q.Limit++
+	}
+	visible := []*models.Job{}
+	for {
+		jobs, err := c.repo.Find(&q)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, j := range jobs {
+			if !c.access.Role(user, j).Allows(models.Viewer) {
+				continue
+			}
+			if query.Limit > 0 && len(visible) == query.Limit {
+				return visible, query.Cursor(visible[len(visible)-1]), nil
+			}
+			visible = append(visible, j)
+		}
+		if q.Limit == 0 || len(jobs) < q.Limit {
+			return visible, "", nil
+		}
+		q.After = query.Cursor(jobs[len(jobs)-1])
+	}
+}
+
+const (
+	defaultJobLimit = 100
+	maxJobLimit     = 1000
+)
+
+func parseJobQuery(r *http.Request) (*models.JobQuery, error) {
+	values := r.URL.Query()
+	query := &models.JobQuery{
+		NamePrefix: values.Get("prefix"),
+		Owner:      values.Get("owner"),
+		Sort:       values.Get("sort"),
+		After:      values.Get("after"),
+	}
+	labels, err := models.ParseLabels(values.Get("labels"))
+	if err != nil {
+		return nil, err
+	}
+	if len(labels) > 0 {
+		query.Labels = labels
+	}
+	if value := values.Get("scheduled"); value != "" {
+		scheduled, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid scheduled: %s", value)
+		}
+		query.Scheduled = &scheduled
+	}
+	for name, t := range map[string]*time.Time{
+		"created_since": &query.CreatedSince, "created_until": &query.CreatedUntil,
+		"updated_since": &query.UpdatedSince, "updated_until": &query.UpdatedUntil,
+	} {
+		if value := values.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s: %s", name, value)
+			}
+			*t = parsed
+		}
+	}
+	switch order := values.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		query.Desc = true
+	default:
+		return nil, fmt.Errorf("Invalid order: %s", order)
+	}
+	if query.Limit, err = queryInt(r, "limit", defaultJobLimit); err != nil {
+		return nil, err
+	}
+	if query.Limit == 0 || query.Limit > maxJobLimit {
+		query.Limit = maxJobLimit
+	}
+	return query, query.Validate()
 }
 
 func writeJson(w http.ResponseWriter, data interface{}) {
@@ -44,12 +160,38 @@ func writeJson(w http.ResponseWriter, data interface{}) {
 
 func (c *JobController) Create(w http.ResponseWriter, r *http.Request) {
 	job, err := models.ParseJob(r.Body)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if job.Slug == "" {
+		writeError(w, models.Required("Job", "Name"), http.StatusBadRequest)
+		return
+	}
+	if !allowTeam(w, r, c.access, job.Team) {
+		return
+	}
+	job.Owner = actor(r)
+	if job.Version, err = models.NextVersion(c.versions, job.Slug); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	j, err := saveVersion(c.versions, job, actor(r), func() (*models.Job, error) {
+		return c.repo.Add(job)
+	})
+	// The version is taken when the job is being created concurrently.
+	if err == models.ErrJobExists || err == models.ErrVersionConflict {
+		http.Error(w, fmt.Sprintf("Job %s already exists", job.Slug), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	audit(c.audit, r, "job.create", "jobs/"+j.Slug, nil, models.Snapshot(j))
+	w.Header().Set("ETag", jobETag(j))
 	w.WriteHeader(http.StatusCreated)
-	writeJson(w, job)
+	writeJson(w, j)
 }
 
 func (c *JobController) Show(w http.ResponseWriter, r *http.Request) {
@@ -63,9 +205,20 @@ func (c *JobController) Show(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if !c.allow(w, r, j, models.Viewer) {
+		return
+	}
+	if notModified(w, r, jobETag(j)) {
+		return
+	}
 	writeJson(w, j)
 }
 
+// allow writes a 403, unless the user may act as the role on the job.
+func (c *JobController) allow(w http.ResponseWriter, r *http.Request, job *models.Job, role models.Role) bool {
+	return allow(w, r, c.access.Role(actor(r), job), role, "job "+job.Slug)
+}
+
 func getSlug(r *http.Request) string {
 	vars := mux.Vars(r)
 	slug := vars["slug"]
@@ -80,15 +233,72 @@ func (c *JobController) Update(w http.ResponseWriter, r *http.Request) {
 	}
 	job, err := models.ParseJob(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err, http.StatusBadRequest)
 		return
 	}
 	job.Slug = slug
-	j, err := c.repo.UpAdd(job)
+	existing, _ := c.repo.FindOne(slug)
+	before := models.Snapshot(existing)
+	if existing == nil {
+		if !allowTeam(w, r, c.access, job.Team) {
+			return
+		}
+		job.Owner = actor(r)
+	} else {
+		if !c.allow(w, r, existing, models.Editor) {
+			return
+		}
+		if job.Team != "" && job.Team != existing.Team && !allowTeam(w, r, c.access, job.Team) {
+			return
+		}
+	}
+	if preconditionFailed(w, r, existing) {
+		return
+	}
+	// A job is merged with the existing one, or replaces it if asked to.
+	job.Version = 0
+	next := job
+	if existing != nil {
+		next = existing.Merge(job)
+		if r.URL.Query().Get("replace") == "true" {
+			next = existing.Replaced(job)
+		}
+	}
+	// Only changes of the definition make a new version.
+	changed := existing == nil || len(existing.Changes(next)) > 0
+	if changed {
+		if next.Version, err = models.NextVersion(c.versions, slug); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	save := func() (*models.Job, error) {
+		if existing == nil {
+			return c.repo.UpAdd(next)
+		}
+		return c.repo.ReplaceVersion(next, existing.Version)
+	}
+	var j *models.Job
+	if changed {
+		j, err = saveVersion(c.versions, next, actor(r), save)
+	} else {
+		j, err = save()
+	}
+	if err == models.ErrVersionConflict || err == models.ErrJobExists {
+		current, _ := c.repo.FindOne(slug)
+		writeConflict(w, current)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	action := "job.update"
+	if existing == nil {
+		action = "job.create"
+	}
+	audit(c.audit, r, action, "jobs/"+slug, before, models.Snapshot(j))
+	w.Header().Set("ETag", jobETag(j))
 	writeJson(w, j)
 }
 
@@ -98,12 +308,63 @@ func (c *JobController) Destroy(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	j, err := c.repo.Delete(slug)
+	j, _ := c.repo.FindOne(slug)
+	if j == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !c.allow(w, r, j, models.Admin) {
+		return
+	}
+	if preconditionFailed(w, r, j) {
+		return
+	}
+	// The job is only deleted as it was checked, not if it has been
+	// updated since.
+	j, err := c.repo.DeleteVersion(slug, j.Version)
+	if err == models.ErrVersionConflict {
+		current, _ := c.repo.FindOne(slug)
+		writeConflict(w, current)
+		return
+	}
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
+	audit(c.audit, r, "job.destroy", "jobs/"+slug, models.Snapshot(j), nil)
+	c.deleteSecrets(r, slug)
+	c.revokeGrants(r, slug)
 	writeJson(w, j)
 }
+
+// deleteSecrets deletes the secrets scoped to the deleted job, so that they
+// are not given to a later job with the same slug.
+func (c *JobController) deleteSecrets(r *http.Request, slug string) {
+	if c.Secrets == nil {
+		return
+	}
+	secrets, err := c.Secrets.DeleteJob(slug)
+	if err != nil {
+		log.Error("Failed to delete the secrets of job ", slug, ": ", err)
+		return
+	}
+	for _, s := range secrets {
+		audit(c.audit, r, "secret.destroy", "secrets/"+s.Slug, models.Snapshot(s), nil)
+	}
+}
+
+// revokeGrants deletes the grants on the deleted job, so that they are not
+// given on a later job with the same slug.
+func (c *JobController) revokeGrants(r *http.Request, slug string) {
+	grants, err := c.access.RevokeJob(slug)
+	if err != nil {
+		log.Error("Failed to revoke the grants on job ", slug, ": ", err)
+		return
+	}
+	for _, g := range grants {
+		audit(c.audit, r, "grant.destroy", fmt.Sprintf("grants/%d", g.ID), models.Snapshot(g), nil)
+	}
+}
+
 func (c *JobController) New(w http.ResponseWriter, r *http.Request)  {}
 func (c *JobController) Edit(w http.ResponseWriter, r *http.Request) {}
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+// StreamPollInterval is how often LogStream looks for new lines.
+var StreamPollInterval = 250 * time.Millisecond
+
+// LogStream sends the log of a run as Server-Sent Events. Each line is a
+// "log" event with the line's Seq as id, so a client reconnecting with
+// Last-Event-ID continues after the last line it saw. When the run has
+// finished and all lines are sent, an "end" event carries the run.
+func (c *RunController) LogStream(w http.ResponseWriter, r *http.Request) {
+	if findJob(w, r, c.jobs, c.access, models.Viewer) == nil {
+		return
+	}
+	run := c.findRun(r)
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	offset := 0
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		seq, err := strconv.Atoi(lastID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid Last-Event-ID: %s", lastID), http.StatusBadRequest)
+			return
+		}
+		offset = seq + 1
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		// Check the state before reading, so that no lines written
+		// between the read and a finished state are missed.
+		current, err := c.runs.FindOne(run.ID)
+		if err != nil {
+			writeEvent(w, "error", "", err.Error())
+			return
+		}
+		lines, err := c.logs.Read(run.ID, offset, defaultLogLimit)
+		if err != nil {
+			writeEvent(w, "error", "", err.Error())
+			return
+		}
+		for _, line := range lines {
+			writeEvent(w, "log", strconv.Itoa(line.Seq), line)
+			offset = line.Seq + 1
+		}
+		if len(lines) == 0 && current.State.Finished() {
+			writeEvent(w, "end", "", current)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+		if len(lines) == defaultLogLimit {
+			continue
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(StreamPollInterval):
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event, id string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		b, _ = json.Marshal(err.Error())
+	}
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/andersjanmyr/jobs/models"
+	"github.com/andersjanmyr/jobs/runner"
+)
+
+type PipelineController struct {
+	repo   models.PipelineRepo
+	jobs   models.JobRepo
+	access *models.Access
+}
+
+func NewPipelineController(repo models.PipelineRepo, jobs models.JobRepo, access *models.Access) *PipelineController {
+	pc := PipelineController{
+		repo:   repo,
+		jobs:   jobs,
+		access: access,
+	}
+	return &pc
+}
+
+// Index lists the pipelines of jobs the user may all view.
+func (c *PipelineController) Index(w http.ResponseWriter, r *http.Request) {
+	pipelines, err := c.repo.Find()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user := actor(r)
+	visible := []*models.Pipeline{}
+	for _, p := range pipelines {
+		if deniedJob(user, c.jobs, c.access, p, models.Viewer) == "" {
+			visible = append(visible, p)
+		}
+	}
+	writeJson(w, visible)
+}
+
+func (c *PipelineController) checkJobs(pipeline *models.Pipeline) error {
+	for _, n := range pipeline.Nodes {
+		if j, _ := c.jobs.FindOne(n.Job); j == nil {
+			return fmt.Errorf("Pipeline node %s has unknown job %s", n.Name, n.Job)
+		}
+	}
+	return nil
+}
+
+func (c *PipelineController) Create(w http.ResponseWriter, r *http.Request) {
+	pipeline, err := models.ParsePipeline(r.Body)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if pipeline.Slug == "" {
+		writeError(w, models.Required("Pipeline", "Name"), http.StatusBadRequest)
+		return
+	}
+	if err := c.checkJobs(pipeline); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowPipeline(w, r, c.jobs, c.access, pipeline, models.Editor) {
+		return
+	}
+	p, err := c.repo.Add(pipeline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJson(w, p)
+}
+
+func (c *PipelineController) Show(w http.ResponseWriter, r *http.Request) {
+	p, _ := c.repo.FindOne(getSlug(r))
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowPipeline(w, r, c.jobs, c.access, p, models.Viewer) {
+		return
+	}
+	writeJson(w, p)
+}
+
+func (c *PipelineController) Update(w http.ResponseWriter, r *http.Request) {
+	slug := getSlug(r)
+	if slug == "" {
+		http.NotFound(w, r)
+		return
+	}
+	pipeline, err := models.ParsePipeline(r.Body)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := c.checkJobs(pipeline); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowPipeline(w, r, c.jobs, c.access, pipeline, models.Editor) {
+		return
+	}
+	if existing, _ := c.repo.FindOne(slug); existing != nil && !allowPipeline(w, r, c.jobs, c.access, existing, models.Editor) {
+		return
+	}
+	pipeline.Slug = slug
+	p, err := c.repo.UpAdd(pipeline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, p)
+}
+
+func (c *PipelineController) Destroy(w http.ResponseWriter, r *http.Request) {
+	p, _ := c.repo.FindOne(getSlug(r))
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !allowPipeline(w, r, c.jobs, c.access, p, models.Editor) {
+		return
+	}
+	p, err := c.repo.Delete(p.Slug)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, p)
+}
+func (c *PipelineController) New(w http.ResponseWriter, r *http.Request)  {}
+func (c *PipelineController) Edit(w http.ResponseWriter, r *http.Request) {}
+
+type PipelineRunController struct {
+	pipelines models.PipelineRepo
+	runs      models.PipelineRunRepo
+	runner    *runner.PipelineRunner
+	jobs      models.JobRepo
+	access    *models.Access
+	audit     models.AuditLog
+}
+
+func NewPipelineRunController(pipelines models.PipelineRepo, runs models.PipelineRunRepo, runner *runner.PipelineRunner, jobs models.JobRepo, access *models.Access, audit models.AuditLog) *PipelineRunController {
+	rc := PipelineRunController{
+		pipelines: pipelines,
+		runs:      runs,
+		runner:    runner,
+		jobs:      jobs,
+		access:    access,
+		audit:     audit,
+	}
+	return &rc
+}
+
+// findPipeline returns the pipeline of the slug in the path, if the user
+// of the request has the role on all its jobs. Otherwise it writes a 404 or
+// 403.
+func (c *PipelineRunController) findPipeline(w http.ResponseWriter, r *http.Request, role models.Role) *models.Pipeline {
+	pipeline, _ := c.pipelines.FindOne(getSlug(r))
+	if pipeline == nil {
+		http.NotFound(w, r)
+		return nil
+	}
+	if !allowPipeline(w, r, c.jobs, c.access, pipeline, role) {
+		return nil
+	}
+	return pipeline
+}
+
+func (c *PipelineRunController) Index(w http.ResponseWriter, r *http.Request) {
+	pipeline := c.findPipeline(w, r, models.Viewer)
+	if pipeline == nil {
+		return
+	}
+	runs, err := c.runs.Find(pipeline.Slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, runs)
+}
+
+func (c *PipelineRunController) Create(w http.ResponseWriter, r *http.Request) {
+	pipeline := c.findPipeline(w, r, models.Operator)
+	if pipeline == nil {
+		return
+	}
+	run, err := c.runner.Start(pipeline)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	audit(c.audit, r, "pipeline.trigger", fmt.Sprintf("pipelines/%s/runs/%d", pipeline.Slug, run.ID), nil, models.Snapshot(run))
+	w.WriteHeader(http.StatusCreated)
+	writeJson(w, run)
+}
+
+func (c *PipelineRunController) Show(w http.ResponseWriter, r *http.Request) {
+	if c.findPipeline(w, r, models.Viewer) == nil {
+		return
+	}
+	id, err := getID(r)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	run, _ := c.runs.FindOne(id)
+	if run == nil || run.PipelineSlug != getSlug(r) {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, run)
+}
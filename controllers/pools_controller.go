@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+type PoolController struct {
+	repo   models.PoolRepo
+	access *models.Access
+}
+
+func NewPoolController(repo models.PoolRepo, access *models.Access) *PoolController {
+	pc := PoolController{
+		repo:   repo,
+		access: access,
+	}
+	return &pc
+}
+
+func (c *PoolController) Index(w http.ResponseWriter, r *http.Request) {
+	pools, err := c.repo.Find()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, pools)
+}
+
+func (c *PoolController) Create(w http.ResponseWriter, r *http.Request) {
+	pool, err := models.ParsePool(r.Body)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if pool.Slug == "" {
+		writeError(w, models.Required("Pool", "Name"), http.StatusBadRequest)
+		return
+	}
+	if !allowGlobal(w, r, c.access, models.Editor) {
+		return
+	}
+	p, err := c.repo.Add(pool)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJson(w, p)
+}
+
+func (c *PoolController) Show(w http.ResponseWriter, r *http.Request) {
+	p, _ := c.repo.FindOne(getSlug(r))
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, p)
+}
+
+func (c *PoolController) Update(w http.ResponseWriter, r *http.Request) {
+	slug := getSlug(r)
+	if slug == "" {
+		http.NotFound(w, r)
+		return
+	}
+	pool, err := models.ParsePool(r.Body)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if !allowGlobal(w, r, c.access, models.Editor) {
+		return
+	}
+	pool.Slug = slug
+	p, err := c.repo.UpAdd(pool)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, p)
+}
+
+func (c *PoolController) Destroy(w http.ResponseWriter, r *http.Request) {
+	if !allowGlobal(w, r, c.access, models.Editor) {
+		return
+	}
+	p, err := c.repo.Delete(getSlug(r))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, p)
+}
+func (c *PoolController) New(w http.ResponseWriter, r *http.Request)  {}
+func (c *PoolController) Edit(w http.ResponseWriter, r *http.Request) {}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+type QueueController struct {
+	queue  models.Queue
+	jobs   models.JobRepo
+	access *models.Access
+}
+
+func NewQueueController(queue models.Queue, jobs models.JobRepo, access *models.Access) *QueueController {
+	return &QueueController{
+		queue:  queue,
+		jobs:   jobs,
+		access: access,
+	}
+}
+
+// Index lists the queued runs in the order they are expected to be
+// executed, with their positions. Only the runs of the jobs the user may
+// view are listed, with their positions in the whole queue.
+func (c *QueueController) Index(w http.ResponseWriter, r *http.Request) {
+	items, err := c.queue.Queued()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	visible := []*models.QueueItem{}
+	for _, item := range items {
+		if allowsJob(actor(r), c.jobs, c.access, item.JobSlug, models.Viewer) {
+			visible = append(visible, item)
+		}
+	}
+	writeJson(w, visible)
+}
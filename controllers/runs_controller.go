@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/andersjanmyr/jobs/models"
+	"github.com/andersjanmyr/jobs/runner"
+	"github.com/gorilla/mux"
+)
+
+type RunController struct {
+	jobs   models.JobRepo
+	runs   models.RunRepo
+	logs   models.LogStore
+	runner *runner.Runner
+	access *models.Access
+	audit  models.AuditLog
+}
+
+func NewRunController(jobs models.JobRepo, runs models.RunRepo, logs models.LogStore, runner *runner.Runner, access *models.Access, audit models.AuditLog) *RunController {
+	rc := RunController{
+		jobs:   jobs,
+		runs:   runs,
+		logs:   logs,
+		runner: runner,
+		access: access,
+		audit:  audit,
+	}
+	return &rc
+}
+
+func (c *RunController) Index(w http.ResponseWriter, r *http.Request) {
+	job := findJob(w, r, c.jobs, c.access, models.Viewer)
+	if job == nil {
+		return
+	}
+	runs, err := c.runs.Find(job.Slug)
+	if err == nil {
+		err = c.runner.WaitingOn(runs...)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, runs)
+}
+
+func (c *RunController) Create(w http.ResponseWriter, r *http.Request) {
+	job := findJob(w, r, c.jobs, c.access, models.Operator)
+	if job == nil {
+		return
+	}
+	// The priority of the job can be overridden for the run.
+	prioritized := *job
+	if p := r.URL.Query().Get("priority"); p != "" {
+		priority, err := strconv.Atoi(p)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid priority: %s", p), http.StatusBadRequest)
+			return
+		}
+		prioritized.Priority = priority
+	}
+	values, err := parseParams(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	run, err := c.runner.StartWith(&prioritized, values)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	audit(c.audit, r, "run.trigger", runResource(run), nil, models.Snapshot(run))
+	w.WriteHeader(http.StatusCreated)
+	writeJson(w, run)
+}
+
+func (c *RunController) Destroy(w http.ResponseWriter, r *http.Request) {
+	if findJob(w, r, c.jobs, c.access, models.Operator) == nil {
+		return
+	}
+	run := c.findRun(r)
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	before := models.Snapshot(run)
+	run, err := c.runner.Cancel(run.ID, actor(r))
+	if err == runner.ErrFinished {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	accepted := err == runner.ErrCancelling
+	if err != nil && !accepted {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(c.audit, r, "run.cancel", runResource(run), before, models.Snapshot(run))
+	if accepted {
+		// The run is still executing, and is stopped by its worker.
+		w.WriteHeader(http.StatusAccepted)
+	}
+	writeJson(w, run)
+}
+
+func runResource(run *models.Run) string {
+	return fmt.Sprintf("jobs/%s/runs/%d", run.JobSlug, run.ID)
+}
+
+// parseParams reads the param values of a run from an optional body such as
+// {"Params": {"env": "prod"}}.
+func parseParams(reader io.ReadCloser) (map[string]interface{}, error) {
+	var body struct {
+		Params map[string]interface{}
+	}
+	if reader == nil {
+		return nil, nil
+	}
+	defer reader.Close() // errcheck-ignore
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	if err := decoder.Decode(&body); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return body.Params, nil
+}
+
+// actor names who made the request.
+func actor(r *http.Request) string {
+	if user := r.Header.Get("X-User"); user != "" {
+		return user
+	}
+	return "anonymous"
+}
+
+func (c *RunController) Show(w http.ResponseWriter, r *http.Request) {
+	if findJob(w, r, c.jobs, c.access, models.Viewer) == nil {
+		return
+	}
+	run := c.findRun(r)
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := c.runner.WaitingOn(run); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, run)
+}
+
+func getID(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	return uint(id), err
+}
+
+func (c *RunController) findRun(r *http.Request) *models.Run {
+	return findRun(c.runs, r)
+}
+
+// findRun returns the run of the id in the path, if it is a run of the job
+// of the slug.
+func findRun(runs models.RunRepo, r *http.Request) *models.Run {
+	id, err := getID(r)
+	if err != nil {
+		return nil
+	}
+	run, _ := runs.FindOne(id)
+	if run == nil || run.JobSlug != getSlug(r) {
+		return nil
+	}
+	return run
+}
+
+const defaultLogLimit = 1000
+
+func (c *RunController) Log(w http.ResponseWriter, r *http.Request) {
+	if findJob(w, r, c.jobs, c.access, models.Viewer) == nil {
+		return
+	}
+	run := c.findRun(r)
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultLogLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lines, err := c.logs.Read(run.ID, offset, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, lines)
+}
+
+func queryInt(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("Invalid %s: %s", name, value)
+	}
+	return i, nil
+}
@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+// SecretController manages secrets. Values are encrypted as they are
+// written, and never returned. Editors of a job or team manage its
+// secrets.
+type SecretController struct {
+	repo   models.SecretRepo
+	cipher *models.Cipher
+	jobs   models.JobRepo
+	access *models.Access
+	audit  models.AuditLog
+}
+
+func NewSecretController(repo models.SecretRepo, cipher *models.Cipher, jobs models.JobRepo, access *models.Access, audit models.AuditLog) *SecretController {
+	sc := SecretController{
+		repo:   repo,
+		cipher: cipher,
+		jobs:   jobs,
+		access: access,
+		audit:  audit,
+	}
+	return &sc
+}
+
+// Index lists the secrets the user may view.
+func (c *SecretController) Index(w http.ResponseWriter, r *http.Request) {
+	secrets, err := c.repo.Find()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user := actor(r)
+	visible := []*models.Secret{}
+	for _, s := range secrets {
+		if c.role(user, s).Allows(models.Viewer) {
+			visible = append(visible, s)
+		}
+	}
+	writeJson(w, visible)
+}
+
+// role returns the role of the user on the job or team of the secret.
+func (c *SecretController) role(user string, s *models.Secret) models.Role {
+	if s.Job == "" {
+		return c.access.TeamRole(user, s.Team)
+	}
+	job, _ := c.jobs.FindOne(s.Job)
+	if job == nil {
+		job = &models.Job{Slug: s.Job}
+	}
+	return c.access.Role(user, job)
+}
+
+// allow writes a 403, unless the user of the request has the role on the
+// job or team of the secret.
+func (c *SecretController) allow(w http.ResponseWriter, r *http.Request, s *models.Secret, role models.Role) bool {
+	what := "team " + s.Team
+	if s.Job != "" {
+		what = "job " + s.Job
+	}
+	return allow(w, r, c.role(actor(r), s), role, what)
+}
+
+func (c *SecretController) Create(w http.ResponseWriter, r *http.Request) {
+	secret, err := models.ParseSecret(r.Body)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if secret.Slug == "" {
+		writeError(w, models.Required("Secret", "Name"), http.StatusBadRequest)
+		return
+	}
+	if secret.Value == "" {
+		writeError(w, models.Required("Secret", "Value"), http.StatusBadRequest)
+		return
+	}
+	if !c.allow(w, r, secret, models.Editor) {
+		return
+	}
+	if err := secret.Seal(c.cipher); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s, err := c.repo.Add(secret)
+	if err == models.ErrSecretExists {
+		http.Error(w, fmt.Sprintf("Secret %s already exists", secret.Slug), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.auditChange(r, "secret.create", nil, s, true)
+	w.WriteHeader(http.StatusCreated)
+	writeJson(w, s)
+}
+
+func (c *SecretController) Show(w http.ResponseWriter, r *http.Request) {
+	s, _ := c.repo.FindOne(getSlug(r))
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !c.allow(w, r, s, models.Viewer) {
+		return
+	}
+	writeJson(w, s)
+}
+
+// Update replaces the value of the secret if one is given.
+func (c *SecretController) Update(w http.ResponseWriter, r *http.Request) {
+	slug := getSlug(r)
+	if slug == "" {
+		http.NotFound(w, r)
+		return
+	}
+	secret, err := models.ParseSecret(r.Body)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	secret.Slug = slug
+	existing, _ := c.repo.FindOne(slug)
+	before := models.Snapshot(existing)
+	if existing != nil && !c.allow(w, r, existing, models.Editor) {
+		return
+	}
+	if !c.allow(w, r, secret, models.Editor) {
+		return
+	}
+	sealed := secret.Value != ""
+	if sealed {
+		if err := secret.Seal(c.cipher); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else if existing == nil {
+		writeError(w, models.Required("Secret", "Value"), http.StatusBadRequest)
+		return
+	}
+	s, err := c.repo.UpAdd(secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	action := "secret.update"
+	if existing == nil {
+		action = "secret.create"
+	}
+	c.auditChange(r, action, before, s, sealed)
+	writeJson(w, s)
+}
+
+func (c *SecretController) Destroy(w http.ResponseWriter, r *http.Request) {
+	s, _ := c.repo.FindOne(getSlug(r))
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !c.allow(w, r, s, models.Editor) {
+		return
+	}
+	before := models.Snapshot(s)
+	s, err := c.repo.Delete(s.Slug)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	audit(c.audit, r, "secret.destroy", "secrets/"+s.Slug, before, nil)
+	writeJson(w, s)
+}
+
+// auditChange audits a change of the secret, where a new value is shown as
+// "***".
+func (c *SecretController) auditChange(r *http.Request, action string, before map[string]interface{}, s *models.Secret, sealed bool) {
+	after := models.Snapshot(s)
+	if sealed {
+		after["Value"] = "***"
+	}
+	audit(c.audit, r, action, "secrets/"+s.Slug, before, after)
+}
+func (c *SecretController) New(w http.ResponseWriter, r *http.Request)  {}
+func (c *SecretController) Edit(w http.ResponseWriter, r *http.Request) {}
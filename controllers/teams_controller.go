@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+type TeamController struct {
+	repo   models.TeamRepo
+	access *models.Access
+}
+
+func NewTeamController(repo models.TeamRepo, access *models.Access) *TeamController {
+	pc := TeamController{
+		repo:   repo,
+		access: access,
+	}
+	return &pc
+}
+
+func (c *TeamController) Index(w http.ResponseWriter, r *http.Request) {
+	teams, err := c.repo.Find()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, teams)
+}
+
+func (c *TeamController) Create(w http.ResponseWriter, r *http.Request) {
+	team, err := models.ParseTeam(r.Body)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if team.Slug == "" {
+		writeError(w, models.Required("Team", "Name"), http.StatusBadRequest)
+		return
+	}
+	if !allowGlobal(w, r, c.access, models.Admin) {
+		return
+	}
+	t, err := c.repo.Add(team)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJson(w, t)
+}
+
+func (c *TeamController) Show(w http.ResponseWriter, r *http.Request) {
+	t, _ := c.repo.FindOne(getSlug(r))
+	if t == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, t)
+}
+
+func (c *TeamController) Update(w http.ResponseWriter, r *http.Request) {
+	slug := getSlug(r)
+	if slug == "" {
+		http.NotFound(w, r)
+		return
+	}
+	team, err := models.ParseTeam(r.Body)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	if !c.allow(w, r, slug) {
+		return
+	}
+	team.Slug = slug
+	t, err := c.repo.UpAdd(team)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, t)
+}
+
+func (c *TeamController) Destroy(w http.ResponseWriter, r *http.Request) {
+	if !c.allow(w, r, getSlug(r)) {
+		return
+	}
+	t, err := c.repo.Delete(getSlug(r))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, t)
+}
+
+// allow writes a 403, unless the user of the request is an admin of the
+// team.
+func (c *TeamController) allow(w http.ResponseWriter, r *http.Request, slug string) bool {
+	return allow(w, r, c.access.TeamRole(actor(r), slug), models.Admin, "team "+slug)
+}
+
+func (c *TeamController) New(w http.ResponseWriter, r *http.Request)  {}
+func (c *TeamController) Edit(w http.ResponseWriter, r *http.Request) {}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/andersjanmyr/jobs/models"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// VersionController shows the versions of the definition of a job, and
+// rolls it back to them.
+type VersionController struct {
+	jobs     models.JobRepo
+	versions models.JobVersionRepo
+	access   *models.Access
+	audit    models.AuditLog
+}
+
+func NewVersionController(jobs models.JobRepo, versions models.JobVersionRepo, access *models.Access, audit models.AuditLog) *VersionController {
+	return &VersionController{
+		jobs:     jobs,
+		versions: versions,
+		access:   access,
+		audit:    audit,
+	}
+}
+
+func (c *VersionController) Index(w http.ResponseWriter, r *http.Request) {
+	job := findJob(w, r, c.jobs, c.access, models.Viewer)
+	if job == nil {
+		return
+	}
+	versions, err := c.versions.Find(job.Slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJson(w, versions)
+}
+
+func (c *VersionController) Show(w http.ResponseWriter, r *http.Request) {
+	job := findJob(w, r, c.jobs, c.access, models.Viewer)
+	if job == nil {
+		return
+	}
+	v := c.findVersion(job, mux.Vars(r)["version"])
+	if v == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, v)
+}
+
+// Diff returns the changes of the definition from the version in the from
+// query param, by default the one before, to the version.
+func (c *VersionController) Diff(w http.ResponseWriter, r *http.Request) {
+	job := findJob(w, r, c.jobs, c.access, models.Viewer)
+	if job == nil {
+		return
+	}
+	to := c.findVersion(job, mux.Vars(r)["version"])
+	if to == nil {
+		http.NotFound(w, r)
+		return
+	}
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		from = strconv.Itoa(to.Version - 1)
+	}
+	v := c.findVersion(job, from)
+	if v == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJson(w, v.Diff(to))
+}
+
+// Rollback restores the definition of the version in the version query
+// param, as a new version.
+func (c *VersionController) Rollback(w http.ResponseWriter, r *http.Request) {
+	job := findJob(w, r, c.jobs, c.access, models.Editor)
+	if job == nil {
+		return
+	}
+	version := r.URL.Query().Get("version")
+	if version == "" {
+		http.Error(w, "Rollback requires a version", http.StatusBadRequest)
+		return
+	}
+	v := c.findVersion(job, version)
+	if v == nil {
+		http.Error(w, fmt.Sprintf("No version %s found of job %s", version, job.Slug), http.StatusNotFound)
+		return
+	}
+	if preconditionFailed(w, r, job) {
+		return
+	}
+	before := models.Snapshot(job)
+	restored := v.Restore(job)
+	// Restoring a version may move the job to another team, which the
+	// user must be allowed to add jobs to, as when updating it.
+	if restored.Team != job.Team && !allowTeam(w, r, c.access, restored.Team) {
+		return
+	}
+	next, err := models.NextVersion(c.versions, job.Slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	restored.Version = next
+	j, err := saveVersion(c.versions, restored, actor(r), func() (*models.Job, error) {
+		return c.jobs.ReplaceVersion(restored, job.Version)
+	})
+	if err == models.ErrVersionConflict {
+		current, _ := c.jobs.FindOne(job.Slug)
+		writeConflict(w, current)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	audit(c.audit, r, "job.rollback", "jobs/"+j.Slug, before, models.Snapshot(j))
+	w.Header().Set("ETag", jobETag(j))
+	writeJson(w, j)
+}
+
+// saveVersion adds the version of the job before save writes the job, so
+// that a job is never at a version that is not recorded. A version taken
+// by a concurrent change fails with ErrVersionConflict, and the version is
+// deleted again if the job cannot be saved.
+func saveVersion(versions models.JobVersionRepo, job *models.Job, author string, save func() (*models.Job, error)) (*models.Job, error) {
+	if _, err := versions.Add(models.NewJobVersion(job, author)); err != nil {
+		return nil, err
+	}
+	j, err := save()
+	if err != nil {
+		if err := versions.Delete(job.Slug, job.Version); err != nil {
+			log.Error("Failed to delete version ", job.Version, " of job ", job.Slug, ": ", err)
+		}
+		return nil, err
+	}
+	return j, nil
+}
+
+func (c *VersionController) findVersion(job *models.Job, version string) *models.JobVersion {
+	n, err := strconv.Atoi(version)
+	if err != nil {
+		return nil
+	}
+	v, _ := c.versions.FindOne(job.Slug, n)
+	return v
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+type WorkerController struct {
+	workers models.WorkerRepo
+	queue   models.Queue
+	jobs    models.JobRepo
+	access  *models.Access
+}
+
+func NewWorkerController(workers models.WorkerRepo, queue models.Queue, jobs models.JobRepo, access *models.Access) *WorkerController {
+	return &WorkerController{
+		workers: workers,
+		queue:   queue,
+		jobs:    jobs,
+		access:  access,
+	}
+}
+
+// Index lists the registered workers, with the runs they are executing of
+// the jobs the user may view.
+func (c *WorkerController) Index(w http.ResponseWriter, r *http.Request) {
+	workers, err := c.workers.Find()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	items, err := c.queue.Find()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, worker := range workers {
+		worker.Runs = []uint{}
+		for _, item := range items {
+			if item.ClaimedBy == worker.Name && allowsJob(actor(r), c.jobs, c.access, item.JobSlug, models.Viewer) {
+				worker.Runs = append(worker.Runs, item.RunID)
+			}
+		}
+	}
+	writeJson(w, workers)
+}
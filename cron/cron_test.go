@@ -0,0 +1,72 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04:05", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func next(t *testing.T, spec, from string) string {
+	s, err := Parse(spec)
+	assert.Nil(t, err)
+	return s.Next(date(from)).Format("2006-01-02 15:04:05")
+}
+
+func TestNext(t *testing.T) {
+	assert.Equal(t, "2017-06-01 12:01:00", next(t, "* * * * *", "2017-06-01 12:00:00"))
+	assert.Equal(t, "2017-06-01 12:00:01", next(t, "* * * * * *", "2017-06-01 12:00:00"))
+	assert.Equal(t, "2017-06-01 12:00:30", next(t, "*/15 * * * * *", "2017-06-01 12:00:15"))
+	assert.Equal(t, "2017-06-02 03:30:00", next(t, "30 3 * * *", "2017-06-01 12:00:00"))
+	assert.Equal(t, "2017-06-05 09:00:00", next(t, "0 9 * * MON-FRI", "2017-06-02 09:00:00"))
+	assert.Equal(t, "2017-07-01 00:00:00", next(t, "@monthly", "2017-06-01 00:00:00"))
+	assert.Equal(t, "2018-01-01 00:00:00", next(t, "@yearly", "2017-06-01 00:00:00"))
+	assert.Equal(t, "2017-06-04 00:00:00", next(t, "0 0 * * 7", "2017-06-01 00:00:00"))
+	assert.Equal(t, "2018-02-28 00:00:00", next(t, "0 0 28 feb *", "2017-06-01 00:00:00"))
+	assert.Equal(t, "2020-02-29 00:00:00", next(t, "0 0 29 2 *", "2017-06-01 00:00:00"))
+	assert.Equal(t, "2017-06-01 12:10:00", next(t, "10-50/20 * * * *", "2017-06-01 12:00:00"))
+}
+
+func TestNextDomOrDow(t *testing.T) {
+	// With both day fields restricted, either one matching is enough.
+	assert.Equal(t, "2017-06-02 00:00:00", next(t, "0 0 15 * FRI", "2017-06-01 00:00:00"))
+	assert.Equal(t, "2017-06-15 00:00:00", next(t, "0 0 15 * FRI", "2017-06-09 00:00:00"))
+}
+
+func TestNextLocation(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	assert.Nil(t, err)
+	s, _ := Parse("0 0 9 * * *")
+	from := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	n := s.Next(from.In(loc))
+	assert.Equal(t, time.Date(2017, 6, 1, 13, 0, 0, 0, time.UTC), n.UTC())
+}
+
+func TestNextNever(t *testing.T) {
+	s, _ := Parse("0 0 30 2 *")
+	assert.True(t, s.Next(date("2017-06-01 00:00:00")).IsZero())
+}
+
+func TestBetween(t *testing.T) {
+	s, _ := Parse("0 * * * *")
+	times := s.Between(date("2017-06-01 10:30:00"), date("2017-06-01 13:00:00"), 10)
+	assert.Equal(t, 3, len(times))
+	assert.Equal(t, date("2017-06-01 11:00:00"), times[0])
+	assert.Equal(t, date("2017-06-01 13:00:00"), times[2])
+	assert.Equal(t, 1, len(s.Between(date("2017-06-01 10:30:00"), date("2017-06-01 13:00:00"), 1)))
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * *", "60 * * * *", "* * * * * * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		_, err := Parse(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
@@ -4,12 +4,17 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"runtime"
 	"strconv"
+	"syscall"
 
 	_ "net/http/pprof"
 
 	"github.com/andersjanmyr/jobs/controllers"
 	"github.com/andersjanmyr/jobs/models"
+	"github.com/andersjanmyr/jobs/runner"
+	"github.com/andersjanmyr/jobs/scheduler"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
@@ -24,20 +29,93 @@ func main() {
 		// but possible.
 		panic(err)
 	}
-	port := 5555
-
 	log.SetOutput(os.Stdout)
-	var router = mux.NewRouter().StrictSlash(true)
-	loggedRouter := handlers.LoggingHandler(os.Stdout, slowMiddleware(router))
+	db := openDb()
+	defer db.Close()
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		work(db)
+		return
+	}
+	if len(os.Args) > 2 && os.Args[1] == "token" {
+		token(db, os.Args[2])
+		return
+	}
+	if len(os.Args) > 3 && os.Args[1] == "grant" {
+		grant(db, os.Args[2], models.Role(os.Args[3]))
+		return
+	}
+	serve(db)
+}
+
+func openDb() *gorm.DB {
 	db, err := gorm.Open("postgres", "host=localhost user=jobs dbname=jobs sslmode=disable password=jobs")
 	if err != nil {
 		panic(err)
 	}
-	defer db.Close()
+	db.AutoMigrate(&models.Job{}, &models.Run{}, &models.Attempt{}, &models.LogLine{},
+		&models.Pipeline{}, &models.PipelineRun{}, &models.QueueItem{}, &models.Worker{}, &models.Pool{}, &models.Team{}, &models.Artifact{}, &models.Secret{}, &models.Token{}, &models.Grant{}, &models.AuditEvent{}, &models.JobVersion{})
+	if err := models.ProtectAuditLog(db); err != nil {
+		panic(err)
+	}
+	return db
+}
+
+// schedulerLock is the advisory lock held by the one server that runs the
+// scheduler, so that each scheduled run is only started once.
+const schedulerLock = 7311
+
+// serve runs the HTTP server and the scheduler. The runs are executed by
+// the workers of `jobs worker`.
+func serve(db *gorm.DB) {
+	port := 5555
+	var router = mux.NewRouter().StrictSlash(true)
+	cipher := newCipher()
+	tokenRepo := models.NewPgTokenRepo(db)
+	auth := controllers.NewAuthenticator(tokenRepo, cipher)
+	grantRepo := models.NewPgGrantRepo(db)
+	access := models.NewAccess(grantRepo)
+	auditLog := models.NewPgAuditLog(db)
+	loggedRouter := handlers.LoggingHandler(os.Stdout, slowMiddleware(controllers.RequestID(auth.Middleware(router))))
 	jobRepo := models.NewPgJobRepo(db)
 	_, _ = jobRepo.Add(models.NewJob("One"))
 	_, _ = jobRepo.Add(models.NewJob("Two"))
-	setupRouter(router.PathPrefix("/jobs"), controllers.NewJobController(jobRepo))
+	runRepo := models.NewPgRunRepo(db)
+	logStore := newLogStore(db)
+	queue := models.NewPgQueue(db)
+	jobRunner := runner.NewRunner(runRepo, logStore, queue)
+	setupArtifactRouter(router, "/jobs/{slug}/runs/{id:[0-9]+}/artifacts",
+		controllers.NewArtifactController(jobRepo, runRepo, models.NewPgArtifactRepo(db), newBlobStore(), access))
+	setupRunRouter(router.PathPrefix("/jobs/{slug}/runs"),
+		controllers.NewRunController(jobRepo, runRepo, logStore, jobRunner, access, auditLog))
+	versionRepo := models.NewPgJobVersionRepo(db)
+	setupVersionRouter(router.PathPrefix("/jobs/{slug}"),
+		controllers.NewVersionController(jobRepo, versionRepo, access, auditLog))
+	jobController := controllers.NewJobController(jobRepo, versionRepo, access, auditLog)
+	jobController.Secrets = models.NewPgSecretRepo(db)
+	setupRouter(router.PathPrefix("/jobs"), jobController)
+	pipelineRepo := models.NewPgPipelineRepo(db)
+	pipelineRunRepo := models.NewPgPipelineRunRepo(db)
+	pipelineRunner := runner.NewPipelineRunner(jobRunner, jobRepo, pipelineRunRepo)
+	setupPipelineRunRouter(router.PathPrefix("/pipelines/{slug}/runs"),
+		controllers.NewPipelineRunController(pipelineRepo, pipelineRunRepo, pipelineRunner, jobRepo, access, auditLog))
+	setupRouter(router.PathPrefix("/pipelines"), controllers.NewPipelineController(pipelineRepo, jobRepo, access))
+	setupRouter(router.PathPrefix("/pools"), controllers.NewPoolController(models.NewPgPoolRepo(db), access))
+	setupRouter(router.PathPrefix("/teams"), controllers.NewTeamController(models.NewPgTeamRepo(db), access))
+	if cipher != nil {
+		setupRouter(router.PathPrefix("/secrets"), controllers.NewSecretController(models.NewPgSecretRepo(db), cipher, jobRepo, access, auditLog))
+		setupRouter(router.PathPrefix("/tokens"), controllers.NewTokenController(tokenRepo, cipher, auditLog))
+	}
+	setupRouter(router.PathPrefix("/grants"), controllers.NewGrantController(grantRepo, jobRepo, access, auditLog))
+	setupAuditRouter(router.PathPrefix("/audit"), controllers.NewAuditController(auditLog, access))
+	setupQueueRouter(router.PathPrefix("/queue"), controllers.NewQueueController(queue, jobRepo, access))
+	setupWorkerRouter(router.PathPrefix("/workers"), controllers.NewWorkerController(models.NewPgWorkerRepo(db), queue, jobRepo, access))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	sched := scheduler.NewScheduler(jobRepo, jobRunner)
+	sched.Lock = models.NewPgLock(db, schedulerLock)
+	go sched.Run(stop)
+	go pipelineRunner.Supervise(pipelineRepo, stop)
 
 	go func() {
 		log.Print("Profile server started on port 6060")
@@ -47,6 +125,136 @@ func main() {
 	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(port), loggedRouter))
 }
 
+// work executes queued runs until the process is interrupted or terminated.
+// Runs that are executing are then stopped, and requeued or failed.
+func work(db *gorm.DB) {
+	jobRunner := runner.NewRunner(models.NewPgRunRepo(db), newLogStore(db), models.NewPgQueue(db))
+	jobRunner.Artifacts = models.NewPgArtifactRepo(db)
+	jobRunner.Blobs = newBlobStore()
+	jobRunner.Secrets = models.NewPgSecretRepo(db)
+	jobRunner.Cipher = newCipher()
+	worker := runner.NewWorker(workerName(), jobRunner, models.NewPgJobRepo(db), models.NewPgWorkerRepo(db))
+	worker.Capacity = capacity()
+	worker.Labels = labels()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		worker.Run(stop)
+		close(done)
+	}()
+	log.Print("Worker ", worker.Name, " started with capacity ", worker.Capacity, " and labels ", worker.Labels)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	log.Print("Stopping worker on ", <-signals)
+	close(stop)
+	<-done
+}
+
+// token creates an API token for the user and prints it. This is how the
+// first token is made, as the API requires one.
+func token(db *gorm.DB, user string) {
+	t, err := models.NewToken("cli", user, nil, newCipher())
+	if err != nil {
+		panic(err)
+	}
+	if _, err := models.NewPgTokenRepo(db).Add(t); err != nil {
+		panic(err)
+	}
+	fmt.Println(t.Token)
+}
+
+// grant gives the user the role on all jobs. This is how the first admin
+// is made, as roles are granted by admins.
+func grant(db *gorm.DB, user string, role models.Role) {
+	g := &models.Grant{User: user, Role: role}
+	if err := g.Validate(); err != nil {
+		panic(err)
+	}
+	if _, err := models.NewPgGrantRepo(db).Add(g); err != nil {
+		panic(err)
+	}
+	fmt.Println("Granted", role, "to", user)
+}
+
+// capacity is the number of runs executed at the same time, set by
+// JOBS_CAPACITY.
+func capacity() int {
+	n, err := strconv.Atoi(os.Getenv("JOBS_CAPACITY"))
+	if err != nil || n < 1 {
+		return 4
+	}
+	return n
+}
+
+// labels are the labels of the worker, set by JOBS_LABELS as
+// "zone=a,pool=gpu". The os label defaults to the operating system.
+func labels() models.StringMap {
+	labels, err := models.ParseLabels(os.Getenv("JOBS_LABELS"))
+	if err != nil {
+		panic(err)
+	}
+	if _, ok := labels["os"]; !ok {
+		labels["os"] = runtime.GOOS
+	}
+	return labels
+}
+
+func workerName() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// newLogStore keeps run logs in the database, unless JOBS_LOG_DIR names a
+// directory to keep them in.
+func newLogStore(db *gorm.DB) models.LogStore {
+	dir := os.Getenv("JOBS_LOG_DIR")
+	if dir == "" {
+		return models.NewPgLogStore(db)
+	}
+	logStore, err := models.NewFsLogStore(dir)
+	if err != nil {
+		panic(err)
+	}
+	return logStore
+}
+
+// newBlobStore keeps artifacts in the S3 compatible bucket JOBS_S3_BUCKET at
+// JOBS_S3_ENDPOINT, if set, or else in the directory JOBS_ARTIFACT_DIR.
+func newBlobStore() models.BlobStore {
+	if endpoint := os.Getenv("JOBS_S3_ENDPOINT"); endpoint != "" {
+		region := os.Getenv("JOBS_S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return models.NewS3BlobStore(endpoint, region, os.Getenv("JOBS_S3_BUCKET"),
+			os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	}
+	dir := os.Getenv("JOBS_ARTIFACT_DIR")
+	if dir == "" {
+		dir = "artifacts"
+	}
+	blobStore, err := models.NewFsBlobStore(dir)
+	if err != nil {
+		panic(err)
+	}
+	return blobStore
+}
+
+// newCipher returns the cipher of the master key of secrets, JOBS_MASTER_KEY,
+// or nil if it is not set, which disables secrets.
+func newCipher() *models.Cipher {
+	key := os.Getenv("JOBS_MASTER_KEY")
+	if key == "" {
+		log.Warn("JOBS_MASTER_KEY is not set, secrets and /tokens are disabled")
+		return nil
+	}
+	cipher, err := models.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	return cipher
+}
+
 func panicHandler(output string) {
 	// output contains the full output (including stack traces) of the
 	// panic. Put it in a file or something.
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/andersjanmyr/jobs/controllers"
 	"github.com/andersjanmyr/jobs/models"
+	"github.com/andersjanmyr/jobs/runner"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
@@ -35,7 +42,7 @@ func TestJobsIndex(t *testing.T) {
 	w := httptest.NewRecorder()
 	router := mux.NewRouter()
 	jobRepo := models.NewMemJobRepo([]*models.Job{models.NewJob("One"), models.NewJob("Two")})
-	controller := controllers.NewJobController(jobRepo)
+	controller := controllers.NewJobController(jobRepo, models.NewMemJobVersionRepo(), adminAccess(), models.NewMemAuditLog())
 	setupRouter(router.PathPrefix("/"), controller)
 	router.ServeHTTP(w, req)
 
@@ -56,7 +63,7 @@ func TestJobsCreate(t *testing.T) {
 
 	w := httptest.NewRecorder()
 	router := mux.NewRouter()
-	controller := controllers.NewJobController(models.NewMemJobRepo([]*models.Job{}))
+	controller := controllers.NewJobController(models.NewMemJobRepo([]*models.Job{}), models.NewMemJobVersionRepo(), adminAccess(), models.NewMemAuditLog())
 	setupRouter(router.PathPrefix("/"), controller)
 	router.ServeHTTP(w, req)
 
@@ -66,6 +73,25 @@ func TestJobsCreate(t *testing.T) {
 	assert.Equal(t, "three", m["Slug"])
 }
 
+func TestJobsCreateExisting(t *testing.T) {
+	req, err := http.NewRequest("POST", "/", strings.NewReader(`{"Name": "one"}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router := mux.NewRouter()
+	jobRepo := models.NewMemJobRepo([]*models.Job{models.NewJob("One")})
+	controller := controllers.NewJobController(jobRepo, models.NewMemJobVersionRepo(), adminAccess(), models.NewMemAuditLog())
+	setupRouter(router.PathPrefix("/"), controller)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 409, w.Code)
+	assert.Equal(t, "Job one already exists\n", w.Body.String())
+	jobs, _ := jobRepo.Find(&models.JobQuery{})
+	assert.Equal(t, 1, len(jobs))
+}
+
 func TestJobsShow(t *testing.T) {
 	req, err := http.NewRequest("GET", "/one", nil)
 	if err != nil {
@@ -76,7 +102,7 @@ func TestJobsShow(t *testing.T) {
 	router := mux.NewRouter()
 	jobRepo := models.NewMemJobRepo([]*models.Job{models.NewJob("Zero"),
 		models.NewJob("One"), models.NewJob("Two")})
-	controller := controllers.NewJobController(jobRepo)
+	controller := controllers.NewJobController(jobRepo, models.NewMemJobVersionRepo(), adminAccess(), models.NewMemAuditLog())
 	setupRouter(router.PathPrefix("/"), controller)
 
 	router.ServeHTTP(w, req)
@@ -100,7 +126,7 @@ func TestJobsUpdate(t *testing.T) {
 	w := httptest.NewRecorder()
 	router := mux.NewRouter()
 	jobRepo := models.NewMemJobRepo([]*models.Job{models.NewJob("One"), models.NewJob("Two")})
-	controller := controllers.NewJobController(jobRepo)
+	controller := controllers.NewJobController(jobRepo, models.NewMemJobVersionRepo(), adminAccess(), models.NewMemAuditLog())
 	setupRouter(router.PathPrefix("/"), controller)
 
 	router.ServeHTTP(w, req)
@@ -119,7 +145,7 @@ func TestJobsDelete(t *testing.T) {
 	w := httptest.NewRecorder()
 	router := mux.NewRouter()
 	jobRepo := models.NewMemJobRepo([]*models.Job{models.NewJob("Zero"), models.NewJob("One"), models.NewJob("Two")})
-	controller := controllers.NewJobController(jobRepo)
+	controller := controllers.NewJobController(jobRepo, models.NewMemJobVersionRepo(), adminAccess(), models.NewMemAuditLog())
 	setupRouter(router.PathPrefix("/"), controller)
 
 	router.ServeHTTP(w, req)
@@ -127,6 +153,1448 @@ func TestJobsDelete(t *testing.T) {
 	assert.Equal(t, 200, w.Code)
 	m := jsonToMap(w)
 	assert.Equal(t, "One", m["Name"])
-	jobs, _ := jobRepo.Find()
+	jobs, _ := jobRepo.Find(&models.JobQuery{})
 	assert.Equal(t, 2, len(jobs))
 }
+
+func TestJobsCreateCommand(t *testing.T) {
+	job := strings.NewReader(`{
+		"Name": "Greet",
+		"Command": "echo",
+		"Args": ["hello", "world"],
+		"Env": {"LANG": "C"},
+		"Dir": "/tmp",
+		"Timeout": "30s",
+		"KillGrace": "5s"
+	}`)
+
+	req, err := http.NewRequest("POST", "/", job)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router := mux.NewRouter()
+	jobRepo := models.NewMemJobRepo([]*models.Job{})
+	controller := controllers.NewJobController(jobRepo, models.NewMemJobVersionRepo(), adminAccess(), models.NewMemAuditLog())
+	setupRouter(router.PathPrefix("/"), controller)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 201, w.Code)
+
+	req, _ = http.NewRequest("GET", "/greet", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "echo", m["Command"])
+	assert.Equal(t, []interface{}{"hello", "world"}, m["Args"])
+	assert.Equal(t, map[string]interface{}{"LANG": "C"}, m["Env"])
+	assert.Equal(t, "/tmp", m["Dir"])
+	assert.Equal(t, "30s", m["Timeout"])
+	assert.Equal(t, "5s", m["KillGrace"])
+}
+
+func TestJobsCreateInvalid(t *testing.T) {
+	job := strings.NewReader(`{
+		"Name": "Bad",
+		"Dir": "relative"
+	}`)
+
+	req, err := http.NewRequest("POST", "/", job)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router := mux.NewRouter()
+	jobRepo := models.NewMemJobRepo([]*models.Job{})
+	controller := controllers.NewJobController(jobRepo, models.NewMemJobVersionRepo(), adminAccess(), models.NewMemAuditLog())
+	setupRouter(router.PathPrefix("/"), controller)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 422, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "Working dir must be an absolute path: relative", m["Message"])
+	jobs, _ := jobRepo.Find(&models.JobQuery{})
+	assert.Equal(t, 0, len(jobs))
+}
+
+// adminAccess returns access where anonymous, the user of requests without
+// an X-User header, is an admin of everything.
+func adminAccess() *models.Access {
+	return models.NewAccess(models.NewMemGrantRepo([]*models.Grant{{User: "anonymous", Role: models.Admin}}))
+}
+
+func newQueue() *models.MemQueue {
+	return models.NewMemQueue(models.NewMemPoolRepo([]*models.Pool{}), models.NewMemTeamRepo([]*models.Team{}))
+}
+
+// startRunner returns a runner with a worker that executes its runs for
+// the rest of the tests.
+func startRunner(jobRepo models.JobRepo, runRepo models.RunRepo, logStore models.LogStore) *runner.Runner {
+	jobRunner := runner.NewRunner(runRepo, logStore, newQueue())
+	worker := runner.NewWorker("test", jobRunner, jobRepo, models.NewMemWorkerRepo())
+	worker.PollInterval = 10 * time.Millisecond
+	go worker.Run(make(chan struct{}))
+	return jobRunner
+}
+
+func setupRunTest(jobs []*models.Job) (*mux.Router, *models.MemRunRepo, *models.MemLogStore) {
+	router := mux.NewRouter()
+	jobRepo := models.NewMemJobRepo(jobs)
+	runRepo := models.NewMemRunRepo([]*models.Run{})
+	logStore := models.NewMemLogStore()
+	jobRunner := startRunner(jobRepo, runRepo, logStore)
+	setupRunRouter(router.PathPrefix("/jobs/{slug}/runs"),
+		controllers.NewRunController(jobRepo, runRepo, logStore, jobRunner, adminAccess(), models.NewMemAuditLog()))
+	setupRouter(router.PathPrefix("/jobs"), controllers.NewJobController(jobRepo, models.NewMemJobVersionRepo(), adminAccess(), models.NewMemAuditLog()))
+	return router, runRepo, logStore
+}
+
+func echoJob(name string) *models.Job {
+	job := models.NewJob(name)
+	job.Command = "echo"
+	job.Args = models.StringList{"hello"}
+	return job
+}
+
+func TestRunsCreate(t *testing.T) {
+	req, err := http.NewRequest("POST", "/jobs/one/runs/", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, _, _ := setupRunTest([]*models.Job{echoJob("One")})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 201, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "one", m["JobSlug"])
+	assert.Equal(t, "queued", m["State"])
+	assert.NotZero(t, m["ID"])
+}
+
+func paramJob(name string) *models.Job {
+	job := echoJob(name)
+	job.Args = models.StringList{"{{.target}}"}
+	job.Params = models.Params{
+		{Name: "target", Type: models.ParamString, Required: true},
+		{Name: "count", Type: models.ParamInt, Default: 1},
+	}
+	return job
+}
+
+func TestRunsCreateParams(t *testing.T) {
+	body := strings.NewReader(`{"Params": {"target": "world", "count": 2}}`)
+	req, err := http.NewRequest("POST", "/jobs/one/runs/", body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, _, _ := setupRunTest([]*models.Job{paramJob("One")})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 201, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, map[string]interface{}{"target": "world", "count": "2"}, m["Params"])
+}
+
+func TestRunsCreateInvalidParams(t *testing.T) {
+	body := strings.NewReader(`{"Params": {"count": "two", "color": "red"}}`)
+	req, err := http.NewRequest("POST", "/jobs/one/runs/", body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, runRepo, _ := setupRunTest([]*models.Job{paramJob("One")})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 422, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "Invalid params", m["Message"])
+	assert.Equal(t, map[string]interface{}{
+		"target": "is required",
+		"count":  "must be an integer",
+		"color":  "is not a param of the job",
+	}, m["Fields"])
+	runs, _ := runRepo.Find("one")
+	assert.Equal(t, 0, len(runs))
+}
+
+func TestRunsCreateMissingJob(t *testing.T) {
+	req, err := http.NewRequest("POST", "/jobs/missing/runs/", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, _, _ := setupRunTest([]*models.Job{echoJob("One")})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestRunsIndex(t *testing.T) {
+	req, err := http.NewRequest("GET", "/jobs/one/runs/", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, runRepo, _ := setupRunTest([]*models.Job{echoJob("One"), echoJob("Two")})
+	_, _ = runRepo.Add(&models.Run{JobSlug: "one", State: models.Succeeded})
+	_, _ = runRepo.Add(&models.Run{JobSlug: "two", State: models.Succeeded})
+	_, _ = runRepo.Add(&models.Run{JobSlug: "one", State: models.Failed})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	s := jsonToSlice(w)
+	assert.Equal(t, 2, len(s))
+}
+
+func TestRunsShow(t *testing.T) {
+	req, err := http.NewRequest("GET", "/jobs/one/runs/1", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, runRepo, _ := setupRunTest([]*models.Job{echoJob("One")})
+	_, _ = runRepo.Add(&models.Run{JobSlug: "one", State: models.Failed, ExitCode: 2})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "failed", m["State"])
+	assert.Equal(t, float64(2), m["ExitCode"])
+}
+
+func TestRunsShowOtherJob(t *testing.T) {
+	req, err := http.NewRequest("GET", "/jobs/two/runs/1", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, runRepo, _ := setupRunTest([]*models.Job{echoJob("One"), echoJob("Two")})
+	_, _ = runRepo.Add(&models.Run{JobSlug: "one"})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestRunsLog(t *testing.T) {
+	req, err := http.NewRequest("GET", "/jobs/one/runs/1/log?offset=1&limit=2", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, runRepo, logStore := setupRunTest([]*models.Job{echoJob("One")})
+	run, _ := runRepo.Add(&models.Run{JobSlug: "one", State: models.Succeeded})
+	for i, text := range []string{"a", "b", "c", "d"} {
+		_ = logStore.Append(&models.LogLine{RunID: run.ID, Seq: i, Stream: models.Stdout, Text: text})
+	}
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	s := jsonToSlice(w)
+	assert.Equal(t, 2, len(s))
+	assert.Equal(t, "b", s[0]["Text"])
+	assert.Equal(t, "stdout", s[0]["Stream"])
+	assert.Equal(t, float64(2), s[1]["Seq"])
+}
+
+func TestRunsLogInvalidOffset(t *testing.T) {
+	req, err := http.NewRequest("GET", "/jobs/one/runs/1/log?offset=x", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, runRepo, _ := setupRunTest([]*models.Job{echoJob("One")})
+	_, _ = runRepo.Add(&models.Run{JobSlug: "one"})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestRunsLogStream(t *testing.T) {
+	req, err := http.NewRequest("GET", "/jobs/one/runs/1/log/stream", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "0")
+
+	w := httptest.NewRecorder()
+	router, runRepo, logStore := setupRunTest([]*models.Job{echoJob("One")})
+	run, _ := runRepo.Add(&models.Run{JobSlug: "one", State: models.Failed, ExitCode: 4})
+	for i, text := range []string{"a", "b", "c"} {
+		_ = logStore.Append(&models.LogLine{RunID: run.ID, Seq: i, Stream: models.Stdout, Text: text})
+	}
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	events := strings.Split(strings.TrimSpace(w.Body.String()), "\n\n")
+	assert.Equal(t, 3, len(events))
+	assert.True(t, strings.HasPrefix(events[0], "id: 1\nevent: log\ndata: {"))
+	assert.Contains(t, events[0], `"Text":"b"`)
+	assert.True(t, strings.HasPrefix(events[1], "id: 2\nevent: log\n"))
+	assert.True(t, strings.HasPrefix(events[2], "event: end\ndata: {"))
+	assert.Contains(t, events[2], `"State":"failed"`)
+	assert.Contains(t, events[2], `"ExitCode":4`)
+}
+
+func TestRunsLogStreamFollows(t *testing.T) {
+	job := models.NewJob("One")
+	job.Command = "sh"
+	job.Args = models.StringList{"-c", "echo one; sleep 0.3; echo two"}
+	router, _, _ := setupRunTest([]*models.Job{job})
+
+	req, _ := http.NewRequest("POST", "/jobs/one/runs/", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 201, w.Code)
+
+	req, _ = http.NewRequest("GET", "/jobs/one/runs/1/log/stream", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	body := w.Body.String()
+	assert.Contains(t, body, `"Text":"one"`)
+	assert.Contains(t, body, `"Text":"two"`)
+	assert.Contains(t, body, `"State":"succeeded"`)
+	assert.True(t, strings.Index(body, `"Text":"two"`) < strings.Index(body, "event: end"))
+}
+
+func TestJobsShowSchedule(t *testing.T) {
+	req, err := http.NewRequest("GET", "/one", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router := mux.NewRouter()
+	job := models.NewJob("One")
+	job.Schedule = "@hourly"
+	last := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+	next := last.Add(time.Hour)
+	job.LastRunAt, job.NextRunAt = &last, &next
+	controller := controllers.NewJobController(models.NewMemJobRepo([]*models.Job{job}), models.NewMemJobVersionRepo(), adminAccess(), models.NewMemAuditLog())
+	setupRouter(router.PathPrefix("/"), controller)
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "@hourly", m["Schedule"])
+	assert.Equal(t, "2017-06-01T10:00:00Z", m["LastRunAt"])
+	assert.Equal(t, "2017-06-01T11:00:00Z", m["NextRunAt"])
+}
+
+func setupPipelineTest(jobs []*models.Job, pipelines []*models.Pipeline) (*mux.Router, *models.MemPipelineRepo) {
+	router := mux.NewRouter()
+	jobRepo := models.NewMemJobRepo(jobs)
+	runRepo := models.NewMemRunRepo([]*models.Run{})
+	pipelineRepo := models.NewMemPipelineRepo(pipelines)
+	pipelineRunRepo := models.NewMemPipelineRunRepo([]*models.PipelineRun{})
+	jobRunner := startRunner(jobRepo, runRepo, models.NewMemLogStore())
+	pipelineRunner := runner.NewPipelineRunner(jobRunner, jobRepo, pipelineRunRepo)
+	pipelineRunner.PollInterval = 10 * time.Millisecond
+	setupPipelineRunRouter(router.PathPrefix("/pipelines/{slug}/runs"),
+		controllers.NewPipelineRunController(pipelineRepo, pipelineRunRepo, pipelineRunner, jobRepo, adminAccess(), models.NewMemAuditLog()))
+	setupRouter(router.PathPrefix("/pipelines"), controllers.NewPipelineController(pipelineRepo, jobRepo, adminAccess()))
+	return router, pipelineRepo
+}
+
+func TestPipelinesCreate(t *testing.T) {
+	pipeline := strings.NewReader(`{
+		"Name": "Release",
+		"Nodes": [
+			{"Name": "build", "Job": "one"},
+			{"Name": "ship", "Job": "two", "depends_on": ["build"]}
+		]
+	}`)
+
+	req, err := http.NewRequest("POST", "/pipelines/", pipeline)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, pipelineRepo := setupPipelineTest([]*models.Job{echoJob("One"), echoJob("Two")}, nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 201, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "release", m["Slug"])
+	p, _ := pipelineRepo.FindOne("release")
+	assert.NotNil(t, p)
+}
+
+func TestPipelinesCreateCycle(t *testing.T) {
+	pipeline := strings.NewReader(`{
+		"Name": "Loop",
+		"Nodes": [
+			{"Name": "a", "Job": "one", "depends_on": ["b"]},
+			{"Name": "b", "Job": "one", "depends_on": ["a"]}
+		]
+	}`)
+
+	req, err := http.NewRequest("POST", "/pipelines/", pipeline)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, pipelineRepo := setupPipelineTest([]*models.Job{echoJob("One")}, nil)
+	router.ServeHTTP(w, req)
+
+	assert.NotEqual(t, 201, w.Code)
+	assert.Contains(t, w.Body.String(), "cycle")
+	pipelines, _ := pipelineRepo.Find()
+	assert.Equal(t, 0, len(pipelines))
+}
+
+func TestPipelinesUpdateUnknownJob(t *testing.T) {
+	pipeline := strings.NewReader(`{
+		"Nodes": [{"Name": "a", "Job": "missing"}]
+	}`)
+
+	req, err := http.NewRequest("PUT", "/pipelines/release", pipeline)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, _ := setupPipelineTest([]*models.Job{echoJob("One")},
+		[]*models.Pipeline{models.NewPipeline("Release", models.PipelineNode{Name: "a", Job: "one"})})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestPipelineRuns(t *testing.T) {
+	pipeline := models.NewPipeline("Release",
+		models.PipelineNode{Name: "build", Job: "one"},
+		models.PipelineNode{Name: "ship", Job: "two", DependsOn: []string{"build"}})
+	router, _ := setupPipelineTest([]*models.Job{echoJob("One"), echoJob("Two")}, []*models.Pipeline{pipeline})
+
+	req, _ := http.NewRequest("POST", "/pipelines/release/runs/", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 201, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "release", m["PipelineSlug"])
+
+	var state interface{}
+	for i := 0; i < 50 && state != "succeeded"; i++ {
+		time.Sleep(100 * time.Millisecond)
+		req, _ = http.NewRequest("GET", "/pipelines/release/runs/1", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		state = jsonToMap(w)["State"]
+	}
+	assert.Equal(t, "succeeded", state)
+	nodes := jsonToMap(w)["Nodes"].([]interface{})
+	assert.Equal(t, "succeeded", nodes[1].(map[string]interface{})["State"])
+}
+
+func TestRunsCancel(t *testing.T) {
+	job := models.NewJob("One")
+	job.Command = "sleep"
+	job.Args = models.StringList{"10"}
+	job.Owner = "alice"
+	router, runRepo, _ := setupRunTest([]*models.Job{job})
+
+	req, _ := http.NewRequest("POST", "/jobs/one/runs/", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 201, w.Code)
+
+	for i := 0; i < 50; i++ {
+		if run, _ := runRepo.FindOne(1); run.State == models.Running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	req, _ = http.NewRequest("DELETE", "/jobs/one/runs/1", nil)
+	req.Header.Set("X-User", "alice")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "cancelled", m["State"])
+	assert.Equal(t, "alice", m["CancelledBy"])
+}
+
+func TestRunsCancelFinished(t *testing.T) {
+	req, err := http.NewRequest("DELETE", "/jobs/one/runs/1", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, runRepo, _ := setupRunTest([]*models.Job{echoJob("One")})
+	_, _ = runRepo.Add(&models.Run{JobSlug: "one", State: models.Succeeded})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 409, w.Code)
+}
+
+func TestWorkersIndex(t *testing.T) {
+	req, err := http.NewRequest("GET", "/workers/", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router := mux.NewRouter()
+	workerRepo := models.NewMemWorkerRepo()
+	worker := &models.Worker{Name: "a", Labels: models.StringMap{"os": "linux"}, Capacity: 2}
+	_ = workerRepo.Register(worker, time.Minute)
+	queue := newQueue()
+	run := &models.Run{JobSlug: "one"}
+	run.ID = 7
+	_ = queue.Enqueue(models.NewJob("One"), run)
+	_, _ = queue.Claim(worker, time.Minute)
+	jobRepo := models.NewMemJobRepo([]*models.Job{models.NewJob("One")})
+	setupWorkerRouter(router.PathPrefix("/workers"), controllers.NewWorkerController(workerRepo, queue, jobRepo, adminAccess()))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	var workers []models.Worker
+	_ = json.Unmarshal(w.Body.Bytes(), &workers)
+	assert.Equal(t, 1, len(workers))
+	assert.Equal(t, "linux", workers[0].Labels["os"])
+	assert.Equal(t, 2, workers[0].Capacity)
+	assert.Equal(t, []uint{7}, workers[0].Runs)
+	assert.NotZero(t, workers[0].HeartbeatAt)
+}
+
+func TestPoolsCreate(t *testing.T) {
+	req, err := http.NewRequest("POST", "/pools/", strings.NewReader(`{"Name": "Reporting", "Size": 4}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router := mux.NewRouter()
+	poolRepo := models.NewMemPoolRepo([]*models.Pool{})
+	setupRouter(router.PathPrefix("/pools"), controllers.NewPoolController(poolRepo, adminAccess()))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 201, w.Code)
+	p, _ := poolRepo.FindOne("reporting")
+	assert.Equal(t, 4, p.Size)
+}
+
+func TestRunsShowWaitingOn(t *testing.T) {
+	job := models.NewJob("One")
+	job.Command = "sleep"
+	job.Args = models.StringList{"10"}
+	job.MaxConcurrency = 1
+	router, runRepo, _ := setupRunTest([]*models.Job{job})
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "/jobs/one/runs/", nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	for i := 0; i < 50; i++ {
+		if run, _ := runRepo.FindOne(1); run.State == models.Running {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	req, _ := http.NewRequest("GET", "/jobs/one/runs/2", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "queued", m["State"])
+	assert.Equal(t, []interface{}{"job one (1/1)"}, m["WaitingOn"])
+
+	for _, id := range []string{"1", "2"} {
+		req, _ = http.NewRequest("DELETE", "/jobs/one/runs/"+id, nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func TestQueueIndex(t *testing.T) {
+	req, err := http.NewRequest("GET", "/queue/", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router := mux.NewRouter()
+	queue := newQueue()
+	runRepo := models.NewMemRunRepo([]*models.Run{})
+	jobRunner := runner.NewRunner(runRepo, models.NewMemLogStore(), queue)
+	low, high := echoJob("Low"), echoJob("High")
+	high.Priority = 10
+	_, _ = jobRunner.Start(low)
+	_, _ = jobRunner.Start(high)
+	jobRepo := models.NewMemJobRepo([]*models.Job{low, high})
+	setupQueueRouter(router.PathPrefix("/queue"), controllers.NewQueueController(queue, jobRepo, adminAccess()))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	items := jsonToSlice(w)
+	assert.Equal(t, 2, len(items))
+	assert.Equal(t, "high", items[0]["JobSlug"])
+	assert.Equal(t, float64(1), items[0]["Position"])
+	assert.Equal(t, "low", items[1]["JobSlug"])
+	assert.Equal(t, float64(2), items[1]["Position"])
+}
+
+func TestRunsCreatePriority(t *testing.T) {
+	req, err := http.NewRequest("POST", "/jobs/one/runs/?priority=-5", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, _, _ := setupRunTest([]*models.Job{echoJob("One")})
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 201, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, float64(-5), m["Priority"])
+}
+
+func setupArtifactTest() (*mux.Router, *models.Run) {
+	router, runRepo, logStore := mux.NewRouter(), models.NewMemRunRepo([]*models.Run{}), models.NewMemLogStore()
+	jobRepo := models.NewMemJobRepo([]*models.Job{echoJob("One")})
+	artifactRepo, blobStore := models.NewMemArtifactRepo(), models.NewMemBlobStore()
+	run, _ := runRepo.Add(&models.Run{JobSlug: "one", State: models.Succeeded})
+	for name, content := range map[string]string{"report.html": "<h1>Report</h1>", "dist/app": "binary"} {
+		artifact, _ := artifactRepo.Add(&models.Artifact{RunID: run.ID, Name: name, Size: int64(len(content)), SHA256: "sum"})
+		_ = blobStore.Put(artifact.Key(), strings.NewReader(content), artifact.Size)
+	}
+	setupArtifactRouter(router, "/jobs/{slug}/runs/{id:[0-9]+}/artifacts",
+		controllers.NewArtifactController(jobRepo, runRepo, artifactRepo, blobStore, adminAccess()))
+	setupRunRouter(router.PathPrefix("/jobs/{slug}/runs"),
+		controllers.NewRunController(jobRepo, runRepo, logStore, runner.NewRunner(runRepo, logStore, newQueue()), adminAccess(), models.NewMemAuditLog()))
+	return router, run
+}
+
+func TestArtifactsIndex(t *testing.T) {
+	router, run := setupArtifactTest()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/jobs/one/runs/%d/artifacts/", run.ID), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	s := jsonToSlice(w)
+	assert.Equal(t, 2, len(s))
+	assert.Equal(t, "sum", s[0]["SHA256"])
+}
+
+func TestArtifactsShow(t *testing.T) {
+	router, run := setupArtifactTest()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/jobs/one/runs/%d/artifacts/report.html", run.ID), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "<h1>Report</h1>", w.Body.String())
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "sum", w.Header().Get("X-Checksum-Sha256"))
+}
+
+func TestArtifactsShowMissing(t *testing.T) {
+	router, run := setupArtifactTest()
+	for _, path := range []string{
+		fmt.Sprintf("/jobs/one/runs/%d/artifacts/missing", run.ID),
+		fmt.Sprintf("/jobs/two/runs/%d/artifacts/report.html", run.ID),
+	} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 404, w.Code)
+	}
+}
+
+func TestArtifactsArchive(t *testing.T) {
+	router, run := setupArtifactTest()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/jobs/one/runs/%d/artifacts.tar.gz", run.ID), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	gz, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+	archive := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		b, _ := ioutil.ReadAll(archive)
+		files[header.Name] = string(b)
+	}
+	assert.Equal(t, map[string]string{"report.html": "<h1>Report</h1>", "dist/app": "binary"}, files)
+}
+
+func setupSecretTest() (*mux.Router, *models.MemSecretRepo) {
+	router := mux.NewRouter()
+	cipher, _ := models.NewCipher("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	secretRepo := models.NewMemSecretRepo([]*models.Secret{})
+	setupRouter(router.PathPrefix("/secrets"), controllers.NewSecretController(secretRepo, cipher, models.NewMemJobRepo([]*models.Job{}), adminAccess(), models.NewMemAuditLog()))
+	return router, secretRepo
+}
+
+func TestSecretsCreate(t *testing.T) {
+	secret := strings.NewReader(`{"Name": "Token", "Job": "one", "Env": "TOKEN", "Value": "s3cr3t"}`)
+	req, err := http.NewRequest("POST", "/secrets/", secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, secretRepo := setupSecretTest()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 201, w.Code)
+	assert.NotContains(t, w.Body.String(), "s3cr3t")
+	m := jsonToMap(w)
+	assert.Equal(t, "token", m["Slug"])
+	assert.NotContains(t, m, "Value")
+	assert.NotContains(t, m, "Ciphertext")
+	s, _ := secretRepo.FindOne("token")
+	assert.NotEmpty(t, s.Ciphertext)
+	assert.NotContains(t, s.Ciphertext, "s3cr3t")
+}
+
+func TestSecretsCreateWithoutValue(t *testing.T) {
+	secret := strings.NewReader(`{"Name": "Token", "Job": "one", "Env": "TOKEN"}`)
+	req, err := http.NewRequest("POST", "/secrets/", secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	router, _ := setupSecretTest()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 422, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, map[string]interface{}{"Value": "is required"}, m["Fields"])
+}
+
+func TestSecretsCreateDuplicate(t *testing.T) {
+	router, _ := setupSecretTest()
+	w := send(router, "POST", "/secrets/", `{"Name": "Token", "Job": "one", "Env": "TOKEN", "Value": "a"}`)
+	assert.Equal(t, 201, w.Code)
+
+	w = send(router, "POST", "/secrets/", `{"Name": "Token", "Team": "infra", "Env": "TOKEN", "Value": "b"}`)
+
+	assert.Equal(t, 409, w.Code)
+	assert.Equal(t, "Secret token already exists\n", w.Body.String())
+}
+
+func TestJobsDeleteSecrets(t *testing.T) {
+	router := mux.NewRouter()
+	secretRepo := models.NewMemSecretRepo([]*models.Secret{
+		{Slug: "token", Job: "one", Env: "TOKEN"},
+		{Slug: "key", Team: "infra", Env: "KEY"},
+	})
+	controller := controllers.NewJobController(models.NewMemJobRepo([]*models.Job{models.NewJob("One")}), models.NewMemJobVersionRepo(), adminAccess(), models.NewMemAuditLog())
+	controller.Secrets = secretRepo
+	setupRouter(router.PathPrefix("/jobs"), controller)
+
+	w := send(router, "DELETE", "/jobs/one", "")
+
+	assert.Equal(t, 200, w.Code)
+	secrets, _ := secretRepo.Find()
+	assert.Equal(t, 1, len(secrets))
+	assert.Equal(t, "key", secrets[0].Slug)
+}
+
+func TestSecretsUpdateKeepsValue(t *testing.T) {
+	router, secretRepo := setupSecretTest()
+	for _, body := range []string{
+		`{"Name": "Token", "Job": "one", "Env": "TOKEN", "Value": "s3cr3t"}`,
+		`{"Name": "Token", "Team": "ops", "Env": "API_TOKEN"}`,
+	} {
+		req, err := http.NewRequest("PUT", "/secrets/token", strings.NewReader(body))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 200, w.Code)
+	}
+	s, _ := secretRepo.FindOne("token")
+	assert.Equal(t, "ops", s.Team)
+	assert.Equal(t, "", s.Job)
+	assert.Equal(t, "API_TOKEN", s.Env)
+	cipher, _ := models.NewCipher("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	value, err := s.Open(cipher)
+	assert.Nil(t, err)
+	assert.Equal(t, "s3cr3t", value)
+}
+
+func setupAuthTest() (http.Handler, *models.MemTokenRepo, *models.Token) {
+	cipher, _ := models.NewCipher("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	tokenRepo := models.NewMemTokenRepo([]*models.Token{})
+	token, _ := models.NewToken("ci", "alice", nil, cipher)
+	_, _ = tokenRepo.Add(token)
+	router := mux.NewRouter()
+	router.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		body := []byte{}
+		if r.Body != nil {
+			body, _ = ioutil.ReadAll(r.Body)
+		}
+		w.Write([]byte(r.Header.Get("X-User") + " " + string(body)))
+	})
+	setupRouter(router.PathPrefix("/tokens"), controllers.NewTokenController(tokenRepo, cipher, models.NewMemAuditLog()))
+	return controllers.NewAuthenticator(tokenRepo, cipher).Middleware(router), tokenRepo, token
+}
+
+func signedRequest(method, path, body, token string, date time.Time) *http.Request {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	keyID, secret, _ := models.SplitToken(token)
+	d := date.UTC().Format(models.SignatureDateFormat)
+	req.Header.Set("X-Jobs-Date", d)
+	req.Header.Set("Authorization", fmt.Sprintf("JOBS-HMAC-SHA256 Credential=%s, Signature=%s",
+		keyID, models.Signature(secret, method, req.URL.RequestURI(), d, []byte(body))))
+	return req
+}
+
+func TestAuthBearer(t *testing.T) {
+	handler, _, token := setupAuthTest()
+	req, err := http.NewRequest("GET", "/whoami", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("X-User", "mallory")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "alice ", w.Body.String())
+}
+
+func TestAuthRejected(t *testing.T) {
+	handler, tokenRepo, token := setupAuthTest()
+	keyID, _, _ := models.SplitToken(token.Token)
+	expired, _ := models.NewToken("old", "bob", nil, nil)
+	past := time.Now().Add(-time.Minute)
+	expired.ExpiresAt = &past
+	_, _ = tokenRepo.Add(expired)
+	cases := map[string]string{
+		"":                                     "Authorization required\n",
+		"Basic YWxpY2U6cGFzcw==":               "Unsupported authorization scheme: Basic\n",
+		"Bearer " + keyID + ".wrong":           "Invalid token\n",
+		"Bearer unknown.secret":                "Invalid token\n",
+		"Bearer " + expired.Token:              "Token has expired\n",
+		"JOBS-HMAC-SHA256 Credential=" + keyID: "Invalid X-Jobs-Date: \"\"\n",
+	}
+	for auth, msg := range cases {
+		req, err := http.NewRequest("GET", "/whoami", nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		req.Header.Set("Authorization", auth)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, 401, w.Code, auth)
+		assert.Equal(t, msg, w.Body.String(), auth)
+	}
+}
+
+func TestAuthSigned(t *testing.T) {
+	handler, _, token := setupAuthTest()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, signedRequest("POST", "/whoami?a=1", `{"x": 1}`, token.Token, time.Now()))
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `alice {"x": 1}`, w.Body.String())
+}
+
+func TestAuthSignedRejected(t *testing.T) {
+	handler, _, token := setupAuthTest()
+	tampered := signedRequest("POST", "/whoami", `{"x": 1}`, token.Token, time.Now())
+	tampered.Body = ioutil.NopCloser(strings.NewReader(`{"x": 2}`))
+	for req, msg := range map[*http.Request]string{
+		tampered: "Invalid signature\n",
+		signedRequest("GET", "/whoami", "", token.Token, time.Now().Add(-10*time.Minute)): "Request date is too far from the server time",
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, 401, w.Code)
+		assert.Contains(t, w.Body.String(), msg)
+	}
+}
+
+func TestTokensCreateAndRevoke(t *testing.T) {
+	handler, _, token := setupAuthTest()
+	req, err := http.NewRequest("POST", "/tokens/", strings.NewReader(`{"Name": "deploy", "User": "root"}`))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, 201, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "alice", m["User"])
+	assert.NotContains(t, m, "Hash")
+	created := m["Token"].(string)
+
+	req, _ = http.NewRequest("DELETE", "/tokens/"+m["KeyID"].(string), nil)
+	req.Header.Set("Authorization", "Bearer "+created)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, jsonToMap(w), "Token")
+
+	req, _ = http.NewRequest("GET", "/tokens/", nil)
+	req.Header.Set("Authorization", "Bearer "+created)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+	assert.Equal(t, "Token has been revoked\n", w.Body.String())
+}
+
+func setupAccessTest(grants []*models.Grant) (*mux.Router, *models.MemJobRepo) {
+	router := mux.NewRouter()
+	infra, data := echoJob("Deploy"), echoJob("Report")
+	infra.Team, data.Team = "infra", "data"
+	jobRepo := models.NewMemJobRepo([]*models.Job{infra, data})
+	runRepo, logStore := models.NewMemRunRepo([]*models.Run{}), models.NewMemLogStore()
+	grantRepo := models.NewMemGrantRepo(grants)
+	access := models.NewAccess(grantRepo)
+	setupRunRouter(router.PathPrefix("/jobs/{slug}/runs"),
+		controllers.NewRunController(jobRepo, runRepo, logStore, runner.NewRunner(runRepo, logStore, newQueue()), access, models.NewMemAuditLog()))
+	setupRouter(router.PathPrefix("/jobs"), controllers.NewJobController(jobRepo, models.NewMemJobVersionRepo(), access, models.NewMemAuditLog()))
+	setupRouter(router.PathPrefix("/grants"), controllers.NewGrantController(grantRepo, jobRepo, access, models.NewMemAuditLog()))
+	return router, jobRepo
+}
+
+func userRequest(method, path, body, user string) *http.Request {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("X-User", user)
+	return req
+}
+
+func TestJobsIndexVisible(t *testing.T) {
+	router, _ := setupAccessTest([]*models.Grant{{User: "alice", Role: models.Viewer, Team: "infra"}})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("GET", "/jobs/", "", "alice"))
+
+	assert.Equal(t, 200, w.Code)
+	jobs := jsonToSlice(w)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "deploy", jobs[0]["Slug"])
+}
+
+func TestQueueAndWorkersVisible(t *testing.T) {
+	router, jobRepo := setupAccessTest([]*models.Grant{{User: "alice", Role: models.Viewer, Team: "infra"}})
+	access := models.NewAccess(models.NewMemGrantRepo([]*models.Grant{{User: "alice", Role: models.Viewer, Team: "infra"}}))
+	queue, workerRepo := newQueue(), models.NewMemWorkerRepo()
+	worker := &models.Worker{Name: "a", Capacity: 4}
+	_ = workerRepo.Register(worker, time.Minute)
+	for i, slug := range []string{"deploy", "report", "deploy", "report"} {
+		job, _ := jobRepo.FindOne(slug)
+		run := &models.Run{JobSlug: slug}
+		run.ID = uint(i + 1)
+		_ = queue.Enqueue(job, run)
+	}
+	_, _ = queue.Claim(worker, time.Minute)
+	_, _ = queue.Claim(worker, time.Minute)
+	setupQueueRouter(router.PathPrefix("/queue"), controllers.NewQueueController(queue, jobRepo, access))
+	setupWorkerRouter(router.PathPrefix("/workers"), controllers.NewWorkerController(workerRepo, queue, jobRepo, access))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("GET", "/queue/", "", "alice"))
+	assert.Equal(t, 200, w.Code)
+	items := jsonToSlice(w)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "deploy", items[0]["JobSlug"])
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("GET", "/workers/", "", "alice"))
+	assert.Equal(t, 200, w.Code)
+	var workers []models.Worker
+	_ = json.Unmarshal(w.Body.Bytes(), &workers)
+	assert.Equal(t, []uint{1}, workers[0].Runs)
+}
+
+func TestJobsForbidden(t *testing.T) {
+	router, _ := setupAccessTest([]*models.Grant{{User: "alice", Role: models.Viewer, Team: "infra"}})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("PUT", "/jobs/deploy", `{"Command": "rm"}`, "alice"))
+
+	assert.Equal(t, 403, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	m := jsonToMap(w)
+	assert.Equal(t, "alice needs the editor role on job deploy", m["Message"])
+	assert.Equal(t, "editor", m["Role"])
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("GET", "/jobs/report", "", "alice"))
+	assert.Equal(t, 403, w.Code)
+}
+
+func TestJobsCreateOwner(t *testing.T) {
+	router, jobRepo := setupAccessTest([]*models.Grant{{User: "alice", Role: models.Editor, Team: "infra"}})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("POST", "/jobs/", `{"Name": "Build", "Team": "infra"}`, "alice"))
+
+	assert.Equal(t, 201, w.Code)
+	assert.Equal(t, "alice", jsonToMap(w)["Owner"])
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("POST", "/jobs/", `{"Name": "Load", "Team": "data"}`, "alice"))
+	assert.Equal(t, 403, w.Code)
+
+	// The owner is an admin of the job, and may move it only to teams
+	// where they are editors.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("PUT", "/jobs/build", `{"Team": "data"}`, "alice"))
+	assert.Equal(t, 403, w.Code)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("DELETE", "/jobs/build", "", "alice"))
+	assert.Equal(t, 200, w.Code)
+	_, err := jobRepo.FindOne("build")
+	assert.NotNil(t, err)
+}
+
+func TestRunsCreateForbidden(t *testing.T) {
+	router, _ := setupAccessTest([]*models.Grant{
+		{User: "alice", Role: models.Viewer},
+		{User: "bob", Role: models.Operator, Job: "deploy"},
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("POST", "/jobs/deploy/runs/", "", "alice"))
+	assert.Equal(t, 403, w.Code)
+	assert.Equal(t, "alice needs the operator role on job deploy", jsonToMap(w)["Message"])
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("POST", "/jobs/deploy/runs/", "", "bob"))
+	assert.Equal(t, 201, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("DELETE", "/jobs/deploy/runs/1", "", "alice"))
+	assert.Equal(t, 403, w.Code)
+}
+
+func TestGrantsCreate(t *testing.T) {
+	router, _ := setupAccessTest([]*models.Grant{{User: "alice", Role: models.Admin, Team: "infra"}})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("POST", "/grants/", `{"User": "bob", "Role": "operator", "Team": "infra"}`, "alice"))
+	assert.Equal(t, 201, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("POST", "/jobs/deploy/runs/", "", "bob"))
+	assert.Equal(t, 201, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("POST", "/grants/", `{"User": "bob", "Role": "admin"}`, "alice"))
+	assert.Equal(t, 403, w.Code)
+	assert.Equal(t, "alice needs the admin role on all jobs", jsonToMap(w)["Message"])
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("GET", "/grants/", "", "bob"))
+	assert.Equal(t, 1, len(jsonToSlice(w)))
+}
+
+func TestJobsDeleteRevokesGrants(t *testing.T) {
+	router, jobRepo := setupAccessTest([]*models.Grant{
+		{User: "alice", Role: models.Admin, Job: "deploy"},
+		{User: "bob", Role: models.Viewer, Team: "infra"},
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("DELETE", "/jobs/deploy", "", "alice"))
+	assert.Equal(t, 200, w.Code)
+
+	_, _ = jobRepo.Add(echoJob("Deploy"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("GET", "/jobs/deploy", "", "alice"))
+	assert.Equal(t, 403, w.Code)
+}
+
+func setupAuditTest() (http.Handler, *models.MemAuditLog) {
+	router := mux.NewRouter()
+	jobRepo := models.NewMemJobRepo([]*models.Job{})
+	auditLog := models.NewMemAuditLog()
+	grantRepo := models.NewMemGrantRepo([]*models.Grant{{User: "alice", Role: models.Admin}})
+	access := models.NewAccess(grantRepo)
+	setupRouter(router.PathPrefix("/jobs"), controllers.NewJobController(jobRepo, models.NewMemJobVersionRepo(), access, auditLog))
+	setupRouter(router.PathPrefix("/grants"), controllers.NewGrantController(grantRepo, jobRepo, access, auditLog))
+	setupRouter(router.PathPrefix("/tokens"), controllers.NewTokenController(models.NewMemTokenRepo([]*models.Token{}), nil, auditLog))
+	setupAuditRouter(router.PathPrefix("/audit"), controllers.NewAuditController(auditLog, access))
+	return controllers.RequestID(router), auditLog
+}
+
+func TestAuditGrantsAndTokens(t *testing.T) {
+	handler, auditLog := setupAuditTest()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, userRequest("POST", "/grants/", `{"User": "bob", "Role": "viewer"}`, "alice"))
+	assert.Equal(t, 201, w.Code)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, userRequest("DELETE", "/grants/2", "", "alice"))
+	assert.Equal(t, 200, w.Code)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, userRequest("POST", "/tokens/", `{"Name": "ci"}`, "alice"))
+	assert.Equal(t, 201, w.Code)
+	token := jsonToMap(w)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, userRequest("DELETE", "/tokens/"+token["KeyID"].(string), "", "alice"))
+	assert.Equal(t, 200, w.Code)
+
+	events, _ := auditLog.Find(&models.AuditFilter{})
+	actions := []string{}
+	for _, e := range events {
+		actions = append(actions, e.Action+" "+e.Resource)
+	}
+	assert.Equal(t, []string{"grant.create grants/2", "grant.destroy grants/2",
+		"token.create tokens/" + token["KeyID"].(string), "token.revoke tokens/" + token["KeyID"].(string)}, actions)
+	assert.Equal(t, "***", events[2].Changes["Token"].After)
+}
+
+func TestAuditJobChanges(t *testing.T) {
+	handler, _ := setupAuditTest()
+	for _, req := range []*http.Request{
+		userRequest("POST", "/jobs/", `{"Name": "Build", "Command": "make"}`, "alice"),
+		userRequest("PUT", "/jobs/build", `{"Command": "make all"}`, "alice"),
+		userRequest("DELETE", "/jobs/build", "", "alice"),
+	} {
+		req.Header.Set("X-Request-ID", "req-"+req.Method)
+		req.RemoteAddr = "10.0.0.1:4321"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(t, "req-"+req.Method, w.Header().Get("X-Request-ID"))
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, userRequest("GET", "/audit/?resource=jobs/build&action=job.update", "", "alice"))
+	assert.Equal(t, 200, w.Code)
+	events := jsonToSlice(w)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "alice", events[0]["Actor"])
+	assert.Equal(t, "req-PUT", events[0]["RequestID"])
+	assert.Equal(t, "10.0.0.1", events[0]["SourceIP"])
+	assert.Equal(t, map[string]interface{}{
+		"Command": map[string]interface{}{"Before": "make", "After": "make all"},
+		"Version": map[string]interface{}{"Before": 1.0, "After": 2.0},
+	}, events[0]["Changes"])
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, userRequest("GET", "/audit/?format=jsonl", "", "alice"))
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, 3, len(lines))
+	var event models.AuditEvent
+	assert.Nil(t, json.Unmarshal([]byte(lines[2]), &event))
+	assert.Equal(t, "job.destroy", event.Action)
+	assert.Equal(t, "make all", event.Changes["Command"].Before)
+}
+
+func TestAuditForbidden(t *testing.T) {
+	handler, _ := setupAuditTest()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, userRequest("GET", "/audit/", "", "bob"))
+	assert.Equal(t, 403, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, userRequest("GET", "/audit/?since=yesterday", "", "alice"))
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, "Invalid since: yesterday\n", w.Body.String())
+}
+
+func TestAuditSecretValueHidden(t *testing.T) {
+	router := mux.NewRouter()
+	cipher, _ := models.NewCipher("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	auditLog := models.NewMemAuditLog()
+	setupRouter(router.PathPrefix("/secrets"), controllers.NewSecretController(models.NewMemSecretRepo([]*models.Secret{}),
+		cipher, models.NewMemJobRepo([]*models.Job{}), adminAccess(), auditLog))
+	req, _ := http.NewRequest("POST", "/secrets/", strings.NewReader(`{"Name": "token", "Team": "infra", "Env": "TOKEN", "Value": "hunter2"}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 201, w.Code)
+
+	events, _ := auditLog.Find(&models.AuditFilter{})
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "secret.create", events[0].Action)
+	assert.Equal(t, "***", events[0].Changes["Value"].After)
+	b, _ := json.Marshal(events[0])
+	assert.NotContains(t, string(b), "hunter2")
+}
+
+func setupVersionTest() (*mux.Router, *models.MemRunRepo) {
+	router := mux.NewRouter()
+	jobRepo, versionRepo := models.NewMemJobRepo([]*models.Job{}), models.NewMemJobVersionRepo()
+	runRepo, logStore := models.NewMemRunRepo([]*models.Run{}), models.NewMemLogStore()
+	access, auditLog := adminAccess(), models.NewMemAuditLog()
+	setupRunRouter(router.PathPrefix("/jobs/{slug}/runs"),
+		controllers.NewRunController(jobRepo, runRepo, logStore, runner.NewRunner(runRepo, logStore, newQueue()), access, auditLog))
+	setupVersionRouter(router.PathPrefix("/jobs/{slug}"), controllers.NewVersionController(jobRepo, versionRepo, access, auditLog))
+	setupRouter(router.PathPrefix("/jobs"), controllers.NewJobController(jobRepo, versionRepo, access, auditLog))
+	return router, runRepo
+}
+
+// staleVersions finds the versions of a job as they were before a
+// concurrent update added its version.
+type staleVersions struct {
+	*models.MemJobVersionRepo
+}
+
+func (r staleVersions) Find(slug string) ([]*models.JobVersion, error) {
+	versions, err := r.MemJobVersionRepo.Find(slug)
+	if len(versions) > 0 {
+		versions = versions[:len(versions)-1]
+	}
+	return versions, err
+}
+
+func TestJobsUpdateVersionTaken(t *testing.T) {
+	router := mux.NewRouter()
+	job := models.NewJob("Build")
+	job.Version = 1
+	jobRepo, versionRepo := models.NewMemJobRepo([]*models.Job{job}), models.NewMemJobVersionRepo()
+	_, _ = versionRepo.Add(models.NewJobVersion(job, "alice"))
+	taken := *job
+	taken.Version = 2
+	_, _ = versionRepo.Add(models.NewJobVersion(&taken, "bob"))
+	setupRouter(router.PathPrefix("/jobs"), controllers.NewJobController(jobRepo, staleVersions{versionRepo}, adminAccess(), models.NewMemAuditLog()))
+
+	w := send(router, "PUT", "/jobs/build", `{"Command": "make"}`)
+
+	assert.Equal(t, 412, w.Code)
+	j, _ := jobRepo.FindOne("build")
+	assert.Equal(t, 1, j.Version)
+	assert.Equal(t, "", j.Command)
+	v, _ := versionRepo.FindOne("build", 2)
+	assert.Equal(t, "bob", v.Author)
+}
+
+func send(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, path, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestJobVersions(t *testing.T) {
+	router, runRepo := setupVersionTest()
+	w := send(router, "POST", "/jobs/", `{"Name": "Build", "Command": "make"}`)
+	assert.Equal(t, 1.0, jsonToMap(w)["Version"])
+	w = send(router, "PUT", "/jobs/build", `{"Command": "make all", "Schedule": "@daily"}`)
+	assert.Equal(t, 2.0, jsonToMap(w)["Version"])
+	w = send(router, "PUT", "/jobs/build", `{"Command": "make all", "Version": 9}`)
+	assert.Equal(t, 2.0, jsonToMap(w)["Version"])
+
+	w = send(router, "GET", "/jobs/build/versions/", "")
+	assert.Equal(t, 200, w.Code)
+	versions := jsonToSlice(w)
+	assert.Equal(t, 2, len(versions))
+	assert.Equal(t, "anonymous", versions[1]["Author"])
+
+	w = send(router, "POST", "/jobs/build/runs/", "")
+	assert.Equal(t, 201, w.Code)
+	run, _ := runRepo.FindOne(1)
+	assert.Equal(t, 2, run.JobVersion)
+
+	w = send(router, "GET", "/jobs/build/versions/2/diff", "")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, map[string]interface{}{"Before": "make", "After": "make all"}, jsonToMap(w)["Command"])
+	w = send(router, "GET", "/jobs/build/versions/2/diff?from=5", "")
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestJobRollback(t *testing.T) {
+	router, _ := setupVersionTest()
+	send(router, "POST", "/jobs/", `{"Name": "Build", "Command": "make"}`)
+	send(router, "PUT", "/jobs/build", `{"Command": "make all", "Schedule": "@daily"}`)
+
+	w := send(router, "POST", "/jobs/build/rollback?version=1", "")
+	assert.Equal(t, 200, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, 3.0, m["Version"])
+	assert.Equal(t, "make", m["Command"])
+	assert.Equal(t, "", m["Schedule"])
+	assert.Equal(t, "anonymous", m["Owner"])
+
+	w = send(router, "GET", "/jobs/build/versions/3/diff?from=1", "")
+	assert.Equal(t, map[string]interface{}{}, jsonToMap(w))
+	w = send(router, "POST", "/jobs/build/rollback?version=7", "")
+	assert.Equal(t, 404, w.Code)
+	w = send(router, "POST", "/jobs/build/rollback", "")
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestJobRollbackTeamForbidden(t *testing.T) {
+	router := mux.NewRouter()
+	job := echoJob("Deploy")
+	job.Team, job.Version = "infra", 2
+	jobRepo, versionRepo := models.NewMemJobRepo([]*models.Job{job}), models.NewMemJobVersionRepo()
+	data := *job
+	data.Team, data.Version = "data", 1
+	_, _ = versionRepo.Add(models.NewJobVersion(&data, "bob"))
+	_, _ = versionRepo.Add(models.NewJobVersion(job, "bob"))
+	access := models.NewAccess(models.NewMemGrantRepo([]*models.Grant{{User: "alice", Role: models.Editor, Team: "infra"}}))
+	setupVersionRouter(router.PathPrefix("/jobs/{slug}"), controllers.NewVersionController(jobRepo, versionRepo, access, models.NewMemAuditLog()))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("POST", "/jobs/deploy/rollback?version=1", "", "alice"))
+
+	assert.Equal(t, 403, w.Code)
+	assert.Equal(t, "alice needs the editor role on team data", jsonToMap(w)["Message"])
+	j, _ := jobRepo.FindOne("deploy")
+	assert.Equal(t, "infra", j.Team)
+}
+
+func TestJobETag(t *testing.T) {
+	router, _ := setupVersionTest()
+	w := send(router, "POST", "/jobs/", `{"Name": "Build", "Command": "make"}`)
+	assert.Equal(t, `W/"1"`, w.Header().Get("ETag"))
+
+	w = send(router, "GET", "/jobs/build", "")
+	assert.Equal(t, `W/"1"`, w.Header().Get("ETag"))
+	req, _ := http.NewRequest("GET", "/jobs/build", nil)
+	req.Header.Set("If-None-Match", `"1"`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 304, w.Code)
+	assert.Equal(t, 0, w.Body.Len())
+
+	w = send(router, "GET", "/jobs/", "")
+	etag := w.Header().Get("ETag")
+	req, _ = http.NewRequest("GET", "/jobs/", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 304, w.Code)
+
+	send(router, "PUT", "/jobs/build", `{"Command": "make all"}`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+}
+
+func TestJobIfMatch(t *testing.T) {
+	router, _ := setupVersionTest()
+	send(router, "POST", "/jobs/", `{"Name": "Build", "Command": "make"}`)
+	send(router, "PUT", "/jobs/build", `{"Command": "make all"}`)
+
+	conditional := func(method, path, body, ifMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("If-Match", ifMatch)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	w := conditional("PUT", "/jobs/build", `{"Command": "make test"}`, `"1"`)
+	assert.Equal(t, 412, w.Code)
+	assert.Equal(t, `W/"2"`, w.Header().Get("ETag"))
+	w = conditional("DELETE", "/jobs/build", "", `"1"`)
+	assert.Equal(t, 412, w.Code)
+	w = conditional("POST", "/jobs/build/rollback?version=1", "", `"1"`)
+	assert.Equal(t, 412, w.Code)
+	w = conditional("PUT", "/jobs/missing", `{"Command": "make"}`, `"1"`)
+	assert.Equal(t, 412, w.Code)
+
+	w = conditional("PUT", "/jobs/build", `{"Command": "make test"}`, `"2"`)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `W/"3"`, w.Header().Get("ETag"))
+	assert.Equal(t, "make test", jsonToMap(w)["Command"])
+	w = conditional("DELETE", "/jobs/build", "", `W/"3"`)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestJobsIndexPages(t *testing.T) {
+	router, jobRepo := setupAccessTest([]*models.Grant{{User: "alice", Role: models.Viewer, Team: "infra"}})
+	for _, name := range []string{"Lint", "Test", "Package"} {
+		job := echoJob(name)
+		job.Team = "infra"
+		_, _ = jobRepo.Add(job)
+	}
+
+	names := []string{}
+	path := "/jobs/?sort=name&limit=2"
+	for path != "" {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, userRequest("GET", path, "", "alice"))
+		assert.Equal(t, 200, w.Code)
+		for _, j := range jsonToSlice(w) {
+			names = append(names, j["Name"].(string))
+		}
+		path = strings.TrimSuffix(strings.TrimPrefix(w.Header().Get("Link"), "<"), `>; rel="next"`)
+	}
+	assert.Equal(t, []string{"Deploy", "Lint", "Package", "Test"}, names)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, userRequest("GET", "/jobs/?prefix=L&order=desc", "", "alice"))
+	assert.Equal(t, 1, len(jsonToSlice(w)))
+	assert.Equal(t, "", w.Header().Get("Link"))
+	w = send(router, "GET", "/jobs/?sort=command", "")
+	assert.Equal(t, 400, w.Code)
+	w = send(router, "GET", "/jobs/?after=bogus", "")
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestJobsUpdateReplace(t *testing.T) {
+	router, _ := setupVersionTest()
+	send(router, "POST", "/jobs/", `{"Name": "Build", "Command": "make", "Schedule": "@daily", "Args": ["all"]}`)
+
+	w := send(router, "PUT", "/jobs/build?replace=true", `{"Name": "Build", "Command": "make"}`)
+	assert.Equal(t, 200, w.Code)
+	m := jsonToMap(w)
+	assert.Equal(t, "", m["Schedule"])
+	assert.Nil(t, m["Args"])
+	assert.Equal(t, "anonymous", m["Owner"])
+	assert.Equal(t, 2.0, m["Version"])
+
+	w = send(router, "PUT", "/jobs/build?replace=true", `{"Name": "Build", "Command": "make"}`)
+	assert.Equal(t, 2.0, jsonToMap(w)["Version"])
+}
@@ -0,0 +1,242 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Role says what a user may do with a job. Each role may do what the roles
+// before it may: viewers see jobs and their runs, operators start and
+// cancel runs, editors change jobs and admins delete them and grant roles.
+type Role string
+
+const (
+	Viewer   Role = "viewer"
+	Operator Role = "operator"
+	Editor   Role = "editor"
+	Admin    Role = "admin"
+)
+
+var roleRanks = map[Role]int{Viewer: 1, Operator: 2, Editor: 3, Admin: 4}
+
+// Allows tells if the role may do what the required role may.
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+func (r Role) max(other Role) Role {
+	if other.Allows(r) {
+		return other
+	}
+	return r
+}
+
+// Grant gives a user a role on a job, on the jobs of a team or, with
+// neither, on all jobs, teams and other resources.
+type Grant struct {
+	gorm.Model
+	User string `gorm:"index"`
+	Role Role
+	Job  string
+	Team string
+}
+
+func (g *Grant) Validate() error {
+	if _, ok := roleRanks[g.Role]; !ok {
+		return fmt.Errorf("Invalid role: %s", g.Role)
+	}
+	if g.Job != "" && g.Team != "" {
+		return fmt.Errorf("Grant must be on either a job or a team")
+	}
+	return nil
+}
+
+func (g *Grant) global() bool {
+	return g.Job == "" && g.Team == ""
+}
+
+func ParseGrant(reader io.ReadCloser) (*Grant, error) {
+	if reader == nil {
+		return nil, fmt.Errorf("No body to parse")
+	}
+	decoder := json.NewDecoder(reader)
+	defer reader.Close() // errcheck-ignore
+	var grant Grant
+	if err := decoder.Decode(&grant); err != nil {
+		return nil, err
+	}
+	if err := grant.Validate(); err != nil {
+		return nil, invalid(err)
+	}
+	return &grant, nil
+}
+
+// ForbiddenError is returned when a user does not have the role required
+// for a request.
+type ForbiddenError struct {
+	Message string
+	User    string
+	Role    Role
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Message
+}
+
+// Forbidden returns the error of the user not having the role on what.
+func Forbidden(user string, role Role, what string) *ForbiddenError {
+	return &ForbiddenError{
+		Message: fmt.Sprintf("%s needs the %s role on %s", user, role, what),
+		User:    user,
+		Role:    role,
+	}
+}
+
+// Access finds the roles of users from their grants.
+type Access struct {
+	grants GrantRepo
+}
+
+func NewAccess(grants GrantRepo) *Access {
+	return &Access{grants: grants}
+}
+
+// Role returns the highest role of the user on the job. The owner of a job
+// is its admin.
+func (a *Access) Role(user string, job *Job) Role {
+	if job.Owner != "" && job.Owner == user {
+		return Admin
+	}
+	return a.role(user, func(g *Grant) bool {
+		return g.Job == job.Slug || g.Team != "" && g.Team == job.Team
+	})
+}
+
+// TeamRole returns the highest role of the user on the jobs of the team.
+// Without a team it is the global role of the user.
+func (a *Access) TeamRole(user, team string) Role {
+	return a.role(user, func(g *Grant) bool {
+		return team != "" && g.Team == team
+	})
+}
+
+// RevokeJob deletes the grants on the job, so that they are not given on
+// a later job with the same slug, and returns them.
+func (a *Access) RevokeJob(slug string) ([]*Grant, error) {
+	return a.grants.DeleteJob(slug)
+}
+
+// role returns the highest role of the grants of the user that are global
+// or match.
+func (a *Access) role(user string, match func(*Grant) bool) Role {
+	grants, err := a.grants.FindByUser(user)
+	if err != nil {
+		return ""
+	}
+	var role Role
+	for _, g := range grants {
+		if g.global() || match(g) {
+			role = role.max(g.Role)
+		}
+	}
+	return role
+}
+
+type GrantRepo interface {
+	Find() ([]*Grant, error)
+	FindByUser(user string) ([]*Grant, error)
+	FindOne(id uint) (*Grant, error)
+	Add(grant *Grant) (*Grant, error)
+	Delete(id uint) (*Grant, error)
+	// DeleteJob deletes the grants on the job, and returns them.
+	DeleteJob(job string) ([]*Grant, error)
+}
+
+type MemGrantRepo struct {
+	sync.Mutex
+	grants []*Grant
+	nextID uint
+}
+
+func NewMemGrantRepo(grants []*Grant) *MemGrantRepo {
+	r := &MemGrantRepo{
+		grants: []*Grant{},
+	}
+	for _, g := range grants {
+		_, _ = r.Add(g)
+	}
+	return r
+}
+
+func (r *MemGrantRepo) Find() ([]*Grant, error) {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*Grant{}, r.grants...), nil
+}
+
+func (r *MemGrantRepo) FindByUser(user string) ([]*Grant, error) {
+	r.Lock()
+	defer r.Unlock()
+	grants := []*Grant{}
+	for _, g := range r.grants {
+		if g.User == user {
+			grants = append(grants, g)
+		}
+	}
+	return grants, nil
+}
+
+func (r *MemGrantRepo) FindOne(id uint) (*Grant, error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, g := range r.grants {
+		if g.ID == id {
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("No grant found with id: %d", id)
+}
+
+func (r *MemGrantRepo) Add(grant *Grant) (*Grant, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.nextID++
+	now := time.Now()
+	grant.CreatedAt = now
+	grant.UpdatedAt = now
+	grant.ID = r.nextID
+	r.grants = append(r.grants, grant)
+	return grant, nil
+}
+
+func (r *MemGrantRepo) Delete(id uint) (*Grant, error) {
+	r.Lock()
+	defer r.Unlock()
+	for i, g := range r.grants {
+		if g.ID == id {
+			r.grants = append(r.grants[:i], r.grants[i+1:]...)
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("Cannot find grant with id %d", id)
+}
+
+func (r *MemGrantRepo) DeleteJob(job string) ([]*Grant, error) {
+	r.Lock()
+	defer r.Unlock()
+	deleted, kept := []*Grant{}, []*Grant{}
+	for _, g := range r.grants {
+		if g.Job == job {
+			deleted = append(deleted, g)
+		} else {
+			kept = append(kept, g)
+		}
+	}
+	r.grants = kept
+	return deleted, nil
+}
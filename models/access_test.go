@@ -0,0 +1,57 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoleAllows(t *testing.T) {
+	assert.True(t, Admin.Allows(Viewer))
+	assert.True(t, Operator.Allows(Operator))
+	assert.False(t, Operator.Allows(Editor))
+	assert.False(t, Role("").Allows(Viewer))
+	assert.False(t, Role("root").Allows(Viewer))
+}
+
+func TestAccessRole(t *testing.T) {
+	access := NewAccess(NewMemGrantRepo([]*Grant{
+		{User: "alice", Role: Viewer},
+		{User: "alice", Role: Operator, Team: "infra"},
+		{User: "alice", Role: Editor, Job: "deploy"},
+		{User: "bob", Role: Admin, Team: "data"},
+	}))
+	deploy := &Job{Slug: "deploy", Team: "infra"}
+	backup := &Job{Slug: "backup", Team: "infra"}
+	report := &Job{Slug: "report", Team: "data", Owner: "carol"}
+
+	assert.Equal(t, Editor, access.Role("alice", deploy))
+	assert.Equal(t, Operator, access.Role("alice", backup))
+	assert.Equal(t, Viewer, access.Role("alice", report))
+	assert.Equal(t, Role(""), access.Role("bob", backup))
+	assert.Equal(t, Admin, access.Role("bob", report))
+	assert.Equal(t, Admin, access.Role("carol", report))
+	assert.Equal(t, Role(""), access.Role("carol", deploy))
+
+	assert.Equal(t, Operator, access.TeamRole("alice", "infra"))
+	assert.Equal(t, Viewer, access.TeamRole("alice", ""))
+	assert.Equal(t, Role(""), access.TeamRole("bob", ""))
+}
+
+func TestAccessRevokeJob(t *testing.T) {
+	access := NewAccess(NewMemGrantRepo([]*Grant{
+		{User: "alice", Role: Editor, Job: "deploy"},
+		{User: "alice", Role: Viewer, Team: "infra"},
+	}))
+	grants, err := access.RevokeJob("deploy")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(grants))
+	assert.Equal(t, Viewer, access.Role("alice", &Job{Slug: "deploy", Team: "infra"}))
+}
+
+func TestGrantValidate(t *testing.T) {
+	assert.Nil(t, (&Grant{User: "alice", Role: Admin}).Validate())
+	assert.EqualError(t, (&Grant{User: "alice", Role: "root"}).Validate(), "Invalid role: root")
+	assert.EqualError(t, (&Grant{User: "alice", Role: Viewer, Job: "a", Team: "b"}).Validate(),
+		"Grant must be on either a job or a team")
+}
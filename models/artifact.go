@@ -0,0 +1,77 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Artifact is a file produced by a run, matched by one of the job's
+// Artifacts patterns. Its content is kept in a BlobStore under Key.
+type Artifact struct {
+	ID    uint `gorm:"primary_key"`
+	RunID uint `gorm:"index"`
+	// Name is the path of the file relative to the working dir of the job.
+	Name      string
+	Size      int64
+	SHA256    string
+	CreatedAt time.Time
+}
+
+func (a *Artifact) Key() string {
+	return fmt.Sprintf("runs/%d/%s", a.RunID, a.Name)
+}
+
+type ArtifactRepo interface {
+	Add(artifact *Artifact) (*Artifact, error)
+	Find(runID uint) ([]*Artifact, error)
+	FindOne(runID uint, name string) (*Artifact, error)
+}
+
+type MemArtifactRepo struct {
+	sync.Mutex
+	artifacts []*Artifact
+	nextID    uint
+}
+
+func NewMemArtifactRepo() *MemArtifactRepo {
+	return &MemArtifactRepo{
+		artifacts: []*Artifact{},
+	}
+}
+
+func (r *MemArtifactRepo) Add(artifact *Artifact) (*Artifact, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.nextID++
+	artifact.ID = r.nextID
+	artifact.CreatedAt = time.Now()
+	c := *artifact
+	r.artifacts = append(r.artifacts, &c)
+	return artifact, nil
+}
+
+func (r *MemArtifactRepo) Find(runID uint) ([]*Artifact, error) {
+	r.Lock()
+	defer r.Unlock()
+	artifacts := []*Artifact{}
+	for _, a := range r.artifacts {
+		if a.RunID == runID {
+			c := *a
+			artifacts = append(artifacts, &c)
+		}
+	}
+	return artifacts, nil
+}
+
+func (r *MemArtifactRepo) FindOne(runID uint, name string) (*Artifact, error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, a := range r.artifacts {
+		if a.RunID == runID && a.Name == name {
+			c := *a
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("No artifact %s found for run: %d", name, runID)
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEvent records who did what to which resource, such as
+// {Actor: "alice", Action: "job.update", Resource: "jobs/deploy"}. Events
+// are only ever appended.
+type AuditEvent struct {
+	ID        uint      `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+	Actor     string    `gorm:"index"`
+	Action    string    `gorm:"index"`
+	Resource  string    `gorm:"index"`
+	Changes   Changes   `gorm:"type:text"`
+	RequestID string
+	SourceIP  string
+}
+
+// Change is the value of a field before and after an event, nil when the
+// resource did not exist.
+type Change struct {
+	Before interface{}
+	After  interface{}
+}
+
+// Changes are the changes of an event by field.
+type Changes map[string]Change
+
+func (c Changes) Value() (driver.Value, error) {
+	b, err := json.Marshal(c)
+	return string(b), err
+}
+
+func (c *Changes) Scan(src interface{}) error {
+	return scanJson(src, c)
+}
+
+// Snapshot returns the fields of a resource as they are written as JSON,
+// to be compared with Diff. It is nil for a nil resource.
+func Snapshot(resource interface{}) map[string]interface{} {
+	if v := reflect.ValueOf(resource); resource == nil || v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil
+	}
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// Diff returns the fields that differ between two snapshots, except
+// UpdatedAt. Empty lists and maps are the same as null, since they are
+// stored alike.
+func Diff(before, after map[string]interface{}) Changes {
+	changes := Changes{}
+	for name, value := range before {
+		if other := after[name]; !reflect.DeepEqual(emptyAsNil(value), emptyAsNil(other)) {
+			changes[name] = Change{Before: value, After: other}
+		}
+	}
+	for name, value := range after {
+		if _, ok := before[name]; !ok && emptyAsNil(value) != nil {
+			changes[name] = Change{After: value}
+		}
+	}
+	delete(changes, "UpdatedAt")
+	return changes
+}
+
+func emptyAsNil(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+	}
+	return value
+}
+
+// AuditFilter selects audit events. Empty fields match all events, and a
+// resource also matches the resources under it, so "jobs/deploy" matches
+// "jobs/deploy/runs/1".
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Resource string
+	Since    time.Time
+	Until    time.Time
+	// Limit is the most events to return, 0 for no limit.
+	Limit int
+}
+
+func (f *AuditFilter) Match(e *AuditEvent) bool {
+	return (f.Actor == "" || e.Actor == f.Actor) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Resource == "" || e.Resource == f.Resource || strings.HasPrefix(e.Resource, f.Resource+"/")) &&
+		(f.Since.IsZero() || !e.CreatedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || e.CreatedAt.Before(f.Until))
+}
+
+// AuditLog is an append-only log of audit events, found oldest first.
+type AuditLog interface {
+	Append(event *AuditEvent) error
+	Find(filter *AuditFilter) ([]*AuditEvent, error)
+}
+
+type MemAuditLog struct {
+	sync.Mutex
+	events []*AuditEvent
+}
+
+func NewMemAuditLog() *MemAuditLog {
+	return &MemAuditLog{events: []*AuditEvent{}}
+}
+
+func (l *MemAuditLog) Append(event *AuditEvent) error {
+	l.Lock()
+	defer l.Unlock()
+	event.ID = uint(len(l.events) + 1)
+	event.CreatedAt = time.Now()
+	c := *event
+	l.events = append(l.events, &c)
+	return nil
+}
+
+func (l *MemAuditLog) Find(filter *AuditFilter) ([]*AuditEvent, error) {
+	l.Lock()
+	defer l.Unlock()
+	events := []*AuditEvent{}
+	for _, e := range l.events {
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+		if filter.Match(e) {
+			c := *e
+			events = append(events, &c)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	before := Snapshot(&Job{Name: "Deploy", Command: "make", UpdatedAt: time.Now()})
+	after := Snapshot(&Job{Name: "Deploy", Command: "make deploy", Team: "infra", UpdatedAt: time.Now().Add(time.Second)})
+	changes := Diff(before, after)
+	assert.Equal(t, Changes{
+		"Command": {Before: "make", After: "make deploy"},
+		"Team":    {Before: "", After: "infra"},
+	}, changes)
+
+	var job *Job
+	assert.Nil(t, Snapshot(job))
+	created := Diff(nil, Snapshot(&Job{Name: "Deploy"}))
+	assert.Equal(t, Change{After: "Deploy"}, created["Name"])
+}
+
+func TestAuditFilter(t *testing.T) {
+	now := time.Now()
+	event := &AuditEvent{Actor: "alice", Action: "run.trigger", Resource: "jobs/deploy/runs/1", CreatedAt: now}
+	assert.True(t, (&AuditFilter{}).Match(event))
+	assert.True(t, (&AuditFilter{Resource: "jobs/deploy"}).Match(event))
+	assert.False(t, (&AuditFilter{Resource: "jobs/dep"}).Match(event))
+	assert.False(t, (&AuditFilter{Actor: "bob"}).Match(event))
+	assert.True(t, (&AuditFilter{Since: now, Until: now.Add(time.Second)}).Match(event))
+	assert.False(t, (&AuditFilter{Until: now}).Match(event))
+}
+
+func TestMemAuditLog(t *testing.T) {
+	log := NewMemAuditLog()
+	for _, action := range []string{"job.create", "job.update", "job.update"} {
+		assert.Nil(t, log.Append(&AuditEvent{Action: action, Resource: "jobs/deploy"}))
+	}
+	events, _ := log.Find(&AuditFilter{Action: "job.update", Limit: 1})
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, uint(2), events[0].ID)
+}
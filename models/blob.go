@@ -0,0 +1,93 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// BlobStore keeps the content of artifacts by key. Keys are slash separated
+// paths, such as "runs/1/report.html".
+type BlobStore interface {
+	Put(key string, reader io.Reader, size int64) error
+	Get(key string) (io.ReadCloser, error)
+}
+
+type MemBlobStore struct {
+	sync.Mutex
+	blobs map[string][]byte
+}
+
+func NewMemBlobStore() *MemBlobStore {
+	return &MemBlobStore{
+		blobs: map[string][]byte{},
+	}
+}
+
+func (s *MemBlobStore) Put(key string, reader io.Reader, size int64) error {
+	b, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.blobs[key] = b
+	return nil
+}
+
+func (s *MemBlobStore) Get(key string) (io.ReadCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, ok := s.blobs[key]
+	if !ok {
+		return nil, fmt.Errorf("No blob found with key: %s", key)
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// FsBlobStore keeps each blob as a file in dir, at the path of its key.
+type FsBlobStore struct {
+	dir string
+}
+
+func NewFsBlobStore(dir string) (*FsBlobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FsBlobStore{dir: dir}, nil
+}
+
+func (s *FsBlobStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// Put writes the blob to a temporary file that is renamed into place, so
+// that a partial blob is never read.
+func (s *FsBlobStore) Put(key string, reader io.Reader, size int64) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".blob")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, reader); err != nil {
+		f.Close()           // errcheck-ignore
+		os.Remove(f.Name()) // errcheck-ignore
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name()) // errcheck-ignore
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *FsBlobStore) Get(key string) (io.ReadCloser, error) {
+	return os.Open(s.path(key))
+}
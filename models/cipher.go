@@ -0,0 +1,61 @@
+package models
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Cipher encrypts secrets at rest with AES-256-GCM under a master key.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher returns a cipher of the master key, given as 32 bytes encoded
+// in base64.
+func NewCipher(key string) (*Cipher, error) {
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(b) != 32 {
+		return nil, fmt.Errorf("Master key must be 32 bytes encoded in base64")
+	}
+	block, err := aes.NewCipher(b)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal encrypts the plaintext with a random nonce and returns the nonce and
+// ciphertext in base64. The ciphertext can only be opened with the same
+// additional data, which binds it to where it is stored.
+func (c *Cipher) Seal(plaintext, data string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(plaintext), []byte(data))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+var errOpen = errors.New("Failed to decrypt secret")
+
+func (c *Cipher) Open(ciphertext, data string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil || len(b) < c.aead.NonceSize() {
+		return "", errOpen
+	}
+	size := c.aead.NonceSize()
+	plaintext, err := c.aead.Open(nil, b[:size], b[size:], []byte(data))
+	if err != nil {
+		return "", errOpen
+	}
+	return string(plaintext), nil
+}
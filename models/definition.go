@@ -0,0 +1,119 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// IsJobFile tells if the file is a JSON, YAML or TOML job definition, by
+// its extension.
+func IsJobFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml", ".toml":
+		return true
+	}
+	return false
+}
+
+// ParseJobFile parses the jobs defined in a JSON, YAML or TOML file. The
+// fields are named as in JSON, in any case. A JSON file may define several
+// jobs in an array, and a YAML file in documents separated by "---".
+func ParseJobFile(name string, data []byte) ([]*Job, error) {
+	docs, err := parseDocuments(name, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	jobs := []*Job{}
+	for _, doc := range docs {
+		job, err := decodeJob(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func parseDocuments(name string, data []byte) ([]interface{}, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		var doc interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		if docs, ok := doc.([]interface{}); ok {
+			return docs, nil
+		}
+		return []interface{}{doc}, nil
+	case ".yaml", ".yml":
+		docs := []interface{}{}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		for {
+			var doc interface{}
+			err := decoder.Decode(&doc)
+			if err == io.EOF {
+				return docs, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if doc != nil {
+				docs = append(docs, stringKeys(doc))
+			}
+		}
+	case ".toml":
+		var doc map[string]interface{}
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		return []interface{}{doc}, nil
+	}
+	return nil, fmt.Errorf("Unknown job file format: %s", filepath.Ext(name))
+}
+
+// stringKeys converts the maps that YAML decodes, with keys of any type,
+// to maps with string keys, as JSON has.
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = stringKeys(item)
+		}
+	}
+	return value
+}
+
+// decodeJob decodes a parsed document as a job, which must be named. Unknown
+// fields are errors, since they are likely misspelled.
+func decodeJob(doc interface{}) (*Job, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	var job Job
+	if err := decoder.Decode(&job); err != nil {
+		return nil, err
+	}
+	if job.Name == "" && job.Slug == "" {
+		return nil, Required("Job", "Name")
+	}
+	if err := prepareJob(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
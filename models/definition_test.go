@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJobFile(t *testing.T) {
+	jobs, err := ParseJobFile("jobs.yaml", []byte(`
+name: Deploy
+command: make
+args: [deploy]
+timeout: 5m
+schedule: "@daily"
+---
+name: Backup
+command: tar
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(jobs))
+	assert.Equal(t, "deploy", jobs[0].Slug)
+	assert.Equal(t, StringList{"deploy"}, jobs[0].Args)
+	assert.Equal(t, "5m0s", jobs[0].Timeout.String())
+	assert.Equal(t, "backup", jobs[1].Slug)
+
+	jobs, err = ParseJobFile("deploy.toml", []byte("name = \"Deploy\"\ncommand = \"make\"\n[env]\nSTAGE = \"prod\"\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, StringMap{"STAGE": "prod"}, jobs[0].Env)
+
+	jobs, err = ParseJobFile("jobs.json", []byte(`[{"Name": "Deploy"}, {"Name": "Backup"}]`))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(jobs))
+}
+
+func TestParseJobFileErrors(t *testing.T) {
+	_, err := ParseJobFile("deploy.yaml", []byte("name: Deploy\ncomand: make\n"))
+	assert.EqualError(t, err, `deploy.yaml: json: unknown field "comand"`)
+	_, err = ParseJobFile("deploy.yaml", []byte("command: make\n"))
+	assert.EqualError(t, err, "deploy.yaml: Job must have a name: Name is required")
+	_, err = ParseJobFile("deploy.toml", []byte("name = \"Deploy\"\ntimeout = \"-1s\"\n"))
+	assert.EqualError(t, err, "deploy.toml: Timeout cannot be negative: -1s")
+	_, err = ParseJobFile("deploy.txt", []byte(""))
+	assert.EqualError(t, err, "deploy.txt: Unknown job file format: .txt")
+	assert.True(t, IsJobFile("jobs/deploy.YML"))
+	assert.False(t, IsJobFile("jobs/README.md"))
+}
+
+func TestParseJobFileYAMLFeatures(t *testing.T) {
+	jobs, err := ParseJobFile("jobs.yml", []byte(`
+name: Deploy
+command: &cmd make
+args: [*cmd, deploy]
+maxconcurrency: 010
+`))
+	assert.Nil(t, err)
+	assert.Equal(t, StringList{"make", "deploy"}, jobs[0].Args)
+	assert.Equal(t, 8, jobs[0].MaxConcurrency)
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"fmt"
+)
+
+// queueState is what the dispatch order of the queue depends on: the items
+// that are claimed, the sizes of the pools and the weights of the teams.
+type queueState struct {
+	claimed []*QueueItem
+	sizes   map[string]int
+	weights map[string]int
+}
+
+func newQueueState() *queueState {
+	return &queueState{
+		claimed: []*QueueItem{},
+		sizes:   map[string]int{},
+		weights: map[string]int{},
+	}
+}
+
+// waitingOn returns the limits that keep the item from being claimed. An
+// unknown pool has size 0.
+func (s *queueState) waitingOn(item *QueueItem) []string {
+	waiting := []string{}
+	if item.MaxConcurrency > 0 {
+		n := 0
+		for _, c := range s.claimed {
+			if c.JobSlug == item.JobSlug && c.RunID != item.RunID {
+				n++
+			}
+		}
+		if n >= item.MaxConcurrency {
+			waiting = append(waiting, fmt.Sprintf("job %s (%d/%d)", item.JobSlug, n, item.MaxConcurrency))
+		}
+	}
+	for _, pool := range item.Pools {
+		n := 0
+		for _, c := range s.claimed {
+			if c.RunID != item.RunID && c.Pools.contains(pool) {
+				n++
+			}
+		}
+		if n >= s.sizes[pool] {
+			waiting = append(waiting, fmt.Sprintf("pool %s (%d/%d)", pool, n, s.sizes[pool]))
+		}
+	}
+	return waiting
+}
+
+// order returns the waiting items in the order they are expected to be
+// claimed. The team with the smallest share, its claimed items per weight,
+// goes first, then the item with the higher priority and then the older
+// one. Each item counts towards the share of its team once it is ordered,
+// so that no team can starve the others.
+func (s *queueState) order(waiting []*QueueItem) []*QueueItem {
+	running := map[string]int{}
+	for _, c := range s.claimed {
+		running[c.Team]++
+	}
+	left := append([]*QueueItem{}, waiting...)
+	ordered := make([]*QueueItem, 0, len(left))
+	for len(left) > 0 {
+		first := 0
+		for n := 1; n < len(left); n++ {
+			if s.before(left[n], left[first], running) {
+				first = n
+			}
+		}
+		ordered = append(ordered, left[first])
+		running[left[first].Team]++
+		left = append(left[:first], left[first+1:]...)
+	}
+	return ordered
+}
+
+func (s *queueState) before(a, b *QueueItem, running map[string]int) bool {
+	if sa, sb := s.share(a.Team, running), s.share(b.Team, running); sa != sb {
+		return sa < sb
+	}
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.ID < b.ID
+}
+
+// share is the claimed items of the team per its weight. A team without
+// weight has weight 1.
+func (s *queueState) share(team string, running map[string]int) float64 {
+	weight := s.weights[team]
+	if weight < 1 {
+		weight = 1
+	}
+	return float64(running[team]) / float64(weight)
+}
+
+// queued orders the waiting items, and sets their positions and what they
+// are waiting on.
+func (s *queueState) queued(waiting []*QueueItem) []*QueueItem {
+	ordered := s.order(waiting)
+	for n, i := range ordered {
+		i.Position = n + 1
+		i.WaitingOn = s.waitingOn(i)
+	}
+	return ordered
+}
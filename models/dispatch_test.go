@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func teamItem(id uint, team string, priority int) *QueueItem {
+	return &QueueItem{ID: id, RunID: id, Team: team, Priority: priority}
+}
+
+func runIDs(items []*QueueItem) []uint {
+	ids := []uint{}
+	for _, i := range items {
+		ids = append(ids, i.RunID)
+	}
+	return ids
+}
+
+func TestOrderFairShare(t *testing.T) {
+	waiting := []*QueueItem{
+		teamItem(1, "a", 0), teamItem(2, "a", 0), teamItem(3, "a", 0),
+		teamItem(4, "b", 0), teamItem(5, "b", 0),
+	}
+	state := newQueueState()
+	assert.Equal(t, []uint{1, 4, 2, 5, 3}, runIDs(state.order(waiting)))
+	state.weights["a"] = 2
+	assert.Equal(t, []uint{1, 4, 2, 3, 5}, runIDs(state.order(waiting)))
+	state.weights["a"] = 1
+	state.claimed = []*QueueItem{teamItem(6, "a", 0), teamItem(7, "a", 0)}
+	assert.Equal(t, []uint{4, 5, 1, 2, 3}, runIDs(state.order(waiting)))
+}
+
+func TestOrderPriority(t *testing.T) {
+	waiting := []*QueueItem{
+		teamItem(1, "a", 0), teamItem(2, "a", 5), teamItem(3, "b", 1),
+	}
+	state := newQueueState()
+	assert.Equal(t, []uint{2, 3, 1}, runIDs(state.order(waiting)))
+}
+
+func TestQueued(t *testing.T) {
+	state := newQueueState()
+	item := teamItem(1, "a", 0)
+	item.Pools = StringList{"db"}
+	queued := state.queued([]*QueueItem{teamItem(2, "b", 0), item})
+	assert.Equal(t, uint(1), queued[0].RunID)
+	assert.Equal(t, 1, queued[0].Position)
+	assert.Equal(t, []string{"pool db (0/0)"}, queued[0].WaitingOn)
+	assert.Equal(t, 2, queued[1].Position)
+	assert.Equal(t, []string{}, queued[1].WaitingOn)
+}
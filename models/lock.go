@@ -0,0 +1,9 @@
+package models
+
+// Lock is held by one process at a time, such as the server that runs the
+// scheduler.
+type Lock interface {
+	// Hold tells if the process holds the lock, and takes it if it is
+	// free.
+	Hold() (bool, error)
+}
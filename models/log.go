@@ -0,0 +1,129 @@
+package models
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type Stream string
+
+const (
+	Stdout Stream = "stdout"
+	Stderr Stream = "stderr"
+)
+
+// LogLine is one line of output from a run. Seq numbers the lines of a
+// run from zero, across both streams, in the order they were captured.
+type LogLine struct {
+	ID     uint `gorm:"primary_key" json:"-"`
+	RunID  uint `gorm:"index"`
+	Seq    int
+	Time   time.Time
+	Stream Stream
+	Text   string
+}
+
+type LogStore interface {
+	Append(line *LogLine) error
+	Read(runID uint, offset, limit int) ([]*LogLine, error)
+}
+
+type MemLogStore struct {
+	sync.Mutex
+	lines map[uint][]*LogLine
+}
+
+func NewMemLogStore() *MemLogStore {
+	return &MemLogStore{
+		lines: map[uint][]*LogLine{},
+	}
+}
+
+func (s *MemLogStore) Append(line *LogLine) error {
+	s.Lock()
+	defer s.Unlock()
+	c := *line
+	s.lines[line.RunID] = append(s.lines[line.RunID], &c)
+	return nil
+}
+
+func (s *MemLogStore) Read(runID uint, offset, limit int) ([]*LogLine, error) {
+	s.Lock()
+	defer s.Unlock()
+	lines := []*LogLine{}
+	for i := offset; i < len(s.lines[runID]) && (limit <= 0 || len(lines) < limit); i++ {
+		c := *s.lines[runID][i]
+		lines = append(lines, &c)
+	}
+	return lines, nil
+}
+
+// FsLogStore keeps the log of each run as a file of JSON lines in dir.
+type FsLogStore struct {
+	dir string
+}
+
+func NewFsLogStore(dir string) (*FsLogStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FsLogStore{dir: dir}, nil
+}
+
+func (s *FsLogStore) path(runID uint) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%d.log", runID))
+}
+
+func (s *FsLogStore) Append(line *LogLine) error {
+	b, err := json.Marshal(line)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(line.RunID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close() // errcheck-ignore
+		return err
+	}
+	return f.Close()
+}
+
+func (s *FsLogStore) Read(runID uint, offset, limit int) ([]*LogLine, error) {
+	lines := []*LogLine{}
+	f, err := os.Open(s.path(runID))
+	if os.IsNotExist(err) {
+		return lines, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // errcheck-ignore
+	reader := bufio.NewReader(f)
+	for i := 0; limit <= 0 || len(lines) < limit; i++ {
+		b, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A partial line is still being written.
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if i < offset {
+			continue
+		}
+		var line LogLine
+		if err := json.Unmarshal(b, &line); err != nil {
+			return nil, err
+		}
+		lines = append(lines, &line)
+	}
+	return lines, nil
+}
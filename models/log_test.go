@@ -0,0 +1,54 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func appendLines(store LogStore, runID uint, texts ...string) {
+	for i, text := range texts {
+		_ = store.Append(&LogLine{RunID: runID, Seq: i, Time: time.Now(), Stream: Stdout, Text: text})
+	}
+}
+
+func testLogStore(t *testing.T, store LogStore) {
+	appendLines(store, 1, "one", "two", "three", "four")
+	appendLines(store, 2, "other")
+
+	lines, err := store.Read(1, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(lines))
+	assert.Equal(t, "one", lines[0].Text)
+	assert.Equal(t, Stdout, lines[0].Stream)
+
+	lines, err = store.Read(1, 1, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(lines))
+	assert.Equal(t, "two", lines[0].Text)
+	assert.Equal(t, 2, lines[1].Seq)
+
+	lines, err = store.Read(1, 10, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(lines))
+
+	lines, err = store.Read(3, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(lines))
+}
+
+func TestMemLogStore(t *testing.T) {
+	testLogStore(t, NewMemLogStore())
+}
+
+func TestFsLogStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewFsLogStore(dir)
+	assert.Nil(t, err)
+	testLogStore(t, store)
+}
@@ -2,20 +2,89 @@ package models
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/andersjanmyr/jobs/cron"
 	"github.com/jinzhu/gorm"
 )
 
+// ErrJobExists is returned when a job is added with the slug of another.
+var ErrJobExists = errors.New("Job already exists")
+
 type Job struct {
 	gorm.Model
-	Name string
-	Slug string
+	Name    string
+	Slug    string `gorm:"unique_index"`
+	Command string
+	Args    StringList `gorm:"type:text"`
+	Env     StringMap  `gorm:"type:text"`
+	Dir     string
+	Timeout Duration
+	// KillGrace is how long a timed out run has to exit after SIGTERM,
+	// before it is killed.
+	KillGrace Duration
+
+	Schedule  string
+	Timezone  string
+	CatchUp   CatchUpPolicy
+	NextRunAt *time.Time
+	LastRunAt *time.Time
+
+	Retry RetryPolicy `gorm:"type:text"`
+	// OnLost says what to do with a run whose worker stopped heartbeating.
+	OnLost LostPolicy
+
+	// Labels are required of the workers that execute the job's runs, and
+	// workers with any of the AntiAffinity labels are avoided.
+	Labels       StringMap `gorm:"type:text"`
+	AntiAffinity StringMap `gorm:"type:text"`
+
+	// MaxConcurrency is the most runs of the job executing at a time, 0
+	// for no limit. Each run also takes a slot in each of the Pools.
+	MaxConcurrency int
+	Pools          StringList `gorm:"type:text"`
+
+	// Team owns the job, and Priority orders its runs before the other
+	// runs of the team, higher first.
+	Team     string
+	Priority int
+	// Owner is the user who created the job, and is its admin.
+	Owner string
+	// Version is the version of the definition of the job, see JobVersion.
+	Version int
+
+	Params Params `gorm:"type:text"`
+
+	// Artifacts are glob patterns, relative to Dir, of the files to keep
+	// after each run, such as "dist/*.tar.gz".
+	Artifacts StringList `gorm:"type:text"`
 }
 
+// CatchUpPolicy says what to do about scheduled runs that were missed,
+// because the server was down.
+type CatchUpPolicy string
+
+const (
+	CatchUpSkip CatchUpPolicy = "skip"
+	CatchUpOnce CatchUpPolicy = "once"
+	CatchUpAll  CatchUpPolicy = "all"
+)
+
+// LostPolicy says what to do about a run that was lost, because its
+// worker died or was stopped while executing it.
+type LostPolicy string
+
+const (
+	LostRequeue LostPolicy = "requeue"
+	LostFail    LostPolicy = "fail"
+)
+
 var jobIndex uint = 0
 
 func NewJob(name string) *Job {
@@ -26,6 +95,28 @@ func slug(name string) string {
 	return strings.ToLower(name)
 }
 
+// Merge returns a copy of the job updated with the fields given in job.
+func (j *Job) Merge(job *Job) *Job {
+	merged := *j
+	merged.update(job)
+	return &merged
+}
+
+// Replaced returns the job with the definition of job, keeping the ids,
+// times, owner and version. The next run of a schedule is kept if the
+// schedule is.
+func (j *Job) Replaced(job *Job) *Job {
+	replaced := Job(job.Definition())
+	replaced.Model = j.Model
+	replaced.Owner = j.Owner
+	replaced.Version = j.Version
+	replaced.LastRunAt = j.LastRunAt
+	if replaced.Schedule == j.Schedule && replaced.Timezone == j.Timezone {
+		replaced.NextRunAt = j.NextRunAt
+	}
+	return &replaced
+}
+
 func (j *Job) update(job *Job) {
 	if job.Name != "" {
 		j.Name = job.Name
@@ -33,7 +124,171 @@ func (j *Job) update(job *Job) {
 	if job.Slug != "" {
 		j.Slug = job.Slug
 	}
-	job.UpdatedAt = time.Now()
+	if job.Command != "" {
+		j.Command = job.Command
+	}
+	if job.Args != nil {
+		j.Args = job.Args
+	}
+	if job.Env != nil {
+		j.Env = job.Env
+	}
+	if job.Dir != "" {
+		j.Dir = job.Dir
+	}
+	if job.Timeout != 0 {
+		j.Timeout = job.Timeout
+	}
+	if job.KillGrace != 0 {
+		j.KillGrace = job.KillGrace
+	}
+	if job.Schedule != "" && job.Schedule != j.Schedule {
+		j.Schedule = job.Schedule
+		j.NextRunAt = nil
+	}
+	if job.Timezone != "" && job.Timezone != j.Timezone {
+		j.Timezone = job.Timezone
+		j.NextRunAt = nil
+	}
+	if job.CatchUp != "" {
+		j.CatchUp = job.CatchUp
+	}
+	if job.OnLost != "" {
+		j.OnLost = job.OnLost
+	}
+	if job.Labels != nil {
+		j.Labels = job.Labels
+	}
+	if job.AntiAffinity != nil {
+		j.AntiAffinity = job.AntiAffinity
+	}
+	if job.MaxConcurrency != 0 {
+		j.MaxConcurrency = job.MaxConcurrency
+	}
+	if job.Pools != nil {
+		j.Pools = job.Pools
+	}
+	if job.Team != "" {
+		j.Team = job.Team
+	}
+	if job.Priority != 0 {
+		j.Priority = job.Priority
+	}
+	if job.Params != nil {
+		j.Params = job.Params
+	}
+	if job.Artifacts != nil {
+		j.Artifacts = job.Artifacts
+	}
+	if job.NextRunAt != nil {
+		j.NextRunAt = job.NextRunAt
+	}
+	if job.LastRunAt != nil {
+		j.LastRunAt = job.LastRunAt
+	}
+	if job.Retry.MaxAttempts != 0 {
+		j.Retry = job.Retry
+	}
+	if job.Version != 0 {
+		j.Version = job.Version
+	}
+	j.UpdatedAt = time.Now()
+}
+
+func (j *Job) Validate() error {
+	if j.Command == "" && len(j.Args) > 0 {
+		return fmt.Errorf("Job has args but no command")
+	}
+	for k := range j.Env {
+		if !validEnv(k) {
+			return fmt.Errorf("Invalid environment variable name: %q", k)
+		}
+	}
+	if j.Dir != "" && !filepath.IsAbs(j.Dir) {
+		return fmt.Errorf("Working dir must be an absolute path: %s", j.Dir)
+	}
+	if j.Timeout < 0 {
+		return fmt.Errorf("Timeout cannot be negative: %s", j.Timeout)
+	}
+	if j.KillGrace < 0 {
+		return fmt.Errorf("Kill grace cannot be negative: %s", j.KillGrace)
+	}
+	if j.Schedule != "" {
+		if _, err := cron.Parse(j.Schedule); err != nil {
+			return err
+		}
+	}
+	if _, err := time.LoadLocation(j.Timezone); err != nil {
+		return fmt.Errorf("Invalid timezone: %s", j.Timezone)
+	}
+	switch j.CatchUp {
+	case "", CatchUpSkip, CatchUpOnce, CatchUpAll:
+	default:
+		return fmt.Errorf("Invalid catch-up policy: %s", j.CatchUp)
+	}
+	switch j.OnLost {
+	case "", LostRequeue, LostFail:
+	default:
+		return fmt.Errorf("Invalid lost policy: %s", j.OnLost)
+	}
+	if err := validateLabels(j.Labels); err != nil {
+		return err
+	}
+	if err := validateLabels(j.AntiAffinity); err != nil {
+		return err
+	}
+	if j.MaxConcurrency < 0 {
+		return fmt.Errorf("Max concurrency cannot be negative: %d", j.MaxConcurrency)
+	}
+	if err := j.Params.validate(); err != nil {
+		return err
+	}
+	if len(j.Params) > 0 {
+		for _, s := range append([]string{j.Command}, j.Args...) {
+			if _, err := j.Params.Expand(s, StringMap{}); err != nil {
+				return fmt.Errorf("Invalid param template %q: %s", s, err)
+			}
+		}
+	}
+	for _, pattern := range j.Artifacts {
+		if err := validateArtifact(pattern); err != nil {
+			return err
+		}
+	}
+	return j.Retry.Validate()
+}
+
+func validEnv(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "=\x00")
+}
+
+func validateArtifact(pattern string) error {
+	if _, err := filepath.Match(pattern, ""); err != nil || pattern == "" {
+		return fmt.Errorf("Invalid artifact pattern: %q", pattern)
+	}
+	if filepath.IsAbs(pattern) || strings.HasPrefix(filepath.Clean(pattern), "..") {
+		return fmt.Errorf("Artifact pattern must be inside the working dir: %s", pattern)
+	}
+	return nil
+}
+
+const DefaultKillGrace = 10 * time.Second
+
+// TerminationGrace is KillGrace, or DefaultKillGrace if it is not set.
+func (j *Job) TerminationGrace() time.Duration {
+	if j.KillGrace == 0 {
+		return DefaultKillGrace
+	}
+	return j.KillGrace.Duration()
+}
+
+// Location is the time zone that the schedule is in, UTC by default.
+func (j *Job) Location() *time.Location {
+	loc, err := time.LoadLocation(j.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
 }
 
 func ParseJob(reader io.ReadCloser) (*Job, error) {
@@ -46,10 +301,24 @@ func ParseJob(reader io.ReadCloser) (*Job, error) {
 	if err := decoder.Decode(&job); err != nil {
 		return nil, err
 	}
+	if err := prepareJob(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// prepareJob sets the slug of a parsed job and validates it.
+func prepareJob(job *Job) error {
 	if job.Slug == "" {
 		job.Slug = slug(job.Name)
 	}
-	return &job, nil
+	// Fire times are kept by the scheduler.
+	job.NextRunAt = nil
+	job.LastRunAt = nil
+	if err := job.Validate(); err != nil {
+		return invalid(err)
+	}
+	return nil
 }
 
 func ParseJobs(reader io.ReadCloser) ([]Job, error) {
@@ -66,15 +335,33 @@ func ParseJobs(reader io.ReadCloser) ([]Job, error) {
 }
 
 type JobRepo interface {
-	Find() ([]*Job, error)
+	// Find returns the jobs that match the query, a page at a time.
+	Find(query *JobQuery) ([]*Job, error)
 	FindOne(slug string) (*Job, error)
 	Add(job *Job) (*Job, error)
 	Update(job *Job) (*Job, error)
+	// Replace saves all the fields of the job, where Update only sets
+	// those that are given.
+	Replace(job *Job) (*Job, error)
+	// ReplaceVersion replaces the job if its stored version is still
+	// version, and fails with ErrVersionConflict otherwise. The run times
+	// are kept, which are the scheduler's, except that the next run is
+	// cleared when the schedule changes.
+	ReplaceVersion(job *Job, version int) (*Job, error)
 	UpAdd(job *Job) (*Job, error)
 	Delete(slug string) (*Job, error)
+	// DeleteVersion deletes the job if its stored version is still version,
+	// and fails with ErrVersionConflict otherwise.
+	DeleteVersion(slug string, version int) (*Job, error)
+	// UpdateRunTimes sets only the NextRunAt and LastRunAt of the job, so
+	// that the scheduler does not overwrite other changes of it.
+	UpdateRunTimes(slug string, nextRunAt, lastRunAt *time.Time) error
 }
 
+// MemJobRepo keeps the jobs in memory. It returns copies of them, so that
+// they are only changed through the repo.
 type MemJobRepo struct {
+	sync.Mutex
 	jobs []*Job
 }
 
@@ -88,11 +375,32 @@ func NewMemJobRepo(jobs []*Job) *MemJobRepo {
 	return r
 }
 
-func (r *MemJobRepo) Find() ([]*Job, error) {
-	return r.jobs, nil
+func copyJob(job *Job) *Job {
+	c := *job
+	return &c
+}
+
+func (r *MemJobRepo) Find(query *JobQuery) ([]*Job, error) {
+	r.Lock()
+	defer r.Unlock()
+	page, err := query.page(r.jobs)
+	for i, j := range page {
+		page[i] = copyJob(j)
+	}
+	return page, err
 }
 
 func (r *MemJobRepo) FindOne(slug string) (*Job, error) {
+	r.Lock()
+	defer r.Unlock()
+	j, err := r.findOne(slug)
+	if err != nil {
+		return nil, err
+	}
+	return copyJob(j), nil
+}
+
+func (r *MemJobRepo) findOne(slug string) (*Job, error) {
 	for _, j := range r.jobs {
 		if j.Slug == slug {
 			return j, nil
@@ -102,12 +410,21 @@ func (r *MemJobRepo) FindOne(slug string) (*Job, error) {
 }
 
 func (r *MemJobRepo) Add(job *Job) (*Job, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.add(job)
+}
+
+func (r *MemJobRepo) add(job *Job) (*Job, error) {
+	if j, _ := r.findOne(job.Slug); j != nil {
+		return nil, ErrJobExists
+	}
 	jobIndex++
 	now := time.Now()
 	job.CreatedAt = now
 	job.UpdatedAt = now
 	job.ID = jobIndex
-	r.jobs = append(r.jobs, job)
+	r.jobs = append(r.jobs, copyJob(job))
 	return job, nil
 }
 
@@ -120,25 +437,94 @@ func (r *MemJobRepo) index(slug string) int {
 	return -1
 }
 func (r *MemJobRepo) Update(job *Job) (*Job, error) {
-	j, _ := r.FindOne(job.Slug)
+	r.Lock()
+	defer r.Unlock()
+	j, _ := r.findOne(job.Slug)
 	if j == nil {
 		return nil, fmt.Errorf("Cannot find job with slug %s", job.Slug)
 	}
 	j.update(job)
-	return job, nil
+	return copyJob(j), nil
+}
+
+func (r *MemJobRepo) Replace(job *Job) (*Job, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.replace(job)
+}
+
+func (r *MemJobRepo) replace(job *Job) (*Job, error) {
+	j, _ := r.findOne(job.Slug)
+	if j == nil {
+		return nil, fmt.Errorf("Cannot find job with slug %s", job.Slug)
+	}
+	*j = *job
+	j.UpdatedAt = time.Now()
+	return copyJob(j), nil
+}
+
+func (r *MemJobRepo) ReplaceVersion(job *Job, version int) (*Job, error) {
+	r.Lock()
+	defer r.Unlock()
+	j, _ := r.findOne(job.Slug)
+	if j == nil {
+		return nil, fmt.Errorf("Cannot find job with slug %s", job.Slug)
+	}
+	if j.Version != version {
+		return nil, ErrVersionConflict
+	}
+	replaced := *job
+	replaced.LastRunAt = j.LastRunAt
+	replaced.NextRunAt = nil
+	if job.Schedule == j.Schedule && job.Timezone == j.Timezone {
+		replaced.NextRunAt = j.NextRunAt
+	}
+	return r.replace(&replaced)
+}
+
+func (r *MemJobRepo) UpdateRunTimes(slug string, nextRunAt, lastRunAt *time.Time) error {
+	r.Lock()
+	defer r.Unlock()
+	j, _ := r.findOne(slug)
+	if j == nil {
+		return fmt.Errorf("Cannot find job with slug %s", slug)
+	}
+	j.NextRunAt, j.LastRunAt = nextRunAt, lastRunAt
+	return nil
 }
 
 func (r *MemJobRepo) UpAdd(job *Job) (*Job, error) {
-	j, _ := r.FindOne(job.Slug)
+	r.Lock()
+	defer r.Unlock()
+	j, _ := r.findOne(job.Slug)
 	if j == nil {
-		return r.Add(job)
+		return r.add(job)
 	} else {
 		j.update(job)
-		return j, nil
+		return copyJob(j), nil
+	}
+}
+
+func (r *MemJobRepo) DeleteVersion(slug string, version int) (*Job, error) {
+	r.Lock()
+	defer r.Unlock()
+	j, _ := r.findOne(slug)
+	if j == nil {
+		return nil, fmt.Errorf("Cannot find job with slug %s", slug)
 	}
+	if j.Version != version {
+		return nil, ErrVersionConflict
+	}
+	return r.delete(slug)
 }
 
 func (r *MemJobRepo) Delete(slug string) (*Job, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.delete(slug)
+}
+
+func (r *MemJobRepo) delete(slug string) (*Job, error) {
 	i := r.index(slug)
 	if i == -1 {
 		return nil, fmt.Errorf("Cannot find job with slug %s", slug)
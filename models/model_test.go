@@ -1,14 +1,17 @@
 package models
 
 import (
+	"io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestFind(t *testing.T) {
 	jobRepo := NewMemJobRepo([]*Job{NewJob("One"), NewJob("Two")})
-	jobs, _ := jobRepo.Find()
+	jobs, _ := jobRepo.Find(&JobQuery{})
 	assert.Equal(t, 2, len(jobs))
 }
 
@@ -29,15 +32,23 @@ func TestFindOneMissing(t *testing.T) {
 
 func TestAdd(t *testing.T) {
 	jobRepo := NewMemJobRepo([]*Job{NewJob("One"), NewJob("Two")})
-	job := NewJob("One")
+	job := NewJob("Three")
 	j, _ := jobRepo.Add(job)
 	assert.NotZero(t, j.ID)
 	assert.NotZero(t, j.CreatedAt)
 	assert.NotZero(t, j.UpdatedAt)
-	jobs, _ := jobRepo.Find()
+	jobs, _ := jobRepo.Find(&JobQuery{})
 	assert.Equal(t, 3, len(jobs))
 }
 
+func TestAddExisting(t *testing.T) {
+	jobRepo := NewMemJobRepo([]*Job{NewJob("One"), NewJob("Two")})
+	_, err := jobRepo.Add(NewJob("one"))
+	assert.Equal(t, ErrJobExists, err)
+	jobs, _ := jobRepo.Find(&JobQuery{})
+	assert.Equal(t, 2, len(jobs))
+}
+
 func TestUpdate(t *testing.T) {
 	jobRepo := NewMemJobRepo([]*Job{NewJob("One"), NewJob("Two")})
 	job, _ := jobRepo.FindOne("one")
@@ -48,6 +59,64 @@ func TestUpdate(t *testing.T) {
 	assert.NotEqual(t, updatedAt, updatedJob.UpdatedAt)
 }
 
+func TestReplace(t *testing.T) {
+	job := NewJob("One")
+	job.Schedule = "@daily"
+	jobRepo := NewMemJobRepo([]*Job{job})
+	replacement := NewJob("One")
+	replacement.Command = "make"
+	j, err := jobRepo.Replace(replacement)
+	assert.Nil(t, err)
+	assert.Equal(t, "", j.Schedule)
+	assert.Equal(t, "make", j.Command)
+}
+
+func TestReplaceVersion(t *testing.T) {
+	job := NewJob("One")
+	job.Version = 2
+	jobRepo := NewMemJobRepo([]*Job{job})
+	replacement := NewJob("One")
+	replacement.Version = 3
+	j, err := jobRepo.ReplaceVersion(replacement, 1)
+	assert.Nil(t, j)
+	assert.Equal(t, ErrVersionConflict, err)
+	j, err = jobRepo.ReplaceVersion(replacement, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, j.Version)
+}
+
+func TestReplaceVersionKeepsRunTimes(t *testing.T) {
+	job := NewJob("One")
+	job.Schedule = "0 * * * *"
+	jobRepo := NewMemJobRepo([]*Job{job})
+	next, last := time.Now().Add(time.Hour), time.Now()
+	assert.Nil(t, jobRepo.UpdateRunTimes("one", &next, &last))
+	replacement := *job
+	replacement.Command = "make"
+	j, err := jobRepo.ReplaceVersion(&replacement, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, next, *j.NextRunAt)
+	assert.Equal(t, last, *j.LastRunAt)
+	replacement.Schedule = "30 * * * *"
+	j, _ = jobRepo.ReplaceVersion(&replacement, 0)
+	assert.Nil(t, j.NextRunAt)
+	assert.Equal(t, last, *j.LastRunAt)
+}
+
+func TestDeleteVersion(t *testing.T) {
+	job := NewJob("One")
+	job.Version = 2
+	jobRepo := NewMemJobRepo([]*Job{job})
+	j, err := jobRepo.DeleteVersion("one", 1)
+	assert.Nil(t, j)
+	assert.Equal(t, ErrVersionConflict, err)
+	j, err = jobRepo.DeleteVersion("one", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "one", j.Slug)
+	j, _ = jobRepo.FindOne("one")
+	assert.Nil(t, j)
+}
+
 func TestUpdateFail(t *testing.T) {
 	jobRepo := NewMemJobRepo([]*Job{NewJob("One"), NewJob("Two")})
 	job := NewJob("Missing")
@@ -56,3 +125,91 @@ func TestUpdateFail(t *testing.T) {
 	assert.Nil(t, j)
 	assert.EqualError(t, err, "Cannot find job with slug missing")
 }
+
+func TestParseJob(t *testing.T) {
+	body := ioutil.NopCloser(strings.NewReader(`{
+		"Name": "Backup",
+		"Command": "tar",
+		"Args": ["czf", "backup.tgz", "data"],
+		"Env": {"GZIP": "-9"},
+		"Dir": "/var/backups",
+		"Timeout": "5m",
+		"KillGrace": "30s"
+	}`))
+	job, err := ParseJob(body)
+	assert.Nil(t, err)
+	assert.Equal(t, "backup", job.Slug)
+	assert.Equal(t, "tar", job.Command)
+	assert.Equal(t, StringList{"czf", "backup.tgz", "data"}, job.Args)
+	assert.Equal(t, "-9", job.Env["GZIP"])
+	assert.Equal(t, "/var/backups", job.Dir)
+	assert.Equal(t, 5*time.Minute, job.Timeout.Duration())
+	assert.Equal(t, 30*time.Second, job.TerminationGrace())
+	assert.Equal(t, DefaultKillGrace, NewJob("Other").TerminationGrace())
+}
+
+func TestParseJobInvalid(t *testing.T) {
+	cases := map[string]string{
+		`{"Name": "a", "Args": ["x"]}`:                                               "Job has args but no command",
+		`{"Name": "a", "Command": "ls", "Dir": "tmp"}`:                               "Working dir must be an absolute path: tmp",
+		`{"Name": "a", "Command": "ls", "Timeout": -1}`:                              "Timeout cannot be negative: -1s",
+		`{"Name": "a", "Command": "ls", "KillGrace": "-2s"}`:                         "Kill grace cannot be negative: -2s",
+		`{"Name": "a", "Command": "ls", "Env": {"A=B": "c"}}`:                        `Invalid environment variable name: "A=B"`,
+		`{"Name": "a", "Schedule": "* * *"}`:                                         `Invalid cron expression "* * *": expected 5 or 6 fields`,
+		`{"Name": "a", "Timezone": "Mars/Olympus"}`:                                  "Invalid timezone: Mars/Olympus",
+		`{"Name": "a", "CatchUp": "sometimes"}`:                                      "Invalid catch-up policy: sometimes",
+		`{"Name": "a", "Retry": {"MaxAttempts": 3, "Jitter": 1.5}}`:                  "Retry jitter must be between 0 and 1: 1.5",
+		`{"Name": "a", "OnLost": "ignore"}`:                                          "Invalid lost policy: ignore",
+		`{"Name": "a", "Artifacts": ["../out"]}`:                                     "Artifact pattern must be inside the working dir: ../out",
+		`{"Name": "a", "Artifacts": ["dist/["]}`:                                     `Invalid artifact pattern: "dist/["`,
+		`{"Name": "a", "Params": [{"Name": "n", "Type": "float"}]}`:                  `Param n has invalid type: "float"`,
+		`{"Name": "a", "Command": "{{.n", "Params": [{"Name": "n", "Type": "int"}]}`: `Invalid param template "{{.n": template: :1: unclosed action`,
+	}
+	for body, msg := range cases {
+		job, err := ParseJob(ioutil.NopCloser(strings.NewReader(body)))
+		assert.Nil(t, job)
+		assert.EqualError(t, err, msg)
+	}
+}
+
+func TestUpdateCommand(t *testing.T) {
+	jobRepo := NewMemJobRepo([]*Job{NewJob("One"), NewJob("Two")})
+	job := NewJob("One")
+	job.Command = "echo"
+	job.Args = StringList{"hello"}
+	updatedJob, err := jobRepo.UpAdd(job)
+	assert.Nil(t, err)
+	assert.Equal(t, "echo", updatedJob.Command)
+	assert.Equal(t, StringList{"hello"}, updatedJob.Args)
+	jobs, _ := jobRepo.Find(&JobQuery{})
+	assert.Equal(t, 2, len(jobs))
+}
+
+func TestParseJobSchedule(t *testing.T) {
+	body := ioutil.NopCloser(strings.NewReader(`{
+		"Name": "Nightly",
+		"Schedule": "0 30 2 * * *",
+		"Timezone": "Europe/Stockholm",
+		"CatchUp": "once",
+		"NextRunAt": "2017-06-01T00:00:00Z"
+	}`))
+	job, err := ParseJob(body)
+	assert.Nil(t, err)
+	assert.Equal(t, "0 30 2 * * *", job.Schedule)
+	assert.Equal(t, CatchUpOnce, job.CatchUp)
+	assert.Equal(t, "Europe/Stockholm", job.Location().String())
+	assert.Nil(t, job.NextRunAt)
+}
+
+func TestUpdateScheduleResetsNextRunAt(t *testing.T) {
+	job := NewJob("One")
+	job.Schedule = "@hourly"
+	next := time.Now()
+	job.NextRunAt = &next
+	jobRepo := NewMemJobRepo([]*Job{job})
+	update := NewJob("One")
+	update.Schedule = "@daily"
+	j, _ := jobRepo.Update(update)
+	assert.Equal(t, "@daily", j.Schedule)
+	assert.Nil(t, j.NextRunAt)
+}
@@ -0,0 +1,267 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+type ParamType string
+
+const (
+	ParamString ParamType = "string"
+	ParamInt    ParamType = "int"
+	ParamBool   ParamType = "bool"
+	ParamEnum   ParamType = "enum"
+)
+
+// Param is a typed input of a job, given a value for each run. The value is
+// set as an environment variable named after the param, and can be used in
+// the command and args of the job as {{.name}}.
+type Param struct {
+	Name        string
+	Type        ParamType
+	Description string
+	Required    bool
+	// Default is the value when none is given.
+	Default interface{}
+	// Values are the values of an enum.
+	Values []string
+	// Min and Max bound an int, and Pattern must match a string.
+	Min     *int
+	Max     *int
+	Pattern string
+}
+
+var paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (p *Param) validate() error {
+	if !paramName.MatchString(p.Name) {
+		return fmt.Errorf("Invalid param name: %q", p.Name)
+	}
+	switch p.Type {
+	case ParamString, ParamInt, ParamBool:
+	case ParamEnum:
+		if len(p.Values) == 0 {
+			return fmt.Errorf("Enum param %s has no values", p.Name)
+		}
+	default:
+		return fmt.Errorf("Param %s has invalid type: %q", p.Name, p.Type)
+	}
+	if _, err := regexp.Compile(p.Pattern); err != nil {
+		return fmt.Errorf("Param %s has invalid pattern: %s", p.Name, err)
+	}
+	if p.Default != nil {
+		if _, err := p.resolve(p.Default); err != nil {
+			return fmt.Errorf("Param %s has invalid default: %s", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// resolve checks the value of the param, as decoded from JSON, and returns
+// it as a string.
+func (p *Param) resolve(value interface{}) (string, error) {
+	switch p.Type {
+	case ParamInt:
+		i, ok := toInt(value)
+		if !ok {
+			return "", fmt.Errorf("must be an integer")
+		}
+		if p.Min != nil && i < *p.Min {
+			return "", fmt.Errorf("must be at least %d", *p.Min)
+		}
+		if p.Max != nil && i > *p.Max {
+			return "", fmt.Errorf("must be at most %d", *p.Max)
+		}
+		return strconv.Itoa(i), nil
+	case ParamBool:
+		b, ok := value.(bool)
+		if !ok {
+			return "", fmt.Errorf("must be a boolean")
+		}
+		return strconv.FormatBool(b), nil
+	case ParamEnum:
+		s, _ := value.(string)
+		for _, v := range p.Values {
+			if s == v {
+				return s, nil
+			}
+		}
+		return "", fmt.Errorf("must be one of %s", strings.Join(p.Values, ", "))
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("must be a string")
+	}
+	if p.Pattern != "" && !regexp.MustCompile(p.Pattern).MatchString(s) {
+		return "", fmt.Errorf("must match %s", p.Pattern)
+	}
+	return s, nil
+}
+
+func toInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, false
+		}
+		return int(v), true
+	case json.Number:
+		i, err := strconv.Atoi(string(v))
+		return i, err == nil
+	case int:
+		return v, true
+	}
+	return 0, false
+}
+
+type Params []Param
+
+func (p Params) Value() (driver.Value, error) {
+	if p == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]Param(p))
+	return string(b), err
+}
+
+func (p *Params) Scan(src interface{}) error {
+	return scanJson(src, p)
+}
+
+func (p Params) validate() error {
+	names := map[string]bool{}
+	for _, param := range p {
+		if err := param.validate(); err != nil {
+			return err
+		}
+		if names[param.Name] {
+			return fmt.Errorf("Duplicate param: %s", param.Name)
+		}
+		names[param.Name] = true
+	}
+	return nil
+}
+
+// Resolve checks the values given for the params and returns the values of
+// all of them as strings, with defaults for those not given. The error is a
+// ValidationError with the errors of each invalid value.
+func (p Params) Resolve(values map[string]interface{}) (StringMap, error) {
+	resolved := StringMap{}
+	fields := map[string]string{}
+	known := map[string]bool{}
+	for _, param := range p {
+		known[param.Name] = true
+		value, ok := values[param.Name]
+		if !ok || value == nil {
+			value = param.Default
+		}
+		if value == nil {
+			if param.Required {
+				fields[param.Name] = "is required"
+			}
+			continue
+		}
+		s, err := param.resolve(value)
+		if err != nil {
+			fields[param.Name] = err.Error()
+			continue
+		}
+		resolved[param.Name] = s
+	}
+	for name := range values {
+		if !known[name] {
+			fields[name] = "is not a param of the job"
+		}
+	}
+	if len(fields) > 0 {
+		return nil, &ValidationError{Message: "Invalid params", Fields: fields}
+	}
+	return resolved, nil
+}
+
+// Typed converts values given as strings, such as the outputs of other
+// runs, to the types of the params, for Resolve. Values that cannot be
+// converted are left as strings, for Resolve to reject.
+func (p Params) Typed(values StringMap) map[string]interface{} {
+	types := map[string]ParamType{}
+	for _, param := range p {
+		types[param.Name] = param.Type
+	}
+	typed := map[string]interface{}{}
+	for name, s := range values {
+		typed[name] = s
+		switch types[name] {
+		case ParamInt:
+			if _, err := strconv.Atoi(s); err == nil {
+				typed[name] = json.Number(s)
+			}
+		case ParamBool:
+			if b, err := strconv.ParseBool(s); err == nil {
+				typed[name] = b
+			}
+		}
+	}
+	return typed
+}
+
+// Expand executes s as a template of the param values. Params that have no
+// value expand to the empty string.
+func (p Params) Expand(s string, values StringMap) (string, error) {
+	data := map[string]string{}
+	for _, param := range p {
+		data[param.Name] = values[param.Name]
+	}
+	t, err := template.New("").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// ValidationError is returned when a request is well formed, but has
+// invalid values. Fields has the errors of each invalid field, if known.
+type ValidationError struct {
+	Message string
+	Fields  map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Fields) == 0 {
+		return e.Message
+	}
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	errors := make([]string, len(names))
+	for i, name := range names {
+		errors[i] = name + " " + e.Fields[name]
+	}
+	return e.Message + ": " + strings.Join(errors, ", ")
+}
+
+func invalid(err error) *ValidationError {
+	return &ValidationError{Message: err.Error(), Fields: map[string]string{}}
+}
+
+// Required returns the error of a missing field of a kind of model.
+func Required(kind, field string) *ValidationError {
+	return &ValidationError{
+		Message: fmt.Sprintf("%s must have a %s", kind, strings.ToLower(field)),
+		Fields:  map[string]string{field: "is required"},
+	}
+}
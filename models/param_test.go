@@ -0,0 +1,68 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParamsResolve(t *testing.T) {
+	min, max := 1, 10
+	params := Params{
+		{Name: "env", Type: ParamEnum, Values: []string{"test", "prod"}, Default: "test"},
+		{Name: "count", Type: ParamInt, Min: &min, Max: &max},
+		{Name: "dry", Type: ParamBool},
+	}
+	values, err := params.Resolve(map[string]interface{}{"count": json.Number("4"), "dry": true})
+	assert.Nil(t, err)
+	assert.Equal(t, StringMap{"env": "test", "count": "4", "dry": "true"}, values)
+}
+
+func TestParamsResolveInvalid(t *testing.T) {
+	min := 1
+	params := Params{
+		{Name: "env", Type: ParamEnum, Values: []string{"test", "prod"}},
+		{Name: "count", Type: ParamInt, Min: &min},
+		{Name: "ref", Type: ParamString, Pattern: "^v[0-9]+$", Required: true},
+		{Name: "dry", Type: ParamBool},
+	}
+	_, err := params.Resolve(map[string]interface{}{
+		"env":   "dev",
+		"count": float64(0),
+		"dry":   "yes",
+		"other": 1,
+	})
+	assert.Equal(t, &ValidationError{Message: "Invalid params", Fields: map[string]string{
+		"env":   "must be one of test, prod",
+		"count": "must be at least 1",
+		"ref":   "is required",
+		"dry":   "must be a boolean",
+		"other": "is not a param of the job",
+	}}, err)
+	assert.EqualError(t, err, "Invalid params: count must be at least 1, dry must be a boolean, "+
+		"env must be one of test, prod, other is not a param of the job, ref is required")
+}
+
+func TestParamsExpand(t *testing.T) {
+	params := Params{{Name: "name", Type: ParamString}, {Name: "unset", Type: ParamString}}
+	s, err := params.Expand("hello {{.name}}{{.unset}}", StringMap{"name": "world"})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello world", s)
+	_, err = params.Expand("{{.other}}", StringMap{})
+	assert.NotNil(t, err)
+}
+
+func TestParamsTyped(t *testing.T) {
+	params := Params{
+		{Name: "count", Type: ParamInt},
+		{Name: "dry", Type: ParamBool},
+		{Name: "name", Type: ParamString},
+	}
+	typed := params.Typed(StringMap{"count": "3", "dry": "true", "name": "4", "other": "x"})
+	assert.Equal(t, map[string]interface{}{
+		"count": json.Number("3"), "dry": true, "name": "4", "other": "x",
+	}, typed)
+	_, err := params.Resolve(params.Typed(StringMap{"count": "three"}))
+	assert.EqualError(t, err, "Invalid params: count must be an integer")
+}
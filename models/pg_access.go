@@ -0,0 +1,68 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type PgGrantRepo struct {
+	db *gorm.DB
+}
+
+func NewPgGrantRepo(db *gorm.DB) *PgGrantRepo {
+	return &PgGrantRepo{
+		db: db,
+	}
+}
+
+func (r *PgGrantRepo) Find() ([]*Grant, error) {
+	var grants []*Grant
+	if err := r.db.Order("id").Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+func (r *PgGrantRepo) FindByUser(user string) ([]*Grant, error) {
+	var grants []*Grant
+	if err := r.db.Where(&Grant{User: user}).Order("id").Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+func (r *PgGrantRepo) FindOne(id uint) (*Grant, error) {
+	grant := Grant{}
+	if err := r.db.First(&grant, id).Error; err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+func (r *PgGrantRepo) Add(grant *Grant) (*Grant, error) {
+	if err := r.db.Create(grant).Error; err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+func (r *PgGrantRepo) Delete(id uint) (*Grant, error) {
+	grant, err := r.FindOne(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.db.Delete(grant).Error; err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+func (r *PgGrantRepo) DeleteJob(job string) ([]*Grant, error) {
+	var grants []*Grant
+	if err := r.db.Where("job = ?", job).Order("id").Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	if err := r.db.Where("job = ?", job).Delete(&Grant{}).Error; err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgGrantsAddFindDelete(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgGrantRepo(tx)
+	grant, err := repo.Add(&Grant{User: "alice", Role: Editor, Team: "infra"})
+	assert.Nil(t, err)
+	_, _ = repo.Add(&Grant{User: "bob", Role: Viewer})
+	grants, err := repo.FindByUser("alice")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(grants))
+	assert.Equal(t, Editor, grants[0].Role)
+	_, err = repo.Delete(grant.ID)
+	assert.Nil(t, err)
+	grants, _ = repo.FindByUser("alice")
+	assert.Equal(t, 0, len(grants))
+}
+
+func TestPgGrantsDeleteJob(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgGrantRepo(tx)
+	_, _ = repo.Add(&Grant{User: "alice", Role: Editor, Job: "deploy"})
+	_, _ = repo.Add(&Grant{User: "alice", Role: Viewer, Team: "infra"})
+	grants, err := repo.DeleteJob("deploy")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(grants))
+	grants, _ = repo.FindByUser("alice")
+	assert.Equal(t, 1, len(grants))
+	assert.Equal(t, Viewer, grants[0].Role)
+}
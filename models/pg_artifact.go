@@ -0,0 +1,38 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type PgArtifactRepo struct {
+	db *gorm.DB
+}
+
+func NewPgArtifactRepo(db *gorm.DB) *PgArtifactRepo {
+	return &PgArtifactRepo{
+		db: db,
+	}
+}
+
+func (r *PgArtifactRepo) Add(artifact *Artifact) (*Artifact, error) {
+	if err := r.db.Create(artifact).Error; err != nil {
+		return nil, err
+	}
+	return artifact, nil
+}
+
+func (r *PgArtifactRepo) Find(runID uint) ([]*Artifact, error) {
+	artifacts := []*Artifact{}
+	if err := r.db.Where(&Artifact{RunID: runID}).Order("name").Find(&artifacts).Error; err != nil {
+		return nil, err
+	}
+	return artifacts, nil
+}
+
+func (r *PgArtifactRepo) FindOne(runID uint, name string) (*Artifact, error) {
+	artifact := Artifact{}
+	if err := r.db.Where(&Artifact{RunID: runID, Name: name}).First(&artifact).Error; err != nil {
+		return nil, err
+	}
+	return &artifact, nil
+}
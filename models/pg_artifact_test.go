@@ -0,0 +1,27 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgArtifactsAddFind(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgArtifactRepo(tx)
+	_, err := repo.Add(&Artifact{RunID: 1, Name: "report.html", Size: 5, SHA256: "abc"})
+	assert.Nil(t, err)
+	_, _ = repo.Add(&Artifact{RunID: 1, Name: "dist/app", Size: 7})
+	_, _ = repo.Add(&Artifact{RunID: 2, Name: "report.html", Size: 3})
+	artifacts, err := repo.Find(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(artifacts))
+	assert.Equal(t, "dist/app", artifacts[0].Name)
+	artifact, err := repo.FindOne(1, "report.html")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), artifact.Size)
+	assert.Equal(t, "abc", artifact.SHA256)
+	_, err = repo.FindOne(1, "missing")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+type PgAuditLog struct {
+	db *gorm.DB
+}
+
+func NewPgAuditLog(db *gorm.DB) *PgAuditLog {
+	return &PgAuditLog{
+		db: db,
+	}
+}
+
+// ProtectAuditLog makes the audit table append-only, by having Postgres
+// ignore updates and deletes of its rows.
+func ProtectAuditLog(db *gorm.DB) error {
+	table := db.NewScope(&AuditEvent{}).TableName()
+	for _, op := range []string{"UPDATE", "DELETE"} {
+		rule := fmt.Sprintf("CREATE OR REPLACE RULE %s_no_%s AS ON %s TO %s DO INSTEAD NOTHING",
+			table, strings.ToLower(op), op, table)
+		if err := db.Exec(rule).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *PgAuditLog) Append(event *AuditEvent) error {
+	return l.db.Create(event).Error
+}
+
+func (l *PgAuditLog) Find(filter *AuditFilter) ([]*AuditEvent, error) {
+	query := l.db.Where(&AuditEvent{Actor: filter.Actor, Action: filter.Action})
+	if filter.Resource != "" {
+		prefix := filter.Resource + "/"
+		query = query.Where("resource = ? OR substr(resource, 1, ?) = ?",
+			filter.Resource, utf8.RuneCountInString(prefix), prefix)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var events []*AuditEvent
+	if err := query.Order("id").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgAuditLogAppendFind(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	assert.Nil(t, ProtectAuditLog(tx))
+	log := NewPgAuditLog(tx)
+	changes := Changes{"Command": {Before: "make", After: "make deploy"}}
+	assert.Nil(t, log.Append(&AuditEvent{Actor: "alice", Action: "job.update", Resource: "jobs/deploy", Changes: changes}))
+	assert.Nil(t, log.Append(&AuditEvent{Actor: "bob", Action: "run.trigger", Resource: "jobs/deploy/runs/1"}))
+	assert.Nil(t, log.Append(&AuditEvent{Actor: "bob", Action: "run.trigger", Resource: "jobs/deployer/runs/2"}))
+
+	events, err := log.Find(&AuditFilter{Resource: "jobs/deploy"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, changes, events[0].Changes)
+	events, _ = log.Find(&AuditFilter{Actor: "bob", Limit: 1})
+	assert.Equal(t, 1, len(events))
+
+	tx.Delete(&AuditEvent{})
+	events, _ = log.Find(&AuditFilter{})
+	assert.Equal(t, 3, len(events))
+}
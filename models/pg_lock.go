@@ -0,0 +1,52 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PgLock is a Postgres advisory lock. It is held by a session, so it keeps
+// a connection of its own while it holds the lock, which Postgres releases
+// if the connection is lost.
+type PgLock struct {
+	db   *sql.DB
+	key  int64
+	conn *sql.Conn
+}
+
+func NewPgLock(db *gorm.DB, key int64) *PgLock {
+	return &PgLock{
+		db:  db.DB(),
+		key: key,
+	}
+}
+
+func (l *PgLock) Hold() (bool, error) {
+	ctx := context.Background()
+	if l.conn != nil {
+		// The lock is held as long as its connection works.
+		if _, err := l.conn.ExecContext(ctx, "SELECT 1"); err != nil {
+			l.release()
+			return false, err
+		}
+		return true, nil
+	}
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var held bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&held); err != nil || !held {
+		conn.Close() // errcheck-ignore
+		return false, err
+	}
+	l.conn = conn
+	return true, nil
+}
+
+func (l *PgLock) release() {
+	l.conn.Close() // errcheck-ignore
+	l.conn = nil
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgLock(t *testing.T) {
+	first, second := NewPgLock(db, 1), NewPgLock(db, 1)
+	held, err := first.Hold()
+	assert.Nil(t, err)
+	assert.True(t, held)
+	held, err = second.Hold()
+	assert.Nil(t, err)
+	assert.False(t, held)
+	held, _ = first.Hold()
+	assert.True(t, held)
+
+	first.release()
+	held, _ = second.Hold()
+	assert.True(t, held)
+	second.release()
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type PgLogStore struct {
+	db *gorm.DB
+}
+
+func NewPgLogStore(db *gorm.DB) *PgLogStore {
+	return &PgLogStore{
+		db: db,
+	}
+}
+
+func (s *PgLogStore) Append(line *LogLine) error {
+	return s.db.Create(line).Error
+}
+
+func (s *PgLogStore) Read(runID uint, offset, limit int) ([]*LogLine, error) {
+	lines := []*LogLine{}
+	query := s.db.Where(&LogLine{RunID: runID}).Where("seq >= ?", offset).Order("seq")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&lines).Error; err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
@@ -0,0 +1,11 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestPgLogStore(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	testLogStore(t, NewPgLogStore(tx))
+}
@@ -1,8 +1,13 @@
 package models
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/lib/pq"
 )
 
 type PgJobRepo struct {
@@ -15,14 +20,66 @@ func NewPgJobRepo(db *gorm.DB) *PgJobRepo {
 	}
 }
 
-func (r *PgJobRepo) Find() ([]*Job, error) {
+func (r *PgJobRepo) Find(query *JobQuery) ([]*Job, error) {
+	after, err := query.after()
+	if err != nil {
+		return nil, err
+	}
+	db := r.db.Where(&Job{Owner: query.Owner})
+	if query.NamePrefix != "" {
+		db = db.Where(`name LIKE ? ESCAPE '\'`, likePrefix(query.NamePrefix))
+	}
+	if len(query.Labels) > 0 {
+		labels, _ := query.Labels.Value()
+		db = db.Where("labels::jsonb @> ?::jsonb", labels)
+	}
+	if query.Scheduled != nil {
+		op := "="
+		if *query.Scheduled {
+			op = "<>"
+		}
+		db = db.Where("coalesce(schedule, '') " + op + " ''")
+	}
+	for cond, bound := range map[string]time.Time{
+		"created_at >= ?": query.CreatedSince, "created_at < ?": query.CreatedUntil,
+		"updated_at >= ?": query.UpdatedSince, "updated_at < ?": query.UpdatedUntil,
+	} {
+		if !bound.IsZero() {
+			db = db.Where(cond, bound)
+		}
+	}
+	column, order, op := JobSorts[query.sort()], "asc", ">"
+	if query.Desc {
+		order, op = "desc", "<"
+	}
+	// Pages are found by the sort value and id of the last job of the
+	// previous one, not by offset, so they stay fast and stable.
+	if after != nil {
+		if column == "id" {
+			db = db.Where("id "+op+" ?", after.ID)
+		} else {
+			db = db.Where(fmt.Sprintf("(%s, id) %s (?, ?)", column, op), query.cursorValue(after), after.ID)
+		}
+	}
+	if column != "id" {
+		db = db.Order(column + " " + order)
+	}
+	db = db.Order("id " + order)
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
 	var jobs []*Job
-	if err := r.db.Find(&jobs).Error; err != nil {
+	if err := db.Find(&jobs).Error; err != nil {
 		return nil, err
 	}
 	return jobs, nil
 }
 
+// likePrefix returns a LIKE pattern that matches what starts with prefix.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
 func (r *PgJobRepo) FindOne(slug string) (*Job, error) {
 	job := Job{}
 	if err := r.db.Where(&Job{Slug: slug}).First(&job).Error; err != nil {
@@ -31,8 +88,19 @@ func (r *PgJobRepo) FindOne(slug string) (*Job, error) {
 	return &job, nil
 }
 
+// uniqueViolation is the code of the Postgres error of a duplicate key.
+const uniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	e, ok := err.(*pq.Error)
+	return ok && e.Code == uniqueViolation
+}
+
 func (r *PgJobRepo) Add(job *Job) (*Job, error) {
 	if err := r.db.Create(job).Error; err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrJobExists
+		}
 		return nil, err
 	}
 	return job, nil
@@ -46,13 +114,57 @@ func (r *PgJobRepo) Update(job *Job) (*Job, error) {
 	return &newJob, nil
 }
 
+func (r *PgJobRepo) Replace(job *Job) (*Job, error) {
+	return r.Update(job)
+}
+
+func (r *PgJobRepo) ReplaceVersion(job *Job, version int) (*Job, error) {
+	newJob := *job
+	values := map[string]interface{}{}
+	for _, f := range r.db.NewScope(&newJob).Fields() {
+		if f.IsNormal && !f.IsPrimaryKey {
+			values[f.DBName] = f.Field.Interface()
+		}
+	}
+	// The run times are the scheduler's, which may have changed since the
+	// job was read. The next run is only cleared if the schedule changes,
+	// which the condition compares with the stored row.
+	delete(values, "last_run_at")
+	values["next_run_at"] = gorm.Expr("CASE WHEN schedule = ? AND timezone = ? THEN next_run_at END",
+		newJob.Schedule, newJob.Timezone)
+	// The version is checked in the update, so that concurrent updates
+	// cannot both succeed.
+	db := r.db.Model(&Job{}).Where("id = ? AND version = ?", job.ID, version).Updates(values)
+	if db.Error != nil {
+		return nil, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return nil, ErrVersionConflict
+	}
+	return r.FindOne(job.Slug)
+}
+
+func (r *PgJobRepo) UpdateRunTimes(slug string, nextRunAt, lastRunAt *time.Time) error {
+	// The columns are updated without the rest of the job, or its
+	// UpdatedAt, which are the user's.
+	db := r.db.Model(&Job{}).Where("slug = ?", slug).
+		UpdateColumns(map[string]interface{}{"next_run_at": nextRunAt, "last_run_at": lastRunAt})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return fmt.Errorf("Cannot find job with slug %s", slug)
+	}
+	return nil
+}
+
 func (r *PgJobRepo) UpAdd(job *Job) (*Job, error) {
 	existingJob, err := r.FindOne(job.Slug)
 	if err != nil {
 		return r.Add(job)
 	}
+	existingJob.update(job)
 	return r.Update(existingJob)
-
 }
 
 func (r *PgJobRepo) Delete(slug string) (*Job, error) {
@@ -60,8 +172,26 @@ func (r *PgJobRepo) Delete(slug string) (*Job, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err := r.db.Delete(job).Error; err != nil {
+	// Deleted jobs are removed, not only marked, so that their slugs can
+	// be used again.
+	if err := r.db.Unscoped().Delete(job).Error; err != nil {
 		return nil, err
 	}
 	return job, nil
 }
+
+func (r *PgJobRepo) DeleteVersion(slug string, version int) (*Job, error) {
+	job, err := r.FindOne(slug)
+	if err != nil {
+		return nil, err
+	}
+	// As in ReplaceVersion, the version is checked in the delete.
+	db := r.db.Unscoped().Where("version = ?", version).Delete(job)
+	if db.Error != nil {
+		return nil, db.Error
+	}
+	if db.RowsAffected == 0 {
+		return nil, ErrVersionConflict
+	}
+	return job, nil
+}
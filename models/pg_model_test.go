@@ -3,6 +3,7 @@ package models
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
@@ -19,8 +20,22 @@ func TestMain(m *testing.M) {
 	}
 	defer db.Close() // errcheck-ignore
 
-	db.AutoMigrate(&Job{})
+	db.AutoMigrate(&Job{}, &Run{}, &Attempt{}, &LogLine{}, &Pipeline{}, &PipelineRun{}, &QueueItem{}, &Worker{}, &Pool{}, &Team{}, &Artifact{}, &Secret{}, &Token{}, &Grant{}, &AuditEvent{}, &JobVersion{})
 	db.Delete(&Job{})
+	db.Delete(&Run{})
+	db.Delete(&Attempt{})
+	db.Delete(&LogLine{})
+	db.Delete(&Pipeline{})
+	db.Delete(&PipelineRun{})
+	db.Delete(&QueueItem{})
+	db.Delete(&Worker{})
+	db.Delete(&Pool{})
+	db.Delete(&Team{})
+	db.Delete(&Artifact{})
+	db.Delete(&Secret{})
+	db.Delete(&Token{})
+	db.Delete(&Grant{})
+	db.Delete(&JobVersion{})
 	code := m.Run()
 
 	os.Exit(code)
@@ -33,7 +48,7 @@ func TestPgJobsAddFind(t *testing.T) {
 	job := NewJob("Dingo")
 	job, err := jobRepo.Add(job)
 	assert.Nil(t, err)
-	jobs, err := jobRepo.Find()
+	jobs, err := jobRepo.Find(&JobQuery{})
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(jobs))
 }
@@ -50,6 +65,114 @@ func TestPgJobsUpdate(t *testing.T) {
 	assert.True(t, updatedJob.UpdatedAt.After(job.UpdatedAt), "Expected '%v' to be after '%v'", updatedJob.UpdatedAt, job.UpdatedAt)
 }
 
+func TestPgJobsReplaceVersion(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	jobRepo := NewPgJobRepo(tx)
+	job := NewJob("Dingo")
+	job.Version = 1
+	job, err := jobRepo.Add(job)
+	assert.Nil(t, err)
+	replacement := *job
+	replacement.Command = "make"
+	replacement.Version = 2
+	j, err := jobRepo.ReplaceVersion(&replacement, 2)
+	assert.Nil(t, j)
+	assert.Equal(t, ErrVersionConflict, err)
+	j, err = jobRepo.ReplaceVersion(&replacement, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, j.Version)
+	assert.Equal(t, "make", j.Command)
+}
+
+func TestPgJobsReplaceVersionKeepsRunTimes(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	jobRepo := NewPgJobRepo(tx)
+	job := NewJob("Dingo")
+	job.Schedule = "0 * * * *"
+	job, err := jobRepo.Add(job)
+	assert.Nil(t, err)
+	next, last := time.Now().Add(time.Hour), time.Now()
+	assert.Nil(t, jobRepo.UpdateRunTimes("dingo", &next, &last))
+	replacement := *job
+	replacement.Command = "make"
+	j, err := jobRepo.ReplaceVersion(&replacement, 0)
+	assert.Nil(t, err)
+	assert.NotNil(t, j.NextRunAt)
+	assert.NotNil(t, j.LastRunAt)
+	replacement.Schedule = "30 * * * *"
+	j, err = jobRepo.ReplaceVersion(&replacement, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, j.NextRunAt)
+	assert.NotNil(t, j.LastRunAt)
+}
+
+func TestPgJobsDeleteVersion(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	jobRepo := NewPgJobRepo(tx)
+	job := NewJob("Dingo")
+	job.Version = 1
+	_, _ = jobRepo.Add(job)
+	j, err := jobRepo.DeleteVersion("dingo", 2)
+	assert.Nil(t, j)
+	assert.Equal(t, ErrVersionConflict, err)
+	j, err = jobRepo.DeleteVersion("dingo", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "dingo", j.Slug)
+	_, err = jobRepo.FindOne("dingo")
+	assert.NotNil(t, err)
+}
+
+func TestPgJobsFindQuery(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	jobRepo := NewPgJobRepo(tx)
+	build, deploy, backup := NewJob("Build"), NewJob("Deploy"), NewJob("Back_up")
+	build.Owner, deploy.Owner, backup.Owner = "alice", "bob", "alice"
+	deploy.Labels = StringMap{"zone": "a", "os": "linux"}
+	backup.Schedule = "@daily"
+	for _, j := range []*Job{build, deploy, backup} {
+		_, err := jobRepo.Add(j)
+		assert.Nil(t, err)
+	}
+	scheduled := true
+	jobs, err := jobRepo.Find(&JobQuery{NamePrefix: "Back_", Owner: "alice", Scheduled: &scheduled})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(jobs))
+	jobs, _ = jobRepo.Find(&JobQuery{NamePrefix: "B_"})
+	assert.Equal(t, 0, len(jobs))
+	jobs, _ = jobRepo.Find(&JobQuery{Labels: StringMap{"zone": "a"}})
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "deploy", jobs[0].Slug)
+
+	query := &JobQuery{Sort: "name", Desc: true, Limit: 2}
+	jobs, _ = jobRepo.Find(query)
+	assert.Equal(t, 2, len(jobs))
+	assert.Equal(t, "deploy", jobs[0].Slug)
+	assert.Equal(t, "build", jobs[1].Slug)
+	query.After = query.Cursor(jobs[1])
+	jobs, _ = jobRepo.Find(query)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "back_up", jobs[0].Slug)
+
+	query = &JobQuery{Sort: "created", Limit: 1}
+	jobs, _ = jobRepo.Find(query)
+	query.After = query.Cursor(jobs[0])
+	jobs, _ = jobRepo.Find(query)
+	assert.Equal(t, "deploy", jobs[0].Slug)
+
+	// A name that is also a time is compared as a name.
+	_, _ = jobRepo.Add(NewJob("2020-01-01T00:00:00.000000000Z"))
+	query = &JobQuery{Sort: "name", Limit: 1}
+	jobs, _ = jobRepo.Find(query)
+	query.After = query.Cursor(jobs[0])
+	jobs, err = jobRepo.Find(query)
+	assert.Nil(t, err)
+	assert.Equal(t, "back_up", jobs[0].Slug)
+}
+
 func TestPgJobsUpAdd(t *testing.T) {
 	tx := db.Begin()
 	defer tx.Rollback()
@@ -80,7 +203,41 @@ func TestPgJobsDelete(t *testing.T) {
 	job, err := jobRepo.Delete("sloth")
 	assert.Nil(t, err)
 	assert.Equal(t, "Sloth", job.Name)
-	jobs, err := jobRepo.Find()
+	jobs, err := jobRepo.Find(&JobQuery{})
 	assert.Nil(t, err)
 	assert.Equal(t, 1, len(jobs))
 }
+
+func TestPgJobsUniqueSlug(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	jobRepo := NewPgJobRepo(tx)
+	_, _ = jobRepo.Add(NewJob("Dingo"))
+	_, err := jobRepo.Delete("dingo")
+	assert.Nil(t, err)
+	_, err = jobRepo.Add(NewJob("Dingo"))
+	assert.Nil(t, err)
+	_, err = jobRepo.Add(NewJob("dingo"))
+	assert.Equal(t, ErrJobExists, err)
+}
+
+func TestPgJobsCommand(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	jobRepo := NewPgJobRepo(tx)
+	job := NewJob("Dingo")
+	job.Command = "echo"
+	job.Args = StringList{"hello", "world"}
+	job.Env = StringMap{"LANG": "C"}
+	job.Dir = "/tmp"
+	job.Timeout = Duration(30 * time.Second)
+	_, err := jobRepo.Add(job)
+	assert.Nil(t, err)
+	found, err := jobRepo.FindOne("dingo")
+	assert.Nil(t, err)
+	assert.Equal(t, "echo", found.Command)
+	assert.Equal(t, StringList{"hello", "world"}, found.Args)
+	assert.Equal(t, StringMap{"LANG": "C"}, found.Env)
+	assert.Equal(t, "/tmp", found.Dir)
+	assert.Equal(t, 30*time.Second, found.Timeout.Duration())
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type PgPipelineRepo struct {
+	db *gorm.DB
+}
+
+func NewPgPipelineRepo(db *gorm.DB) *PgPipelineRepo {
+	return &PgPipelineRepo{
+		db: db,
+	}
+}
+
+func (r *PgPipelineRepo) Find() ([]*Pipeline, error) {
+	var pipelines []*Pipeline
+	if err := r.db.Find(&pipelines).Error; err != nil {
+		return nil, err
+	}
+	return pipelines, nil
+}
+
+func (r *PgPipelineRepo) FindOne(slug string) (*Pipeline, error) {
+	pipeline := Pipeline{}
+	if err := r.db.Where(&Pipeline{Slug: slug}).First(&pipeline).Error; err != nil {
+		return nil, err
+	}
+	return &pipeline, nil
+}
+
+func (r *PgPipelineRepo) Add(pipeline *Pipeline) (*Pipeline, error) {
+	if err := r.db.Create(pipeline).Error; err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+func (r *PgPipelineRepo) Update(pipeline *Pipeline) (*Pipeline, error) {
+	newPipeline := *pipeline
+	if err := r.db.Save(&newPipeline).Error; err != nil {
+		return nil, err
+	}
+	return &newPipeline, nil
+}
+
+func (r *PgPipelineRepo) UpAdd(pipeline *Pipeline) (*Pipeline, error) {
+	existing, err := r.FindOne(pipeline.Slug)
+	if err != nil {
+		return r.Add(pipeline)
+	}
+	existing.update(pipeline)
+	return r.Update(existing)
+}
+
+func (r *PgPipelineRepo) Delete(slug string) (*Pipeline, error) {
+	pipeline, err := r.FindOne(slug)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.db.Delete(pipeline).Error; err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+type PgPipelineRunRepo struct {
+	db *gorm.DB
+}
+
+func NewPgPipelineRunRepo(db *gorm.DB) *PgPipelineRunRepo {
+	return &PgPipelineRunRepo{
+		db: db,
+	}
+}
+
+func (r *PgPipelineRunRepo) Find(pipelineSlug string) ([]*PipelineRun, error) {
+	var runs []*PipelineRun
+	if err := r.db.Where(&PipelineRun{PipelineSlug: pipelineSlug}).Order("id").Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (r *PgPipelineRunRepo) FindOne(id uint) (*PipelineRun, error) {
+	run := PipelineRun{}
+	if err := r.db.First(&run, id).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (r *PgPipelineRunRepo) Add(run *PipelineRun) (*PipelineRun, error) {
+	if err := r.db.Create(run).Error; err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (r *PgPipelineRunRepo) Update(run *PipelineRun) (*PipelineRun, error) {
+	if err := r.db.Save(run).Error; err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (r *PgPipelineRunRepo) Unfinished() ([]*PipelineRun, error) {
+	var runs []*PipelineRun
+	if err := r.db.Where("state IN (?)", []RunState{Queued, Running}).Order("id").Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (r *PgPipelineRunRepo) Claim(id uint, lease time.Duration) (*PipelineRun, error) {
+	now := gorm.NowFunc()
+	// The lease is checked in the update, so that only one server claims
+	// the run.
+	update := r.db.Model(&PipelineRun{}).
+		Where("id = ? AND state IN (?) AND (lease_expires_at IS NULL OR lease_expires_at < ?)", id, []RunState{Queued, Running}, now).
+		Update("lease_expires_at", now.Add(lease))
+	if update.Error != nil {
+		return nil, update.Error
+	}
+	if update.RowsAffected == 0 {
+		return nil, nil
+	}
+	return r.FindOne(id)
+}
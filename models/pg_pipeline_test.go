@@ -0,0 +1,64 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgPipelinesAddFindOne(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgPipelineRepo(tx)
+	_, err := repo.Add(NewPipeline("Dingo", node("a"), node("b", "a")))
+	assert.Nil(t, err)
+	pipeline, err := repo.FindOne("dingo")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a"}, pipeline.Nodes[1].DependsOn)
+}
+
+func TestPgPipelinesUpAddDelete(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgPipelineRepo(tx)
+	_, _ = repo.Add(NewPipeline("Dingo", node("a")))
+	pipeline, err := repo.UpAdd(NewPipeline("Dingo", node("a"), node("b")))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(pipeline.Nodes))
+	_, err = repo.Delete("dingo")
+	assert.Nil(t, err)
+	pipelines, _ := repo.Find()
+	assert.Equal(t, 0, len(pipelines))
+}
+
+func TestPgPipelineRuns(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgPipelineRunRepo(tx)
+	run, err := repo.Add(NewPipelineRun(NewPipeline("Dingo", node("a"))))
+	assert.Nil(t, err)
+	run.Node("a").State = Succeeded
+	_, err = repo.Update(run)
+	assert.Nil(t, err)
+	found, err := repo.FindOne(run.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, Succeeded, found.Node("a").State)
+}
+
+func TestPgPipelineRunsClaim(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgPipelineRunRepo(tx)
+	run, _ := repo.Add(NewPipelineRun(NewPipeline("Dingo", node("a"))))
+	runs, err := repo.Unfinished()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(runs))
+
+	claimed, err := repo.Claim(run.ID, time.Minute)
+	assert.Nil(t, err)
+	assert.NotNil(t, claimed.LeaseExpiresAt)
+	claimed, err = repo.Claim(run.ID, time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, claimed)
+}
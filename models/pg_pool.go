@@ -0,0 +1,66 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type PgPoolRepo struct {
+	db *gorm.DB
+}
+
+func NewPgPoolRepo(db *gorm.DB) *PgPoolRepo {
+	return &PgPoolRepo{
+		db: db,
+	}
+}
+
+func (r *PgPoolRepo) Find() ([]*Pool, error) {
+	var pools []*Pool
+	if err := r.db.Find(&pools).Error; err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+func (r *PgPoolRepo) FindOne(slug string) (*Pool, error) {
+	pool := Pool{}
+	if err := r.db.Where(&Pool{Slug: slug}).First(&pool).Error; err != nil {
+		return nil, err
+	}
+	return &pool, nil
+}
+
+func (r *PgPoolRepo) Add(pool *Pool) (*Pool, error) {
+	if err := r.db.Create(pool).Error; err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+func (r *PgPoolRepo) Update(pool *Pool) (*Pool, error) {
+	newPool := *pool
+	if err := r.db.Save(&newPool).Error; err != nil {
+		return nil, err
+	}
+	return &newPool, nil
+}
+
+func (r *PgPoolRepo) UpAdd(pool *Pool) (*Pool, error) {
+	existing, err := r.FindOne(pool.Slug)
+	if err != nil {
+		return r.Add(pool)
+	}
+	existing.update(pool)
+	return r.Update(existing)
+}
+
+func (r *PgPoolRepo) Delete(slug string) (*Pool, error) {
+	pool, err := r.FindOne(slug)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.db.Delete(pool).Error; err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
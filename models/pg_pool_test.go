@@ -0,0 +1,21 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgPoolsAddFind(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgPoolRepo(tx)
+	_, err := repo.Add(NewPool("Reporting", 4))
+	assert.Nil(t, err)
+	pool, err := repo.UpAdd(&Pool{Slug: "reporting", Size: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, pool.Size)
+	pool, _ = repo.FindOne("reporting")
+	assert.Equal(t, "Reporting", pool.Name)
+	assert.Equal(t, 2, pool.Size)
+}
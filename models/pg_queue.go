@@ -0,0 +1,276 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PgQueue keeps the queue in the queue_items table. Workers in any number
+// of processes can claim from it at the same time, since each claim skips
+// the rows locked by the others. Leases are timed by the database clock.
+type PgQueue struct {
+	db *gorm.DB
+}
+
+func NewPgQueue(db *gorm.DB) *PgQueue {
+	return &PgQueue{
+		db: db,
+	}
+}
+
+func (q *PgQueue) Enqueue(job *Job, run *Run) error {
+	return q.db.Create(&QueueItem{
+		RunID:          run.ID,
+		JobSlug:        run.JobSlug,
+		Labels:         job.Labels,
+		AntiAffinity:   job.AntiAffinity,
+		MaxConcurrency: job.MaxConcurrency,
+		Pools:          job.Pools,
+		Priority:       run.Priority,
+		Team:           run.Team,
+	}).Error
+}
+
+// claimLock is the advisory lock that the claims of items with concurrency
+// limits or pools are made under, so that their claimed runs are counted the
+// same by all workers. Other claims only skip each other's locked rows. The
+// team shares are counted without the lock, so concurrent claims may favor
+// the same team once, which the next claims make up for. The order is the
+// first step of queueState.order.
+const claimLock = 7310
+
+// errOverLimit is returned when an item claimed without the lock turns out
+// to exceed its limits.
+var errOverLimit = errors.New("Claimed item is over its limits")
+
+const claimSql = `
+UPDATE queue_items
+SET claimed_by = ?, claimed_at = now(),
+	lease_expires_at = now() + ? * interval '1 millisecond', leases = leases + 1
+WHERE id = (
+	SELECT id FROM queue_items q
+	WHERE (claimed_by = '' OR lease_expires_at < now())
+	AND labels::jsonb <@ ?::jsonb
+	AND NOT EXISTS (
+		SELECT 1 FROM jsonb_each_text(anti_affinity::jsonb) a
+		WHERE ?::jsonb ->> a.key = a.value
+	)
+	AND (max_concurrency = 0 OR (
+		SELECT count(*) FROM queue_items c
+		WHERE c.job_slug = q.job_slug AND c.claimed_by <> '' AND c.lease_expires_at >= now()
+	) < max_concurrency)
+	AND NOT EXISTS (
+		SELECT 1 FROM jsonb_array_elements_text(pools::jsonb) p
+		WHERE (
+			SELECT count(*) FROM queue_items c
+			WHERE c.claimed_by <> '' AND c.lease_expires_at >= now()
+			AND jsonb_exists(c.pools::jsonb, p.value)
+		) >= coalesce((
+			SELECT size FROM pools WHERE slug = p.value AND deleted_at IS NULL
+		), 0)
+	)
+	ORDER BY (
+		SELECT count(*) FROM queue_items c
+		WHERE c.team = q.team AND c.claimed_by <> '' AND c.lease_expires_at >= now()
+	)::float / coalesce((
+		SELECT weight FROM teams WHERE slug = q.team AND deleted_at IS NULL AND weight > 0
+	), 1), priority DESC, id
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`
+
+func (q *PgQueue) Claim(worker *Worker, lease time.Duration) (*QueueItem, error) {
+	labels, err := worker.Labels.Value()
+	if err != nil {
+		return nil, err
+	}
+	args := []interface{}{worker.Name, milliseconds(lease), labels, labels}
+	var item *QueueItem
+	err = q.transaction(func(tx *PgQueue) error {
+		item, err = tx.returning(claimSql, args...)
+		if err != nil || item == nil || !item.limited() {
+			return err
+		}
+		// The limits were counted without the claims of concurrent
+		// transactions, so they are counted again once these have
+		// committed.
+		if err := tx.lock(); err != nil {
+			return err
+		}
+		within, err := tx.withinLimits(item.ID)
+		if err == nil && !within {
+			err = errOverLimit
+		}
+		return err
+	})
+	if err != errOverLimit {
+		return item, err
+	}
+	err = q.transaction(func(tx *PgQueue) error {
+		if err := tx.lock(); err != nil {
+			return err
+		}
+		item, err = tx.returning(claimSql, args...)
+		return err
+	})
+	return item, err
+}
+
+func (q *PgQueue) lock() error {
+	return q.db.Exec("SELECT pg_advisory_xact_lock(?)", claimLock).Error
+}
+
+// withinLimitsSql counts the claimed item among the claimed runs.
+const withinLimitsSql = `
+SELECT count(*) FROM queue_items q
+WHERE id = ?
+AND (max_concurrency = 0 OR (
+	SELECT count(*) FROM queue_items c
+	WHERE c.job_slug = q.job_slug AND c.claimed_by <> '' AND c.lease_expires_at >= now()
+) <= max_concurrency)
+AND NOT EXISTS (
+	SELECT 1 FROM jsonb_array_elements_text(pools::jsonb) p
+	WHERE (
+		SELECT count(*) FROM queue_items c
+		WHERE c.claimed_by <> '' AND c.lease_expires_at >= now()
+		AND jsonb_exists(c.pools::jsonb, p.value)
+	) > coalesce((
+		SELECT size FROM pools WHERE slug = p.value AND deleted_at IS NULL
+	), 0)
+)`
+
+// withinLimits tells if the claimed item is within its concurrency limit
+// and the sizes of its pools.
+func (q *PgQueue) withinLimits(id uint) (bool, error) {
+	var n int
+	if err := q.db.Raw(withinLimitsSql, id).Row().Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// transaction calls f with the queue in a transaction, unless it already
+// is in one.
+func (q *PgQueue) transaction(f func(tx *PgQueue) error) error {
+	if _, ok := q.db.CommonDB().(*sql.Tx); ok {
+		return f(q)
+	}
+	tx := q.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := f(&PgQueue{db: tx}); err != nil {
+		tx.Rollback() // errcheck-ignore
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (q *PgQueue) WaitingOn(runID uint) ([]string, error) {
+	item := QueueItem{}
+	found := q.db.Where("run_id = ? AND claimed_by = ''", runID).First(&item)
+	if found.RecordNotFound() {
+		return []string{}, nil
+	}
+	if found.Error != nil {
+		return nil, found.Error
+	}
+	state, err := q.state()
+	if err != nil {
+		return nil, err
+	}
+	return state.waitingOn(&item), nil
+}
+
+func (q *PgQueue) Queued() ([]*QueueItem, error) {
+	var waiting []*QueueItem
+	if err := q.db.Where("claimed_by = '' OR lease_expires_at < now()").Find(&waiting).Error; err != nil {
+		return nil, err
+	}
+	state, err := q.state()
+	if err != nil {
+		return nil, err
+	}
+	return state.queued(waiting), nil
+}
+
+func (q *PgQueue) state() (*queueState, error) {
+	state := newQueueState()
+	if err := q.db.Where("claimed_by <> '' AND lease_expires_at >= now()").Find(&state.claimed).Error; err != nil {
+		return nil, err
+	}
+	var pools []*Pool
+	if err := q.db.Find(&pools).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range pools {
+		state.sizes[p.Slug] = p.Size
+	}
+	var teams []*Team
+	if err := q.db.Find(&teams).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range teams {
+		state.weights[t.Slug] = t.Weight
+	}
+	return state, nil
+}
+
+const heartbeatSql = `
+UPDATE queue_items SET lease_expires_at = now() + ? * interval '1 millisecond'
+WHERE run_id = ? AND claimed_by = ?
+RETURNING *`
+
+func (q *PgQueue) Heartbeat(runID uint, worker string, lease time.Duration) (*QueueItem, error) {
+	item, err := q.returning(heartbeatSql, milliseconds(lease), runID, worker)
+	if err == nil && item == nil {
+		return nil, ErrLeaseLost
+	}
+	return item, err
+}
+
+func (q *PgQueue) Release(runID uint) error {
+	return q.db.Model(&QueueItem{}).Where("run_id = ?", runID).
+		Updates(map[string]interface{}{"claimed_by": "", "claimed_at": nil, "lease_expires_at": nil}).Error
+}
+
+func (q *PgQueue) Cancel(runID uint, by string) (bool, error) {
+	if err := q.db.Where("run_id = ? AND claimed_by = ''", runID).Delete(&QueueItem{}).Error; err != nil {
+		return false, err
+	}
+	update := q.db.Model(&QueueItem{}).Where("run_id = ?", runID).Update("cancelled_by", by)
+	return update.RowsAffected > 0, update.Error
+}
+
+func (q *PgQueue) Remove(runID uint) error {
+	return q.db.Where("run_id = ?", runID).Delete(&QueueItem{}).Error
+}
+
+func (q *PgQueue) Find() ([]*QueueItem, error) {
+	items := []*QueueItem{}
+	if err := q.db.Order("id").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// returning runs an update of at most one item and returns the item, or
+// nil if none was updated.
+func (q *PgQueue) returning(sql string, values ...interface{}) (*QueueItem, error) {
+	var items []*QueueItem
+	if err := q.db.Raw(sql, values...).Scan(&items).Error; err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	return items[0], nil
+}
+
+func milliseconds(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
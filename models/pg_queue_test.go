@@ -0,0 +1,170 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgQueueClaim(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	queue := NewPgQueue(tx)
+	for _, id := range []uint{1, 2} {
+		assert.Nil(t, queue.Enqueue(NewJob("One"), queuedRun(id)))
+	}
+	item, err := queue.Claim(&Worker{Name: "a"}, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), item.RunID)
+	assert.Equal(t, "a", item.ClaimedBy)
+	assert.NotNil(t, item.ClaimedAt)
+	item, _ = queue.Claim(&Worker{Name: "b"}, time.Minute)
+	assert.Equal(t, uint(2), item.RunID)
+	item, err = queue.Claim(&Worker{Name: "c"}, time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, item)
+}
+
+func TestPgQueueRemove(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	queue := NewPgQueue(tx)
+	_ = queue.Enqueue(NewJob("One"), queuedRun(1))
+	_ = queue.Enqueue(NewJob("One"), queuedRun(2))
+	assert.Nil(t, queue.Remove(1))
+	items, err := queue.Find()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, uint(2), items[0].RunID)
+}
+
+func TestPgQueueLease(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	queue := NewPgQueue(tx)
+	_ = queue.Enqueue(NewJob("One"), queuedRun(1))
+	_, _ = queue.Claim(&Worker{Name: "a"}, -time.Second)
+	item, err := queue.Claim(&Worker{Name: "b"}, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, "b", item.ClaimedBy)
+	assert.Equal(t, 2, item.Leases)
+	_, err = queue.Heartbeat(1, "a", time.Minute)
+	assert.Equal(t, ErrLeaseLost, err)
+	_, err = queue.Heartbeat(1, "b", time.Minute)
+	assert.Nil(t, err)
+	item, _ = queue.Claim(&Worker{Name: "c"}, time.Minute)
+	assert.Nil(t, item)
+	assert.Nil(t, queue.Release(1))
+	item, _ = queue.Claim(&Worker{Name: "c"}, time.Minute)
+	assert.Equal(t, "c", item.ClaimedBy)
+}
+
+func TestPgQueueCancel(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	queue := NewPgQueue(tx)
+	_ = queue.Enqueue(NewJob("One"), queuedRun(1))
+	_ = queue.Enqueue(NewJob("One"), queuedRun(2))
+	_, _ = queue.Claim(&Worker{Name: "a"}, time.Minute)
+	claimed, err := queue.Cancel(2, "alice")
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+	claimed, err = queue.Cancel(1, "alice")
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+	items, _ := queue.Find()
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, "alice", items[0].CancelledBy)
+}
+
+func TestPgQueuePlacement(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	queue := NewPgQueue(tx)
+	gpu := NewJob("Gpu")
+	gpu.Labels = StringMap{"pool": "gpu"}
+	notA := NewJob("NotA")
+	notA.AntiAffinity = StringMap{"zone": "a"}
+	_ = queue.Enqueue(gpu, queuedRun(1))
+	_ = queue.Enqueue(notA, queuedRun(2))
+
+	item, err := queue.Claim(&Worker{Name: "a", Labels: StringMap{"zone": "a"}}, time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, item)
+	item, _ = queue.Claim(&Worker{Name: "b", Labels: StringMap{"zone": "b"}}, time.Minute)
+	assert.Equal(t, uint(2), item.RunID)
+	item, _ = queue.Claim(&Worker{Name: "c", Labels: StringMap{"pool": "gpu", "zone": "a"}}, time.Minute)
+	assert.Equal(t, uint(1), item.RunID)
+}
+
+func TestPgQueueLimits(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	_, _ = NewPgPoolRepo(tx).Add(NewPool("Reporting", 2))
+	queue := NewPgQueue(tx)
+	single := NewJob("Single")
+	single.MaxConcurrency = 1
+	report := NewJob("Report")
+	report.Pools = StringList{"reporting"}
+	_ = queue.Enqueue(single, queuedRunOf(single, 1))
+	_ = queue.Enqueue(single, queuedRunOf(single, 2))
+	for id := uint(3); id <= 5; id++ {
+		_ = queue.Enqueue(report, queuedRunOf(report, id))
+	}
+	worker := &Worker{Name: "a"}
+	var claimed []uint
+	for item, _ := queue.Claim(worker, time.Minute); item != nil; item, _ = queue.Claim(worker, time.Minute) {
+		claimed = append(claimed, item.RunID)
+	}
+	assert.Equal(t, []uint{1, 3, 4}, claimed)
+	waiting, err := queue.WaitingOn(2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"job single (1/1)"}, waiting)
+	waiting, _ = queue.WaitingOn(5)
+	assert.Equal(t, []string{"pool reporting (2/2)"}, waiting)
+}
+
+func TestPgQueueWithinLimits(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	queue := NewPgQueue(tx)
+	single := NewJob("Single")
+	single.MaxConcurrency = 1
+	_ = queue.Enqueue(single, queuedRunOf(single, 1))
+	_ = queue.Enqueue(single, queuedRunOf(single, 2))
+	item, _ := queue.Claim(&Worker{Name: "a"}, time.Minute)
+	within, err := queue.withinLimits(item.ID)
+	assert.Nil(t, err)
+	assert.True(t, within)
+	// As if another worker had claimed the other run at the same time.
+	tx.Exec("UPDATE queue_items SET claimed_by = 'b', lease_expires_at = now() + interval '1 minute' WHERE run_id = 2")
+	within, _ = queue.withinLimits(item.ID)
+	assert.False(t, within)
+}
+
+func TestPgQueueFairShare(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	_, _ = NewPgTeamRepo(tx).Add(NewTeam("Backfill", 1))
+	queue := NewPgQueue(tx)
+	backfill := NewJob("Backfill")
+	backfill.Team = "backfill"
+	web := NewJob("Web")
+	web.Team = "web"
+	web.Priority = -1
+	for id := uint(1); id <= 3; id++ {
+		_ = queue.Enqueue(backfill, queuedRunOf(backfill, id))
+	}
+	_ = queue.Enqueue(web, queuedRunOf(web, 4))
+
+	queued, err := queue.Queued()
+	assert.Nil(t, err)
+	assert.Equal(t, []uint{1, 4, 2, 3}, runIDs(queued))
+	worker := &Worker{Name: "a"}
+	var claimed []uint
+	for item, _ := queue.Claim(worker, time.Minute); item != nil; item, _ = queue.Claim(worker, time.Minute) {
+		claimed = append(claimed, item.RunID)
+	}
+	assert.Equal(t, []uint{1, 4, 2, 3}, claimed)
+}
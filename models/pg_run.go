@@ -0,0 +1,45 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type PgRunRepo struct {
+	db *gorm.DB
+}
+
+func NewPgRunRepo(db *gorm.DB) *PgRunRepo {
+	return &PgRunRepo{
+		db: db,
+	}
+}
+
+func (r *PgRunRepo) Find(jobSlug string) ([]*Run, error) {
+	var runs []*Run
+	if err := r.db.Preload("Attempts").Where(&Run{JobSlug: jobSlug}).Order("id").Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (r *PgRunRepo) FindOne(id uint) (*Run, error) {
+	run := Run{}
+	if err := r.db.Preload("Attempts").First(&run, id).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (r *PgRunRepo) Add(run *Run) (*Run, error) {
+	if err := r.db.Create(run).Error; err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func (r *PgRunRepo) Update(run *Run) (*Run, error) {
+	if err := r.db.Save(run).Error; err != nil {
+		return nil, err
+	}
+	return run, nil
+}
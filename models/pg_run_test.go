@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgRunsAddFind(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	runRepo := NewPgRunRepo(tx)
+	_, err := runRepo.Add(NewRun(NewJob("Dingo")))
+	assert.Nil(t, err)
+	_, _ = runRepo.Add(NewRun(NewJob("Sloth")))
+	runs, err := runRepo.Find("dingo")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(runs))
+}
+
+func TestPgRunsUpdate(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	runRepo := NewPgRunRepo(tx)
+	run, _ := runRepo.Add(NewRun(NewJob("Dingo")))
+	run.Start()
+	run.Finish(Succeeded, 0)
+	_, err := runRepo.Update(run)
+	assert.Nil(t, err)
+	found, err := runRepo.FindOne(run.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, Succeeded, found.State)
+	assert.NotNil(t, found.EndedAt)
+}
+
+func TestPgRunsAttempts(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	runRepo := NewPgRunRepo(tx)
+	run, _ := runRepo.Add(NewRun(NewJob("Dingo")))
+	run.Start()
+	run.StartAttempt().Finish(Failed, 1)
+	_, err := runRepo.Update(run)
+	assert.Nil(t, err)
+	run.StartAttempt().Finish(Succeeded, 0)
+	_, err = runRepo.Update(run)
+	assert.Nil(t, err)
+	found, err := runRepo.FindOne(run.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(found.Attempts))
+	assert.Equal(t, 1, found.Attempts[0].ExitCode)
+	assert.Equal(t, 2, found.Attempts[1].Number)
+}
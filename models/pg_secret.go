@@ -0,0 +1,91 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type PgSecretRepo struct {
+	db *gorm.DB
+}
+
+func NewPgSecretRepo(db *gorm.DB) *PgSecretRepo {
+	return &PgSecretRepo{
+		db: db,
+	}
+}
+
+func (r *PgSecretRepo) Find() ([]*Secret, error) {
+	var secrets []*Secret
+	if err := r.db.Order("slug").Find(&secrets).Error; err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func (r *PgSecretRepo) FindScoped(job *Job) ([]*Secret, error) {
+	var secrets []*Secret
+	query := r.db.Where("(job <> '' AND job = ?) OR (team <> '' AND team = ?)", job.Slug, job.Team)
+	if err := query.Order("slug").Find(&secrets).Error; err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func (r *PgSecretRepo) FindOne(slug string) (*Secret, error) {
+	secret := Secret{}
+	if err := r.db.Where(&Secret{Slug: slug}).First(&secret).Error; err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+func (r *PgSecretRepo) Add(secret *Secret) (*Secret, error) {
+	if err := r.db.Create(secret).Error; err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrSecretExists
+		}
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (r *PgSecretRepo) Update(secret *Secret) (*Secret, error) {
+	newSecret := *secret
+	if err := r.db.Save(&newSecret).Error; err != nil {
+		return nil, err
+	}
+	return &newSecret, nil
+}
+
+func (r *PgSecretRepo) UpAdd(secret *Secret) (*Secret, error) {
+	existing, err := r.FindOne(secret.Slug)
+	if err != nil {
+		return r.Add(secret)
+	}
+	existing.update(secret)
+	return r.Update(existing)
+}
+
+func (r *PgSecretRepo) Delete(slug string) (*Secret, error) {
+	secret, err := r.FindOne(slug)
+	if err != nil {
+		return nil, err
+	}
+	// Deleted secrets are removed, not only marked, so that their slugs
+	// can be used again.
+	if err := r.db.Unscoped().Delete(secret).Error; err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (r *PgSecretRepo) DeleteJob(job string) ([]*Secret, error) {
+	var secrets []*Secret
+	if err := r.db.Where("job = ?", job).Order("slug").Find(&secrets).Error; err != nil {
+		return nil, err
+	}
+	if err := r.db.Unscoped().Where("job = ?", job).Delete(&Secret{}).Error; err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgSecretsFindScoped(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgSecretRepo(tx)
+	_, err := repo.Add(&Secret{Name: "A", Slug: "a", Job: "one", Env: "A", Ciphertext: "x"})
+	assert.Nil(t, err)
+	_, _ = repo.Add(&Secret{Name: "B", Slug: "b", Team: "ops", Env: "B"})
+	_, _ = repo.Add(&Secret{Name: "C", Slug: "c", Job: "two", Env: "C"})
+	job := NewJob("One")
+	secrets, err := repo.FindScoped(job)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(secrets))
+	assert.Equal(t, "x", secrets[0].Ciphertext)
+	job.Team = "ops"
+	secrets, _ = repo.FindScoped(job)
+	assert.Equal(t, 2, len(secrets))
+}
+
+func TestPgSecretsUniqueSlug(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgSecretRepo(tx)
+	_, _ = repo.Add(&Secret{Name: "A", Slug: "a", Job: "one", Env: "A"})
+	_, err := repo.Delete("a")
+	assert.Nil(t, err)
+	_, err = repo.Add(&Secret{Name: "A", Slug: "a", Job: "two", Env: "A"})
+	assert.Nil(t, err)
+	_, err = repo.Add(&Secret{Name: "A", Slug: "a", Team: "ops", Env: "A"})
+	assert.Equal(t, ErrSecretExists, err)
+}
+
+func TestPgSecretsDeleteJob(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgSecretRepo(tx)
+	_, _ = repo.Add(&Secret{Name: "A", Slug: "a", Job: "one", Env: "A"})
+	_, _ = repo.Add(&Secret{Name: "B", Slug: "b", Team: "ops", Env: "B"})
+	secrets, err := repo.DeleteJob("one")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(secrets))
+	secrets, _ = repo.Find()
+	assert.Equal(t, 1, len(secrets))
+	_, err = repo.Add(&Secret{Name: "A", Slug: "a", Job: "two", Env: "A"})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type PgTeamRepo struct {
+	db *gorm.DB
+}
+
+func NewPgTeamRepo(db *gorm.DB) *PgTeamRepo {
+	return &PgTeamRepo{
+		db: db,
+	}
+}
+
+func (r *PgTeamRepo) Find() ([]*Team, error) {
+	var teams []*Team
+	if err := r.db.Find(&teams).Error; err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+func (r *PgTeamRepo) FindOne(slug string) (*Team, error) {
+	team := Team{}
+	if err := r.db.Where(&Team{Slug: slug}).First(&team).Error; err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+func (r *PgTeamRepo) Add(team *Team) (*Team, error) {
+	if err := r.db.Create(team).Error; err != nil {
+		return nil, err
+	}
+	return team, nil
+}
+
+func (r *PgTeamRepo) Update(team *Team) (*Team, error) {
+	newTeam := *team
+	if err := r.db.Save(&newTeam).Error; err != nil {
+		return nil, err
+	}
+	return &newTeam, nil
+}
+
+func (r *PgTeamRepo) UpAdd(team *Team) (*Team, error) {
+	existing, err := r.FindOne(team.Slug)
+	if err != nil {
+		return r.Add(team)
+	}
+	existing.update(team)
+	return r.Update(existing)
+}
+
+func (r *PgTeamRepo) Delete(slug string) (*Team, error) {
+	team, err := r.FindOne(slug)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.db.Delete(team).Error; err != nil {
+		return nil, err
+	}
+	return team, nil
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgTeamsAddFind(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgTeamRepo(tx)
+	_, err := repo.Add(NewTeam("Web", 2))
+	assert.Nil(t, err)
+	team, err := repo.FindOne("web")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, team.Weight)
+	teams, _ := repo.Find()
+	assert.Equal(t, 1, len(teams))
+}
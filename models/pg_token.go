@@ -0,0 +1,46 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type PgTokenRepo struct {
+	db *gorm.DB
+}
+
+func NewPgTokenRepo(db *gorm.DB) *PgTokenRepo {
+	return &PgTokenRepo{
+		db: db,
+	}
+}
+
+func (r *PgTokenRepo) Find() ([]*Token, error) {
+	var tokens []*Token
+	if err := r.db.Order("id").Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (r *PgTokenRepo) FindOne(keyID string) (*Token, error) {
+	token := Token{}
+	if err := r.db.Where(&Token{KeyID: keyID}).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *PgTokenRepo) Add(token *Token) (*Token, error) {
+	if err := r.db.Create(token).Error; err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (r *PgTokenRepo) Update(token *Token) (*Token, error) {
+	newToken := *token
+	if err := r.db.Save(&newToken).Error; err != nil {
+		return nil, err
+	}
+	return &newToken, nil
+}
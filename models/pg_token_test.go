@@ -0,0 +1,26 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgTokensAddFind(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgTokenRepo(tx)
+	token, _ := NewToken("ci", "alice", nil, nil)
+	_, err := repo.Add(token)
+	assert.Nil(t, err)
+	found, err := repo.FindOne(token.KeyID)
+	assert.Nil(t, err)
+	assert.Equal(t, token.Hash, found.Hash)
+	now := time.Now()
+	found.RevokedAt = &now
+	_, err = repo.Update(found)
+	assert.Nil(t, err)
+	found, _ = repo.FindOne(token.KeyID)
+	assert.NotNil(t, found.RevokedAt)
+}
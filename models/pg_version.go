@@ -0,0 +1,45 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+type PgJobVersionRepo struct {
+	db *gorm.DB
+}
+
+func NewPgJobVersionRepo(db *gorm.DB) *PgJobVersionRepo {
+	return &PgJobVersionRepo{
+		db: db,
+	}
+}
+
+func (r *PgJobVersionRepo) Find(slug string) ([]*JobVersion, error) {
+	var versions []*JobVersion
+	if err := r.db.Where(&JobVersion{JobSlug: slug}).Order("version").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (r *PgJobVersionRepo) FindOne(slug string, version int) (*JobVersion, error) {
+	v := JobVersion{}
+	if err := r.db.Where(&JobVersion{JobSlug: slug, Version: version}).First(&v).Error; err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (r *PgJobVersionRepo) Add(version *JobVersion) (*JobVersion, error) {
+	if err := r.db.Create(version).Error; err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrVersionConflict
+		}
+		return nil, err
+	}
+	return version, nil
+}
+
+func (r *PgJobVersionRepo) Delete(slug string, version int) error {
+	return r.db.Where("job_slug = ? AND version = ?", slug, version).Delete(&JobVersion{}).Error
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgJobVersionsAddFind(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgJobVersionRepo(tx)
+	job := &Job{Slug: "deploy", Command: "make", Args: StringList{"deploy"}, Timeout: Duration(60e9), Version: 1}
+	_, err := repo.Add(NewJobVersion(job, "alice"))
+	assert.Nil(t, err)
+	job.Version = 2
+	_, _ = repo.Add(NewJobVersion(job, "bob"))
+
+	versions, err := repo.Find("deploy")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions))
+	v, err := repo.FindOne("deploy", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", v.Author)
+	assert.Equal(t, job.Definition(), v.Job)
+	_, err = repo.Add(NewJobVersion(job, "carol"))
+	assert.Equal(t, ErrVersionConflict, err)
+	assert.Nil(t, repo.Delete("deploy", 2))
+	versions, _ = repo.Find("deploy")
+	assert.Equal(t, 1, len(versions))
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type PgWorkerRepo struct {
+	db *gorm.DB
+}
+
+func NewPgWorkerRepo(db *gorm.DB) *PgWorkerRepo {
+	return &PgWorkerRepo{
+		db: db,
+	}
+}
+
+func (r *PgWorkerRepo) Register(worker *Worker, lease time.Duration) error {
+	if err := r.Remove(worker.Name); err != nil {
+		return err
+	}
+	now := gorm.NowFunc()
+	// Workers that crashed are removed when their leases have expired.
+	if err := r.db.Where("lease_expires_at < ?", now).Delete(&Worker{}).Error; err != nil {
+		return err
+	}
+	worker.ID = 0
+	worker.StartedAt = now
+	worker.HeartbeatAt = now
+	worker.LeaseExpiresAt = now.Add(lease)
+	return r.db.Create(worker).Error
+}
+
+func (r *PgWorkerRepo) Heartbeat(name string, lease time.Duration) error {
+	now := gorm.NowFunc()
+	update := r.db.Model(&Worker{}).Where("name = ?", name).
+		Updates(map[string]interface{}{"heartbeat_at": now, "lease_expires_at": now.Add(lease)})
+	if update.Error == nil && update.RowsAffected == 0 {
+		return fmt.Errorf("No worker found with name: %s", name)
+	}
+	return update.Error
+}
+
+func (r *PgWorkerRepo) Remove(name string) error {
+	return r.db.Where("name = ?", name).Delete(&Worker{}).Error
+}
+
+func (r *PgWorkerRepo) Find() ([]*Worker, error) {
+	workers := []*Worker{}
+	if err := r.db.Where("lease_expires_at > ?", gorm.NowFunc()).Order("name").Find(&workers).Error; err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPgWorkerRepo(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgWorkerRepo(tx)
+	assert.Nil(t, repo.Register(&Worker{Name: "a", Capacity: 2, Labels: StringMap{"os": "linux"}}, time.Minute))
+	assert.Nil(t, repo.Register(&Worker{Name: "a", Capacity: 4}, time.Minute))
+	assert.Nil(t, repo.Register(&Worker{Name: "b", Capacity: 1}, time.Minute))
+	workers, err := repo.Find()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(workers))
+	assert.Equal(t, 4, workers[0].Capacity)
+
+	assert.Nil(t, repo.Heartbeat("a", time.Minute))
+	assert.NotNil(t, repo.Heartbeat("c", time.Minute))
+	assert.Nil(t, repo.Remove("a"))
+	workers, _ = repo.Find()
+	assert.Equal(t, 1, len(workers))
+}
+
+func TestPgWorkerRepoExpires(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+	repo := NewPgWorkerRepo(tx)
+	assert.Nil(t, repo.Register(&Worker{Name: "crashed"}, -time.Second))
+	assert.Nil(t, repo.Register(&Worker{Name: "live"}, time.Minute))
+	workers, err := repo.Find()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(workers))
+	assert.Equal(t, "live", workers[0].Name)
+	assert.NotNil(t, repo.Heartbeat("crashed", time.Minute))
+}
@@ -0,0 +1,284 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PipelineNode runs the job with slug Job when all the nodes it depends on
+// have succeeded. Inputs give the params of the job the outputs of those
+// nodes, as {"version": "build.version"}.
+type PipelineNode struct {
+	Name      string
+	Job       string
+	DependsOn []string `json:"depends_on"`
+	Inputs    map[string]string
+}
+
+// Input returns the node and output that the input refers to.
+func (n *PipelineNode) Input(param string) (node, output string) {
+	ref := n.Inputs[param]
+	if i := strings.Index(ref, "."); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+type PipelineNodes []PipelineNode
+
+func (n PipelineNodes) Value() (driver.Value, error) {
+	b, err := json.Marshal(n)
+	return string(b), err
+}
+
+func (n *PipelineNodes) Scan(src interface{}) error {
+	return scanJson(src, n)
+}
+
+type Pipeline struct {
+	gorm.Model
+	Name  string
+	Slug  string
+	Nodes PipelineNodes `gorm:"type:text"`
+}
+
+func NewPipeline(name string, nodes ...PipelineNode) *Pipeline {
+	return &Pipeline{Name: name, Slug: slug(name), Nodes: nodes}
+}
+
+func (p *Pipeline) update(pipeline *Pipeline) {
+	if pipeline.Name != "" {
+		p.Name = pipeline.Name
+	}
+	if pipeline.Slug != "" {
+		p.Slug = pipeline.Slug
+	}
+	if pipeline.Nodes != nil {
+		p.Nodes = pipeline.Nodes
+	}
+	p.UpdatedAt = time.Now()
+}
+
+func (p *Pipeline) Validate() error {
+	names := map[string]bool{}
+	for _, n := range p.Nodes {
+		if n.Name == "" {
+			return fmt.Errorf("Pipeline node must have a name")
+		}
+		if n.Job == "" {
+			return fmt.Errorf("Pipeline node %s has no job", n.Name)
+		}
+		if names[n.Name] {
+			return fmt.Errorf("Duplicate pipeline node: %s", n.Name)
+		}
+		names[n.Name] = true
+	}
+	for _, n := range p.Nodes {
+		for _, d := range n.DependsOn {
+			if !names[d] {
+				return fmt.Errorf("Pipeline node %s depends on unknown node %s", n.Name, d)
+			}
+		}
+		for param := range n.Inputs {
+			if err := n.validateInput(param); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := p.Order()
+	return err
+}
+
+// validateInput checks that the input refers to an output of a node that the
+// node depends on, so that it has finished when the node runs.
+func (n *PipelineNode) validateInput(param string) error {
+	node, output := n.Input(param)
+	if node == "" || output == "" {
+		return fmt.Errorf("Pipeline node %s input %s must refer to node.output: %q", n.Name, param, n.Inputs[param])
+	}
+	for _, d := range n.DependsOn {
+		if d == node {
+			return nil
+		}
+	}
+	return fmt.Errorf("Pipeline node %s input %s refers to node %s, which it does not depend on", n.Name, param, node)
+}
+
+// Order sorts the nodes topologically into stages. The nodes of a stage
+// only depend on nodes in earlier stages, so they can run in parallel.
+func (p *Pipeline) Order() ([][]PipelineNode, error) {
+	remaining := map[string]PipelineNode{}
+	for _, n := range p.Nodes {
+		remaining[n.Name] = n
+	}
+	done := map[string]bool{}
+	stages := [][]PipelineNode{}
+	for len(remaining) > 0 {
+		stage := []PipelineNode{}
+		for _, n := range remaining {
+			ready := true
+			for _, d := range n.DependsOn {
+				ready = ready && done[d]
+			}
+			if ready {
+				stage = append(stage, n)
+			}
+		}
+		if len(stage) == 0 {
+			names := []string{}
+			for name := range remaining {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("Pipeline has a cycle between nodes: %v", names)
+		}
+		sort.Slice(stage, func(i, j int) bool { return stage[i].Name < stage[j].Name })
+		for _, n := range stage {
+			delete(remaining, n.Name)
+			done[n.Name] = true
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+func ParsePipeline(reader io.ReadCloser) (*Pipeline, error) {
+	if reader == nil {
+		return nil, fmt.Errorf("No body to parse")
+	}
+	decoder := json.NewDecoder(reader)
+	defer reader.Close() // errcheck-ignore
+	var pipeline Pipeline
+	if err := decoder.Decode(&pipeline); err != nil {
+		return nil, err
+	}
+	if pipeline.Slug == "" {
+		pipeline.Slug = slug(pipeline.Name)
+	}
+	if err := pipeline.Validate(); err != nil {
+		return nil, invalid(err)
+	}
+	return &pipeline, nil
+}
+
+type PipelineRepo interface {
+	Find() ([]*Pipeline, error)
+	FindOne(slug string) (*Pipeline, error)
+	Add(pipeline *Pipeline) (*Pipeline, error)
+	Update(pipeline *Pipeline) (*Pipeline, error)
+	UpAdd(pipeline *Pipeline) (*Pipeline, error)
+	Delete(slug string) (*Pipeline, error)
+}
+
+// MemPipelineRepo keeps the pipelines in memory. It returns copies of them,
+// so that they are only changed through the repo.
+type MemPipelineRepo struct {
+	sync.Mutex
+	pipelines []*Pipeline
+	nextID    uint
+}
+
+func NewMemPipelineRepo(pipelines []*Pipeline) *MemPipelineRepo {
+	r := &MemPipelineRepo{
+		pipelines: []*Pipeline{},
+	}
+	for _, p := range pipelines {
+		_, _ = r.Add(p)
+	}
+	return r
+}
+
+func copyPipeline(pipeline *Pipeline) *Pipeline {
+	c := *pipeline
+	if pipeline.Nodes != nil {
+		c.Nodes = append(PipelineNodes{}, pipeline.Nodes...)
+	}
+	return &c
+}
+
+func (r *MemPipelineRepo) Find() ([]*Pipeline, error) {
+	r.Lock()
+	defer r.Unlock()
+	pipelines := []*Pipeline{}
+	for _, p := range r.pipelines {
+		pipelines = append(pipelines, copyPipeline(p))
+	}
+	return pipelines, nil
+}
+
+func (r *MemPipelineRepo) FindOne(slug string) (*Pipeline, error) {
+	r.Lock()
+	defer r.Unlock()
+	p, err := r.findOne(slug)
+	if err != nil {
+		return nil, err
+	}
+	return copyPipeline(p), nil
+}
+
+func (r *MemPipelineRepo) findOne(slug string) (*Pipeline, error) {
+	for _, p := range r.pipelines {
+		if p.Slug == slug {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("No pipeline found with slug: %s", slug)
+}
+
+func (r *MemPipelineRepo) Add(pipeline *Pipeline) (*Pipeline, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.add(pipeline)
+}
+
+func (r *MemPipelineRepo) add(pipeline *Pipeline) (*Pipeline, error) {
+	r.nextID++
+	now := time.Now()
+	pipeline.CreatedAt = now
+	pipeline.UpdatedAt = now
+	pipeline.ID = r.nextID
+	r.pipelines = append(r.pipelines, copyPipeline(pipeline))
+	return pipeline, nil
+}
+
+func (r *MemPipelineRepo) Update(pipeline *Pipeline) (*Pipeline, error) {
+	r.Lock()
+	defer r.Unlock()
+	p, _ := r.findOne(pipeline.Slug)
+	if p == nil {
+		return nil, fmt.Errorf("Cannot find pipeline with slug %s", pipeline.Slug)
+	}
+	p.update(pipeline)
+	return copyPipeline(p), nil
+}
+
+func (r *MemPipelineRepo) UpAdd(pipeline *Pipeline) (*Pipeline, error) {
+	r.Lock()
+	defer r.Unlock()
+	p, _ := r.findOne(pipeline.Slug)
+	if p == nil {
+		return r.add(pipeline)
+	}
+	p.update(pipeline)
+	return copyPipeline(p), nil
+}
+
+func (r *MemPipelineRepo) Delete(slug string) (*Pipeline, error) {
+	r.Lock()
+	defer r.Unlock()
+	for i, p := range r.pipelines {
+		if p.Slug == slug {
+			r.pipelines = append(r.pipelines[:i], r.pipelines[i+1:]...)
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("Cannot find pipeline with slug %s", slug)
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Skipped is the state of a pipeline node that did not run, because a node
+// it depends on did not succeed.
+const Skipped RunState = "skipped"
+
+type NodeRun struct {
+	Name  string
+	Job   string
+	RunID uint
+	State RunState
+}
+
+type NodeRuns []NodeRun
+
+func (n NodeRuns) Value() (driver.Value, error) {
+	b, err := json.Marshal(n)
+	return string(b), err
+}
+
+func (n *NodeRuns) Scan(src interface{}) error {
+	return scanJson(src, n)
+}
+
+type PipelineRun struct {
+	gorm.Model
+	PipelineSlug string `gorm:"index"`
+	State        RunState
+	Nodes        NodeRuns `gorm:"type:text"`
+	StartedAt    *time.Time
+	EndedAt      *time.Time
+	// LeaseExpiresAt is when the server executing the run is presumed to
+	// have stopped, unless it renews the lease. Another server then claims
+	// the run to resume it.
+	LeaseExpiresAt *time.Time
+}
+
+func NewPipelineRun(pipeline *Pipeline) *PipelineRun {
+	nodes := NodeRuns{}
+	for _, n := range pipeline.Nodes {
+		nodes = append(nodes, NodeRun{Name: n.Name, Job: n.Job, State: Queued})
+	}
+	return &PipelineRun{PipelineSlug: pipeline.Slug, State: Queued, Nodes: nodes}
+}
+
+func (r *PipelineRun) Node(name string) *NodeRun {
+	for i := range r.Nodes {
+		if r.Nodes[i].Name == name {
+			return &r.Nodes[i]
+		}
+	}
+	return nil
+}
+
+type PipelineRunRepo interface {
+	Find(pipelineSlug string) ([]*PipelineRun, error)
+	FindOne(id uint) (*PipelineRun, error)
+	Add(run *PipelineRun) (*PipelineRun, error)
+	Update(run *PipelineRun) (*PipelineRun, error)
+	// Unfinished returns the queued and running runs.
+	Unfinished() ([]*PipelineRun, error)
+	// Claim leases the unfinished run, if its lease has expired. It returns
+	// nil if the run is finished or leased by another server.
+	Claim(id uint, lease time.Duration) (*PipelineRun, error)
+}
+
+func (r *PipelineRun) claimable(now time.Time) bool {
+	return !r.State.Finished() && (r.LeaseExpiresAt == nil || r.LeaseExpiresAt.Before(now))
+}
+
+type MemPipelineRunRepo struct {
+	sync.Mutex
+	runs   []*PipelineRun
+	nextID uint
+}
+
+func NewMemPipelineRunRepo(runs []*PipelineRun) *MemPipelineRunRepo {
+	r := &MemPipelineRunRepo{
+		runs: []*PipelineRun{},
+	}
+	for _, run := range runs {
+		_, _ = r.Add(run)
+	}
+	return r
+}
+
+func copyPipelineRun(run *PipelineRun) *PipelineRun {
+	c := *run
+	c.Nodes = append(NodeRuns{}, run.Nodes...)
+	return &c
+}
+
+func (r *MemPipelineRunRepo) Find(pipelineSlug string) ([]*PipelineRun, error) {
+	r.Lock()
+	defer r.Unlock()
+	runs := []*PipelineRun{}
+	for _, run := range r.runs {
+		if run.PipelineSlug == pipelineSlug {
+			runs = append(runs, copyPipelineRun(run))
+		}
+	}
+	return runs, nil
+}
+
+func (r *MemPipelineRunRepo) FindOne(id uint) (*PipelineRun, error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, run := range r.runs {
+		if run.ID == id {
+			return copyPipelineRun(run), nil
+		}
+	}
+	return nil, fmt.Errorf("No pipeline run found with id: %d", id)
+}
+
+func (r *MemPipelineRunRepo) Add(run *PipelineRun) (*PipelineRun, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.nextID++
+	now := time.Now()
+	run.ID = r.nextID
+	run.CreatedAt = now
+	run.UpdatedAt = now
+	r.runs = append(r.runs, copyPipelineRun(run))
+	return run, nil
+}
+
+func (r *MemPipelineRunRepo) Update(run *PipelineRun) (*PipelineRun, error) {
+	r.Lock()
+	defer r.Unlock()
+	for i, existing := range r.runs {
+		if existing.ID == run.ID {
+			run.UpdatedAt = time.Now()
+			r.runs[i] = copyPipelineRun(run)
+			return run, nil
+		}
+	}
+	return nil, fmt.Errorf("Cannot find pipeline run with id %d", run.ID)
+}
+
+func (r *MemPipelineRunRepo) Unfinished() ([]*PipelineRun, error) {
+	r.Lock()
+	defer r.Unlock()
+	runs := []*PipelineRun{}
+	for _, run := range r.runs {
+		if !run.State.Finished() {
+			runs = append(runs, copyPipelineRun(run))
+		}
+	}
+	return runs, nil
+}
+
+func (r *MemPipelineRunRepo) Claim(id uint, lease time.Duration) (*PipelineRun, error) {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	for _, run := range r.runs {
+		if run.ID == id {
+			if !run.claimable(now) {
+				return nil, nil
+			}
+			expires := now.Add(lease)
+			run.LeaseExpiresAt = &expires
+			run.UpdatedAt = now
+			return copyPipelineRun(run), nil
+		}
+	}
+	return nil, fmt.Errorf("Cannot find pipeline run with id %d", id)
+}
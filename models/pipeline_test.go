@@ -0,0 +1,143 @@
+package models
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func node(name string, dependsOn ...string) PipelineNode {
+	return PipelineNode{Name: name, Job: name, DependsOn: dependsOn}
+}
+
+func stageNames(stages [][]PipelineNode) [][]string {
+	names := [][]string{}
+	for _, stage := range stages {
+		s := []string{}
+		for _, n := range stage {
+			s = append(s, n.Name)
+		}
+		names = append(names, s)
+	}
+	return names
+}
+
+func TestPipelineOrder(t *testing.T) {
+	pipeline := NewPipeline("Build",
+		node("deploy", "test", "lint"),
+		node("test", "compile"),
+		node("lint"),
+		node("compile"))
+	stages, err := pipeline.Order()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"compile", "lint"}, {"test"}, {"deploy"}}, stageNames(stages))
+}
+
+func TestPipelineValidate(t *testing.T) {
+	cases := map[string]*Pipeline{
+		"Pipeline has a cycle between nodes: [a b c]":     NewPipeline("P", node("a", "c"), node("b", "a"), node("c", "b"), node("d")),
+		"Pipeline has a cycle between nodes: [a]":         NewPipeline("P", node("a", "a")),
+		"Pipeline node a depends on unknown node missing": NewPipeline("P", node("a", "missing")),
+		"Duplicate pipeline node: a":                      NewPipeline("P", node("a"), node("a")),
+		"Pipeline node a has no job":                      NewPipeline("P", PipelineNode{Name: "a"}),
+		"Pipeline node b input v refers to node c, which it does not depend on": NewPipeline("P", node("a"),
+			PipelineNode{Name: "b", Job: "b", DependsOn: []string{"a"}, Inputs: map[string]string{"v": "c.v"}}),
+		`Pipeline node b input v must refer to node.output: "a"`: NewPipeline("P", node("a"),
+			PipelineNode{Name: "b", Job: "b", DependsOn: []string{"a"}, Inputs: map[string]string{"v": "a"}}),
+	}
+	for msg, pipeline := range cases {
+		assert.EqualError(t, pipeline.Validate(), msg)
+	}
+}
+
+func TestParsePipeline(t *testing.T) {
+	body := ioutil.NopCloser(strings.NewReader(`{
+		"Name": "Release",
+		"Nodes": [
+			{"Name": "build", "Job": "compile"},
+			{"Name": "ship", "Job": "deploy", "depends_on": ["build"]}
+		]
+	}`))
+	pipeline, err := ParsePipeline(body)
+	assert.Nil(t, err)
+	assert.Equal(t, "release", pipeline.Slug)
+	assert.Equal(t, []string{"build"}, pipeline.Nodes[1].DependsOn)
+}
+
+func TestParsePipelineCycle(t *testing.T) {
+	body := ioutil.NopCloser(strings.NewReader(`{
+		"Name": "Loop",
+		"Nodes": [
+			{"Name": "a", "Job": "a", "depends_on": ["b"]},
+			{"Name": "b", "Job": "b", "depends_on": ["a"]}
+		]
+	}`))
+	pipeline, err := ParsePipeline(body)
+	assert.Nil(t, pipeline)
+	assert.EqualError(t, err, "Pipeline has a cycle between nodes: [a b]")
+}
+
+func TestPipelinesRepo(t *testing.T) {
+	repo := NewMemPipelineRepo([]*Pipeline{NewPipeline("One", node("a")), NewPipeline("Two")})
+	p, err := repo.FindOne("one")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(p.Nodes))
+
+	p, err = repo.UpAdd(NewPipeline("One", node("a"), node("b", "a")))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(p.Nodes))
+
+	_, err = repo.Delete("two")
+	assert.Nil(t, err)
+	pipelines, _ := repo.Find()
+	assert.Equal(t, 1, len(pipelines))
+
+	_, err = repo.FindOne("two")
+	assert.EqualError(t, err, "No pipeline found with slug: two")
+}
+
+func TestPipelinesRepoCopies(t *testing.T) {
+	repo := NewMemPipelineRepo([]*Pipeline{NewPipeline("One", node("a"))})
+	p, _ := repo.FindOne("one")
+	p.Name = "Changed"
+	p.Nodes[0].Job = "changed"
+	p, _ = repo.FindOne("one")
+	assert.Equal(t, "One", p.Name)
+	assert.Equal(t, "a", p.Nodes[0].Job)
+}
+
+func TestPipelineRunsRepo(t *testing.T) {
+	pipeline := NewPipeline("One", node("a"), node("b", "a"))
+	repo := NewMemPipelineRunRepo([]*PipelineRun{NewPipelineRun(pipeline)})
+	run, err := repo.FindOne(1)
+	assert.Nil(t, err)
+	assert.Equal(t, Queued, run.Node("b").State)
+	run.Node("b").State = Running
+	_, _ = repo.Update(run)
+	run, _ = repo.FindOne(1)
+	assert.Equal(t, Running, run.Node("b").State)
+	runs, _ := repo.Find("one")
+	assert.Equal(t, 1, len(runs))
+}
+
+func TestPipelineRunsClaim(t *testing.T) {
+	pipeline := NewPipeline("One", node("a"))
+	finished := NewPipelineRun(pipeline)
+	finished.State = Succeeded
+	repo := NewMemPipelineRunRepo([]*PipelineRun{NewPipelineRun(pipeline), finished})
+	runs, _ := repo.Unfinished()
+	assert.Equal(t, 1, len(runs))
+	assert.Equal(t, uint(1), runs[0].ID)
+
+	run, err := repo.Claim(1, time.Minute)
+	assert.Nil(t, err)
+	assert.NotNil(t, run.LeaseExpiresAt)
+	run, err = repo.Claim(1, time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, run)
+	run, _ = repo.Claim(2, time.Minute)
+	assert.Nil(t, run)
+}
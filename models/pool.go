@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Pool is a named resource, such as a database, that at most Size runs use
+// at a time. Jobs name the pools they use in Job.Pools.
+type Pool struct {
+	gorm.Model
+	Name string
+	Slug string
+	Size int
+}
+
+func NewPool(name string, size int) *Pool {
+	return &Pool{Name: name, Slug: slug(name), Size: size}
+}
+
+func (p *Pool) update(pool *Pool) {
+	if pool.Name != "" {
+		p.Name = pool.Name
+	}
+	if pool.Slug != "" {
+		p.Slug = pool.Slug
+	}
+	if pool.Size != 0 {
+		p.Size = pool.Size
+	}
+	p.UpdatedAt = time.Now()
+}
+
+func (p *Pool) Validate() error {
+	if p.Size < 1 {
+		return fmt.Errorf("Pool size must be at least 1: %d", p.Size)
+	}
+	return nil
+}
+
+func ParsePool(reader io.ReadCloser) (*Pool, error) {
+	if reader == nil {
+		return nil, fmt.Errorf("No body to parse")
+	}
+	decoder := json.NewDecoder(reader)
+	defer reader.Close() // errcheck-ignore
+	var pool Pool
+	if err := decoder.Decode(&pool); err != nil {
+		return nil, err
+	}
+	if pool.Slug == "" {
+		pool.Slug = slug(pool.Name)
+	}
+	if err := pool.Validate(); err != nil {
+		return nil, invalid(err)
+	}
+	return &pool, nil
+}
+
+type PoolRepo interface {
+	Find() ([]*Pool, error)
+	FindOne(slug string) (*Pool, error)
+	Add(pool *Pool) (*Pool, error)
+	Update(pool *Pool) (*Pool, error)
+	UpAdd(pool *Pool) (*Pool, error)
+	Delete(slug string) (*Pool, error)
+}
+
+type MemPoolRepo struct {
+	pools  []*Pool
+	nextID uint
+}
+
+func NewMemPoolRepo(pools []*Pool) *MemPoolRepo {
+	r := &MemPoolRepo{
+		pools: []*Pool{},
+	}
+	for _, p := range pools {
+		_, _ = r.Add(p)
+	}
+	return r
+}
+
+func (r *MemPoolRepo) Find() ([]*Pool, error) {
+	return r.pools, nil
+}
+
+func (r *MemPoolRepo) FindOne(slug string) (*Pool, error) {
+	for _, p := range r.pools {
+		if p.Slug == slug {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("No pool found with slug: %s", slug)
+}
+
+func (r *MemPoolRepo) Add(pool *Pool) (*Pool, error) {
+	r.nextID++
+	now := time.Now()
+	pool.CreatedAt = now
+	pool.UpdatedAt = now
+	pool.ID = r.nextID
+	r.pools = append(r.pools, pool)
+	return pool, nil
+}
+
+func (r *MemPoolRepo) Update(pool *Pool) (*Pool, error) {
+	p, _ := r.FindOne(pool.Slug)
+	if p == nil {
+		return nil, fmt.Errorf("Cannot find pool with slug %s", pool.Slug)
+	}
+	p.update(pool)
+	return p, nil
+}
+
+func (r *MemPoolRepo) UpAdd(pool *Pool) (*Pool, error) {
+	p, _ := r.FindOne(pool.Slug)
+	if p == nil {
+		return r.Add(pool)
+	}
+	p.update(pool)
+	return p, nil
+}
+
+func (r *MemPoolRepo) Delete(slug string) (*Pool, error) {
+	for i, p := range r.pools {
+		if p.Slug == slug {
+			r.pools = append(r.pools[:i], r.pools[i+1:]...)
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("Cannot find pool with slug %s", slug)
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePool(t *testing.T) {
+	pool, err := ParsePool(ioutil.NopCloser(strings.NewReader(`{"Name": "Reporting DB", "Size": 4}`)))
+	assert.Nil(t, err)
+	assert.Equal(t, "reporting db", pool.Slug)
+	assert.Equal(t, 4, pool.Size)
+	_, err = ParsePool(ioutil.NopCloser(strings.NewReader(`{"Name": "Empty"}`)))
+	assert.EqualError(t, err, "Pool size must be at least 1: 0")
+}
+
+func TestMemPoolRepo(t *testing.T) {
+	repo := NewMemPoolRepo([]*Pool{NewPool("One", 1)})
+	p, _ := repo.UpAdd(&Pool{Slug: "one", Size: 3})
+	assert.Equal(t, 3, p.Size)
+	assert.Equal(t, "One", p.Name)
+	_, _ = repo.UpAdd(NewPool("Two", 2))
+	pools, _ := repo.Find()
+	assert.Equal(t, 2, len(pools))
+	_, err := repo.Delete("one")
+	assert.Nil(t, err)
+	_, err = repo.FindOne("one")
+	assert.EqualError(t, err, "No pool found with slug: one")
+}
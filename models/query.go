@@ -0,0 +1,183 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// JobQuery selects, sorts and pages jobs. Empty fields match all jobs.
+type JobQuery struct {
+	// NamePrefix matches the jobs whose name starts with it.
+	NamePrefix string
+	// Labels matches the jobs that have all of the labels.
+	Labels StringMap
+	Owner  string
+	// Scheduled matches the jobs with a schedule if true, and those
+	// without one if false.
+	Scheduled    *bool
+	CreatedSince time.Time
+	CreatedUntil time.Time
+	UpdatedSince time.Time
+	UpdatedUntil time.Time
+	// Sort is one of JobSorts, by default "id", and ties are ordered by id.
+	Sort string
+	Desc bool
+	// After is the cursor of the last job of the previous page.
+	After string
+	// Limit is the most jobs to return, 0 for no limit.
+	Limit int
+}
+
+// JobSorts are the fields that jobs are sorted by, and their columns.
+var JobSorts = map[string]string{
+	"id":      "id",
+	"name":    "name",
+	"slug":    "slug",
+	"created": "created_at",
+	"updated": "updated_at",
+}
+
+// cursorTime is the format of times in cursors, which sorts like the times.
+const cursorTime = "2006-01-02T15:04:05.000000000Z"
+
+// jobCursor is the position of a job in the sort order.
+type jobCursor struct {
+	Value string `json:"v,omitempty"`
+	ID    uint   `json:"id"`
+}
+
+func (c jobCursor) compare(other jobCursor) int {
+	if n := strings.Compare(c.Value, other.Value); n != 0 {
+		return n
+	}
+	switch {
+	case c.ID < other.ID:
+		return -1
+	case c.ID > other.ID:
+		return 1
+	}
+	return 0
+}
+
+// Validate returns an error if the sort or the cursor is invalid.
+func (q *JobQuery) Validate() error {
+	if _, ok := JobSorts[q.sort()]; !ok {
+		return fmt.Errorf("Invalid sort: %s", q.Sort)
+	}
+	_, err := q.after()
+	return err
+}
+
+func (q *JobQuery) sort() string {
+	if q.Sort == "" {
+		return "id"
+	}
+	return q.Sort
+}
+
+func (q *JobQuery) cursor(job *Job) jobCursor {
+	c := jobCursor{ID: job.ID}
+	switch q.sort() {
+	case "name":
+		c.Value = job.Name
+	case "slug":
+		c.Value = job.Slug
+	case "created":
+		c.Value = job.CreatedAt.UTC().Format(cursorTime)
+	case "updated":
+		c.Value = job.UpdatedAt.UTC().Format(cursorTime)
+	}
+	return c
+}
+
+// Cursor returns the cursor of the job, to get the page after it.
+func (q *JobQuery) Cursor(job *Job) string {
+	b, _ := json.Marshal(q.cursor(job))
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (q *JobQuery) after() (*jobCursor, error) {
+	if q.After == "" {
+		return nil, nil
+	}
+	var c jobCursor
+	b, err := base64.RawURLEncoding.DecodeString(q.After)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err == nil && (q.sort() == "created" || q.sort() == "updated") {
+		_, err = time.Parse(cursorTime, c.Value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cursor: %s", q.After)
+	}
+	return &c, nil
+}
+
+// cursorValue returns the value of the cursor as the type of the sort
+// column, a time for created and updated and a string otherwise.
+func (q *JobQuery) cursorValue(c *jobCursor) interface{} {
+	switch q.sort() {
+	case "created", "updated":
+		t, _ := time.Parse(cursorTime, c.Value)
+		return t
+	}
+	return c.Value
+}
+
+func (q *JobQuery) Match(j *Job) bool {
+	for k, v := range q.Labels {
+		if value, ok := j.Labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return strings.HasPrefix(j.Name, q.NamePrefix) &&
+		(q.Owner == "" || j.Owner == q.Owner) &&
+		(q.Scheduled == nil || *q.Scheduled == (j.Schedule != "")) &&
+		inRange(j.CreatedAt, q.CreatedSince, q.CreatedUntil) &&
+		inRange(j.UpdatedAt, q.UpdatedSince, q.UpdatedUntil)
+}
+
+func inRange(t, since, until time.Time) bool {
+	return (since.IsZero() || !t.Before(since)) && (until.IsZero() || t.Before(until))
+}
+
+// page returns the jobs that match the query, sorted and after the cursor,
+// and at most Limit of them.
+func (q *JobQuery) page(jobs []*Job) ([]*Job, error) {
+	after, err := q.after()
+	if err != nil {
+		return nil, err
+	}
+	page := []*Job{}
+	for _, j := range jobs {
+		if q.Match(j) {
+			page = append(page, j)
+		}
+	}
+	sort.Slice(page, func(a, b int) bool {
+		n := q.cursor(page[a]).compare(q.cursor(page[b]))
+		if q.Desc {
+			return n > 0
+		}
+		return n < 0
+	})
+	if after != nil {
+		i := sort.Search(len(page), func(i int) bool {
+			n := q.cursor(page[i]).compare(*after)
+			if q.Desc {
+				return n < 0
+			}
+			return n > 0
+		})
+		page = page[i:]
+	}
+	if q.Limit > 0 && len(page) > q.Limit {
+		page = page[:q.Limit]
+	}
+	return page, nil
+}
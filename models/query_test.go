@@ -0,0 +1,65 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func queryJobs() *MemJobRepo {
+	build, deploy, backup := NewJob("Build"), NewJob("Deploy"), NewJob("Backup")
+	build.Owner, deploy.Owner, backup.Owner = "alice", "bob", "alice"
+	deploy.Labels = StringMap{"zone": "a", "os": "linux"}
+	backup.Schedule = "@daily"
+	return NewMemJobRepo([]*Job{build, deploy, backup})
+}
+
+func slugs(jobs []*Job) []string {
+	s := []string{}
+	for _, j := range jobs {
+		s = append(s, j.Slug)
+	}
+	return s
+}
+
+func TestJobQueryFilter(t *testing.T) {
+	repo := queryJobs()
+	scheduled := false
+	jobs, _ := repo.Find(&JobQuery{NamePrefix: "B", Scheduled: &scheduled})
+	assert.Equal(t, []string{"build"}, slugs(jobs))
+	jobs, _ = repo.Find(&JobQuery{Owner: "alice"})
+	assert.Equal(t, []string{"build", "backup"}, slugs(jobs))
+	jobs, _ = repo.Find(&JobQuery{Labels: StringMap{"zone": "a"}})
+	assert.Equal(t, []string{"deploy"}, slugs(jobs))
+	jobs, _ = repo.Find(&JobQuery{CreatedUntil: time.Now().Add(-time.Hour)})
+	assert.Equal(t, []string{}, slugs(jobs))
+}
+
+func TestJobQueryPages(t *testing.T) {
+	repo := queryJobs()
+	query := &JobQuery{Sort: "name", Desc: true, Limit: 2}
+	jobs, _ := repo.Find(query)
+	assert.Equal(t, []string{"deploy", "build"}, slugs(jobs))
+	query.After = query.Cursor(jobs[1])
+	jobs, _ = repo.Find(query)
+	assert.Equal(t, []string{"backup"}, slugs(jobs))
+
+	query = &JobQuery{Sort: "created", Limit: 1}
+	jobs, _ = repo.Find(query)
+	query.After = query.Cursor(jobs[0])
+	jobs, _ = repo.Find(query)
+	assert.Equal(t, []string{"deploy"}, slugs(jobs))
+}
+
+func TestJobQueryInvalid(t *testing.T) {
+	assert.EqualError(t, (&JobQuery{Sort: "command"}).Validate(), "Invalid sort: command")
+	_, err := queryJobs().Find(&JobQuery{Sort: "updated", After: "e30"})
+	assert.EqualError(t, err, "Invalid cursor: e30")
+}
+
+func TestJobQueryCursorValue(t *testing.T) {
+	c := &jobCursor{Value: "2020-01-01T00:00:00.000000000Z", ID: 1}
+	assert.Equal(t, c.Value, (&JobQuery{Sort: "name"}).cursorValue(c))
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), (&JobQuery{Sort: "created"}).cursorValue(c))
+}
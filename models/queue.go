@@ -0,0 +1,264 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrLeaseLost is returned when a worker heartbeats a run it no longer
+// holds the lease on.
+var ErrLeaseLost = errors.New("Lease on run was lost")
+
+// QueueItem is a run waiting to be executed. It stays in the queue, leased
+// by a worker, until the worker has finished with it. A lease that is not
+// kept alive by heartbeats expires, and the item can be claimed again.
+type QueueItem struct {
+	ID      uint   `gorm:"primary_key"`
+	RunID   uint   `gorm:"unique_index"`
+	JobSlug string `gorm:"index"`
+	// Labels and AntiAffinity are the placement of the job, and
+	// MaxConcurrency and Pools its limits, see Job.
+	Labels         StringMap `gorm:"type:text"`
+	AntiAffinity   StringMap `gorm:"type:text"`
+	MaxConcurrency int
+	Pools          StringList `gorm:"type:text"`
+	// Priority and Team order the queue, see Run.
+	Priority       int
+	Team           string `gorm:"index"`
+	CreatedAt      time.Time
+	ClaimedBy      string
+	ClaimedAt      *time.Time
+	LeaseExpiresAt *time.Time
+	// Leases is the number of times the item has been claimed.
+	Leases int
+	// CancelledBy is set when the run is cancelled while a worker holds
+	// it, for the worker to stop it.
+	CancelledBy string
+
+	// Position is the estimated place of the item in the queue, counting
+	// from 1, and WaitingOn the limits it is waiting on. See Queued.
+	Position  int      `gorm:"-"`
+	WaitingOn []string `gorm:"-"`
+}
+
+func (i *QueueItem) Claimed() bool {
+	return i.ClaimedBy != ""
+}
+
+// limited tells if the item has a concurrency limit or pools.
+func (i *QueueItem) limited() bool {
+	return i.MaxConcurrency > 0 || len(i.Pools) > 0
+}
+
+func (i *QueueItem) expired(now time.Time) bool {
+	return i.LeaseExpiresAt != nil && i.LeaseExpiresAt.Before(now)
+}
+
+type Queue interface {
+	Enqueue(job *Job, run *Run) error
+	// Claim leases the first unclaimed, or expired, item that the worker
+	// matches to it, in dispatch order. It returns nil when there is
+	// nothing to claim.
+	Claim(worker *Worker, lease time.Duration) (*QueueItem, error)
+	// Heartbeat extends the worker's lease on the run. It returns
+	// ErrLeaseLost when the worker no longer holds it.
+	Heartbeat(runID uint, worker string, lease time.Duration) (*QueueItem, error)
+	// Release gives up the lease, leaving the run for another worker.
+	Release(runID uint) error
+	// WaitingOn returns the concurrency limits that keep the queued run from
+	// being claimed, such as "pool reporting (4/4)".
+	WaitingOn(runID uint) ([]string, error)
+	// Queued returns the items that wait to be claimed, in the order they
+	// are expected to be.
+	Queued() ([]*QueueItem, error)
+	// Cancel removes the run from the queue, unless it is claimed. A claimed
+	// run is marked as cancelled for its worker to stop, and true returned.
+	Cancel(runID uint, by string) (bool, error)
+	Remove(runID uint) error
+	Find() ([]*QueueItem, error)
+}
+
+type MemQueue struct {
+	sync.Mutex
+	items  []*QueueItem
+	nextID uint
+	pools  PoolRepo
+	teams  TeamRepo
+}
+
+func NewMemQueue(pools PoolRepo, teams TeamRepo) *MemQueue {
+	return &MemQueue{
+		items: []*QueueItem{},
+		pools: pools,
+		teams: teams,
+	}
+}
+
+func (q *MemQueue) Enqueue(job *Job, run *Run) error {
+	q.Lock()
+	defer q.Unlock()
+	if q.find(run.ID) != nil {
+		return fmt.Errorf("Run %d is already queued", run.ID)
+	}
+	q.nextID++
+	q.items = append(q.items, &QueueItem{
+		ID:             q.nextID,
+		RunID:          run.ID,
+		JobSlug:        run.JobSlug,
+		Labels:         job.Labels,
+		AntiAffinity:   job.AntiAffinity,
+		MaxConcurrency: job.MaxConcurrency,
+		Pools:          job.Pools,
+		Priority:       run.Priority,
+		Team:           run.Team,
+		CreatedAt:      time.Now(),
+	})
+	return nil
+}
+
+func (q *MemQueue) find(runID uint) *QueueItem {
+	for _, i := range q.items {
+		if i.RunID == runID {
+			return i
+		}
+	}
+	return nil
+}
+
+func (q *MemQueue) Claim(worker *Worker, lease time.Duration) (*QueueItem, error) {
+	q.Lock()
+	defer q.Unlock()
+	now := time.Now()
+	state := q.state(now)
+	for _, i := range state.order(q.waiting(now)) {
+		if worker.Matches(i) && len(state.waitingOn(i)) == 0 {
+			expires := now.Add(lease)
+			i.ClaimedBy = worker.Name
+			i.ClaimedAt = &now
+			i.LeaseExpiresAt = &expires
+			i.Leases++
+			c := *i
+			return &c, nil
+		}
+	}
+	return nil, nil
+}
+
+// waiting returns the items that are unclaimed, or have expired leases.
+func (q *MemQueue) waiting(now time.Time) []*QueueItem {
+	waiting := []*QueueItem{}
+	for _, i := range q.items {
+		if !i.Claimed() || i.expired(now) {
+			waiting = append(waiting, i)
+		}
+	}
+	return waiting
+}
+
+func (q *MemQueue) state(now time.Time) *queueState {
+	state := newQueueState()
+	for _, i := range q.items {
+		if i.Claimed() && !i.expired(now) {
+			state.claimed = append(state.claimed, i)
+		}
+	}
+	pools, _ := q.pools.Find()
+	for _, p := range pools {
+		state.sizes[p.Slug] = p.Size
+	}
+	teams, _ := q.teams.Find()
+	for _, t := range teams {
+		state.weights[t.Slug] = t.Weight
+	}
+	return state
+}
+
+func (q *MemQueue) WaitingOn(runID uint) ([]string, error) {
+	q.Lock()
+	defer q.Unlock()
+	i := q.find(runID)
+	if i == nil || i.Claimed() {
+		return []string{}, nil
+	}
+	return q.state(time.Now()).waitingOn(i), nil
+}
+
+func (q *MemQueue) Queued() ([]*QueueItem, error) {
+	q.Lock()
+	defer q.Unlock()
+	now := time.Now()
+	waiting := []*QueueItem{}
+	for _, i := range q.waiting(now) {
+		c := *i
+		waiting = append(waiting, &c)
+	}
+	return q.state(now).queued(waiting), nil
+}
+
+func (q *MemQueue) Heartbeat(runID uint, worker string, lease time.Duration) (*QueueItem, error) {
+	q.Lock()
+	defer q.Unlock()
+	i := q.find(runID)
+	if i == nil || i.ClaimedBy != worker {
+		return nil, ErrLeaseLost
+	}
+	expires := time.Now().Add(lease)
+	i.LeaseExpiresAt = &expires
+	c := *i
+	return &c, nil
+}
+
+func (q *MemQueue) Release(runID uint) error {
+	q.Lock()
+	defer q.Unlock()
+	if i := q.find(runID); i != nil {
+		i.ClaimedBy = ""
+		i.ClaimedAt = nil
+		i.LeaseExpiresAt = nil
+	}
+	return nil
+}
+
+func (q *MemQueue) Cancel(runID uint, by string) (bool, error) {
+	q.Lock()
+	defer q.Unlock()
+	i := q.find(runID)
+	if i == nil {
+		return false, nil
+	}
+	if !i.Claimed() {
+		q.remove(runID)
+		return false, nil
+	}
+	i.CancelledBy = by
+	return true, nil
+}
+
+func (q *MemQueue) Remove(runID uint) error {
+	q.Lock()
+	defer q.Unlock()
+	q.remove(runID)
+	return nil
+}
+
+func (q *MemQueue) remove(runID uint) {
+	for n, i := range q.items {
+		if i.RunID == runID {
+			q.items = append(q.items[:n], q.items[n+1:]...)
+			return
+		}
+	}
+}
+
+func (q *MemQueue) Find() ([]*QueueItem, error) {
+	q.Lock()
+	defer q.Unlock()
+	items := []*QueueItem{}
+	for _, i := range q.items {
+		c := *i
+		items = append(items, &c)
+	}
+	return items, nil
+}
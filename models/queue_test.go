@@ -0,0 +1,177 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func queuedRun(id uint) *Run {
+	return queuedRunOf(NewJob("One"), id)
+}
+
+func queuedRunOf(job *Job, id uint) *Run {
+	run := NewRun(job)
+	run.ID = id
+	return run
+}
+
+func newMemQueue() *MemQueue {
+	return NewMemQueue(NewMemPoolRepo([]*Pool{}), NewMemTeamRepo([]*Team{}))
+}
+
+func testQueue(t *testing.T, queue Queue) {
+	for _, id := range []uint{1, 2, 3} {
+		assert.Nil(t, queue.Enqueue(NewJob("One"), queuedRun(id)))
+	}
+	assert.NotNil(t, queue.Enqueue(NewJob("One"), queuedRun(2)))
+
+	item, err := queue.Claim(&Worker{Name: "a"}, time.Minute)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), item.RunID)
+	assert.Equal(t, "one", item.JobSlug)
+	assert.Equal(t, "a", item.ClaimedBy)
+	assert.NotNil(t, item.ClaimedAt)
+
+	item, _ = queue.Claim(&Worker{Name: "b"}, time.Minute)
+	assert.Equal(t, uint(2), item.RunID)
+
+	assert.Nil(t, queue.Remove(1))
+	items, _ := queue.Find()
+	assert.Equal(t, 2, len(items))
+	assert.True(t, items[0].Claimed())
+	assert.False(t, items[1].Claimed())
+
+	item, _ = queue.Claim(&Worker{Name: "c"}, time.Minute)
+	assert.Equal(t, uint(3), item.RunID)
+	item, err = queue.Claim(&Worker{Name: "d"}, time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, item)
+}
+
+func TestMemQueue(t *testing.T) {
+	testQueue(t, newMemQueue())
+}
+
+func TestMemQueueLease(t *testing.T) {
+	queue := newMemQueue()
+	_ = queue.Enqueue(NewJob("One"), queuedRun(1))
+	item, _ := queue.Claim(&Worker{Name: "a"}, -time.Second)
+	assert.Equal(t, 1, item.Leases)
+	_, err := queue.Heartbeat(1, "b", time.Minute)
+	assert.Equal(t, ErrLeaseLost, err)
+
+	// The lease of a has expired.
+	item, _ = queue.Claim(&Worker{Name: "b"}, time.Minute)
+	assert.Equal(t, uint(1), item.RunID)
+	assert.Equal(t, "b", item.ClaimedBy)
+	assert.Equal(t, 2, item.Leases)
+	_, err = queue.Heartbeat(1, "a", time.Minute)
+	assert.Equal(t, ErrLeaseLost, err)
+	item, err = queue.Heartbeat(1, "b", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, item.LeaseExpiresAt.After(time.Now()))
+	item, _ = queue.Claim(&Worker{Name: "c"}, time.Minute)
+	assert.Nil(t, item)
+
+	assert.Nil(t, queue.Release(1))
+	item, _ = queue.Claim(&Worker{Name: "c"}, time.Minute)
+	assert.Equal(t, "c", item.ClaimedBy)
+}
+
+func TestMemQueueCancel(t *testing.T) {
+	queue := newMemQueue()
+	_ = queue.Enqueue(NewJob("One"), queuedRun(1))
+	_ = queue.Enqueue(NewJob("One"), queuedRun(2))
+	_, _ = queue.Claim(&Worker{Name: "a"}, time.Minute)
+
+	claimed, err := queue.Cancel(2, "alice")
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+	claimed, err = queue.Cancel(1, "alice")
+	assert.Nil(t, err)
+	assert.True(t, claimed)
+	items, _ := queue.Find()
+	assert.Equal(t, 1, len(items))
+	item, _ := queue.Heartbeat(1, "a", time.Minute)
+	assert.Equal(t, "alice", item.CancelledBy)
+}
+
+func TestMemQueuePlacement(t *testing.T) {
+	queue := newMemQueue()
+	gpu := NewJob("Gpu")
+	gpu.Labels = StringMap{"pool": "gpu"}
+	notA := NewJob("NotA")
+	notA.AntiAffinity = StringMap{"zone": "a"}
+	_ = queue.Enqueue(gpu, queuedRun(1))
+	_ = queue.Enqueue(notA, queuedRun(2))
+
+	zoneA := &Worker{Name: "a", Labels: StringMap{"zone": "a"}}
+	item, _ := queue.Claim(zoneA, time.Minute)
+	assert.Nil(t, item)
+	item, _ = queue.Claim(&Worker{Name: "b", Labels: StringMap{"zone": "b"}}, time.Minute)
+	assert.Equal(t, uint(2), item.RunID)
+	item, _ = queue.Claim(&Worker{Name: "c", Labels: StringMap{"pool": "gpu", "zone": "a"}}, time.Minute)
+	assert.Equal(t, uint(1), item.RunID)
+}
+
+func TestMemQueueLimits(t *testing.T) {
+	queue := NewMemQueue(NewMemPoolRepo([]*Pool{NewPool("Reporting", 2)}), NewMemTeamRepo([]*Team{}))
+	single := NewJob("Single")
+	single.MaxConcurrency = 1
+	report := NewJob("Report")
+	report.Pools = StringList{"reporting"}
+	_ = queue.Enqueue(single, queuedRunOf(single, 1))
+	_ = queue.Enqueue(single, queuedRunOf(single, 2))
+	for id := uint(3); id <= 5; id++ {
+		_ = queue.Enqueue(report, queuedRunOf(report, id))
+	}
+	worker := &Worker{Name: "a"}
+	var claimed []uint
+	for item, _ := queue.Claim(worker, time.Minute); item != nil; item, _ = queue.Claim(worker, time.Minute) {
+		claimed = append(claimed, item.RunID)
+	}
+	assert.Equal(t, []uint{1, 3, 4}, claimed)
+	waiting, _ := queue.WaitingOn(2)
+	assert.Equal(t, []string{"job single (1/1)"}, waiting)
+	waiting, _ = queue.WaitingOn(5)
+	assert.Equal(t, []string{"pool reporting (2/2)"}, waiting)
+
+	_ = queue.Remove(3)
+	item, _ := queue.Claim(worker, time.Minute)
+	assert.Equal(t, uint(5), item.RunID)
+}
+
+func TestMemQueueUnknownPool(t *testing.T) {
+	queue := newMemQueue()
+	job := NewJob("One")
+	job.Pools = StringList{"missing"}
+	_ = queue.Enqueue(job, queuedRunOf(job, 1))
+	item, _ := queue.Claim(&Worker{Name: "a"}, time.Minute)
+	assert.Nil(t, item)
+	waiting, _ := queue.WaitingOn(1)
+	assert.Equal(t, []string{"pool missing (0/0)"}, waiting)
+}
+
+func TestMemQueueFairShare(t *testing.T) {
+	teams := NewMemTeamRepo([]*Team{NewTeam("Backfill", 1), NewTeam("Web", 1)})
+	queue := NewMemQueue(NewMemPoolRepo([]*Pool{}), teams)
+	backfill := NewJob("Backfill")
+	backfill.Team = "backfill"
+	web := NewJob("Web")
+	web.Team = "web"
+	for id := uint(1); id <= 3; id++ {
+		_ = queue.Enqueue(backfill, queuedRunOf(backfill, id))
+	}
+	_ = queue.Enqueue(web, queuedRunOf(web, 4))
+
+	queued, _ := queue.Queued()
+	assert.Equal(t, []uint{1, 4, 2, 3}, runIDs(queued))
+	assert.Equal(t, 2, queued[1].Position)
+	worker := &Worker{Name: "a"}
+	item, _ := queue.Claim(worker, time.Minute)
+	assert.Equal(t, uint(1), item.RunID)
+	item, _ = queue.Claim(worker, time.Minute)
+	assert.Equal(t, uint(4), item.RunID)
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy says how often a failed run is attempted again. The delay
+// before attempt n+1 is InitialDelay * Multiplier^(n-1), at most MaxDelay,
+// randomly varied by up to Jitter (a fraction) in either direction. If
+// RetryOn lists exit codes, only failures with those codes are retried.
+type RetryPolicy struct {
+	MaxAttempts  int
+	InitialDelay Duration
+	Multiplier   float64
+	MaxDelay     Duration
+	Jitter       float64
+	RetryOn      []int
+}
+
+func (p RetryPolicy) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	return string(b), err
+}
+
+func (p *RetryPolicy) Scan(src interface{}) error {
+	return scanJson(src, p)
+}
+
+func (p *RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("Retry max attempts cannot be negative: %d", p.MaxAttempts)
+	}
+	if p.InitialDelay < 0 || p.MaxDelay < 0 {
+		return fmt.Errorf("Retry delays cannot be negative")
+	}
+	if p.Multiplier != 0 && p.Multiplier < 1 {
+		return fmt.Errorf("Retry multiplier must be at least 1: %g", p.Multiplier)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("Retry jitter must be between 0 and 1: %g", p.Jitter)
+	}
+	return nil
+}
+
+// ShouldRetry tells if a run that failed with exitCode on the given attempt
+// should be attempted again.
+func (p *RetryPolicy) ShouldRetry(attempt int, exitCode int) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+	if len(p.RetryOn) == 0 {
+		return true
+	}
+	for _, code := range p.RetryOn {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay is the time to wait after the given attempt before the next one.
+func (p *RetryPolicy) Delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+	delay := float64(p.InitialDelay) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
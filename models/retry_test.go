@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:  5,
+		InitialDelay: Duration(time.Second),
+		Multiplier:   3,
+		MaxDelay:     Duration(10 * time.Second),
+	}
+	assert.Equal(t, time.Second, policy.Delay(1))
+	assert.Equal(t, 3*time.Second, policy.Delay(2))
+	assert.Equal(t, 9*time.Second, policy.Delay(3))
+	assert.Equal(t, 10*time.Second, policy.Delay(4))
+}
+
+func TestRetryDelayDefaultMultiplier(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: Duration(time.Second)}
+	assert.Equal(t, 4*time.Second, policy.Delay(3))
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialDelay: Duration(10 * time.Second), Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(1)
+		assert.True(t, delay >= 5*time.Second && delay <= 15*time.Second, "delay %s", delay)
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}
+	assert.True(t, policy.ShouldRetry(1, 1))
+	assert.True(t, policy.ShouldRetry(2, 1))
+	assert.False(t, policy.ShouldRetry(3, 1))
+
+	policy.RetryOn = []int{75}
+	assert.True(t, policy.ShouldRetry(1, 75))
+	assert.False(t, policy.ShouldRetry(1, 1))
+
+	assert.False(t, (&RetryPolicy{}).ShouldRetry(1, 1))
+}
+
+func TestRetryValidate(t *testing.T) {
+	assert.Nil(t, (&RetryPolicy{MaxAttempts: 3, Multiplier: 1.5, Jitter: 0.1}).Validate())
+	assert.EqualError(t, (&RetryPolicy{MaxAttempts: -1}).Validate(), "Retry max attempts cannot be negative: -1")
+	assert.EqualError(t, (&RetryPolicy{Multiplier: 0.5}).Validate(), "Retry multiplier must be at least 1: 0.5")
+	assert.EqualError(t, (&RetryPolicy{Jitter: 2}).Validate(), "Retry jitter must be between 0 and 1: 2")
+	assert.EqualError(t, (&RetryPolicy{InitialDelay: -1}).Validate(), "Retry delays cannot be negative")
+}
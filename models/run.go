@@ -0,0 +1,181 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type RunState string
+
+const (
+	Queued    RunState = "queued"
+	Running   RunState = "running"
+	Succeeded RunState = "succeeded"
+	Failed    RunState = "failed"
+	Cancelled RunState = "cancelled"
+	TimedOut  RunState = "timed_out"
+	// Lost is a run whose worker went away while executing it.
+	Lost RunState = "lost"
+)
+
+func (s RunState) Finished() bool {
+	return s != Queued && s != Running
+}
+
+type Run struct {
+	gorm.Model
+	JobSlug string `gorm:"index"`
+	// JobVersion is the version of the job's definition the run executes.
+	JobVersion int
+	// Team and Priority come from the job, see Job.
+	Team     string
+	Priority int
+	// Params are the values of the job's params for the run.
+	Params StringMap `gorm:"type:text"`
+	// Outputs are written by the command of the run, as name=value lines
+	// to the file named by JOBS_OUTPUT.
+	Outputs   StringMap `gorm:"type:text"`
+	State     RunState
+	ExitCode  int
+	Signal    string
+	StartedAt *time.Time
+	EndedAt   *time.Time
+	Attempts  []Attempt
+
+	CancelledBy string
+	CancelledAt *time.Time
+
+	// WaitingOn are the concurrency limits a queued run is waiting on.
+	WaitingOn []string `gorm:"-"`
+}
+
+// Attempt is one execution of the command of a run. There is more than one
+// when failed attempts are retried.
+type Attempt struct {
+	ID        uint `gorm:"primary_key"`
+	RunID     uint `gorm:"index"`
+	Number    int
+	State     RunState
+	ExitCode  int
+	Signal    string
+	StartedAt *time.Time
+	EndedAt   *time.Time
+}
+
+func NewRun(job *Job) *Run {
+	return &Run{JobSlug: job.Slug, JobVersion: job.Version, Team: job.Team, Priority: job.Priority, State: Queued}
+}
+
+func (r *Run) Start() {
+	now := time.Now()
+	r.State = Running
+	r.StartedAt = &now
+}
+
+func (r *Run) Finish(state RunState, exitCode int) {
+	now := time.Now()
+	r.State = state
+	r.ExitCode = exitCode
+	r.EndedAt = &now
+}
+
+// StartAttempt adds a running attempt to the run and returns it.
+func (r *Run) StartAttempt() *Attempt {
+	now := time.Now()
+	r.Attempts = append(r.Attempts, Attempt{
+		RunID:     r.ID,
+		Number:    len(r.Attempts) + 1,
+		State:     Running,
+		StartedAt: &now,
+	})
+	return &r.Attempts[len(r.Attempts)-1]
+}
+
+func (a *Attempt) Finish(state RunState, exitCode int) {
+	now := time.Now()
+	a.State = state
+	a.ExitCode = exitCode
+	a.EndedAt = &now
+}
+
+func copyRun(run *Run) *Run {
+	c := *run
+	c.Attempts = append([]Attempt(nil), run.Attempts...)
+	return &c
+}
+
+type RunRepo interface {
+	Find(jobSlug string) ([]*Run, error)
+	FindOne(id uint) (*Run, error)
+	Add(run *Run) (*Run, error)
+	Update(run *Run) (*Run, error)
+}
+
+// MemRunRepo is safe for concurrent use. It hands out copies, since runs
+// are updated by the runner while controllers read them.
+type MemRunRepo struct {
+	sync.Mutex
+	runs   []*Run
+	nextID uint
+}
+
+func NewMemRunRepo(runs []*Run) *MemRunRepo {
+	r := &MemRunRepo{
+		runs: []*Run{},
+	}
+	for _, run := range runs {
+		_, _ = r.Add(run)
+	}
+	return r
+}
+
+func (r *MemRunRepo) Find(jobSlug string) ([]*Run, error) {
+	r.Lock()
+	defer r.Unlock()
+	runs := []*Run{}
+	for _, run := range r.runs {
+		if run.JobSlug == jobSlug {
+			runs = append(runs, copyRun(run))
+		}
+	}
+	return runs, nil
+}
+
+func (r *MemRunRepo) FindOne(id uint) (*Run, error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, run := range r.runs {
+		if run.ID == id {
+			return copyRun(run), nil
+		}
+	}
+	return nil, fmt.Errorf("No run found with id: %d", id)
+}
+
+func (r *MemRunRepo) Add(run *Run) (*Run, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.nextID++
+	now := time.Now()
+	run.ID = r.nextID
+	run.CreatedAt = now
+	run.UpdatedAt = now
+	r.runs = append(r.runs, copyRun(run))
+	return run, nil
+}
+
+func (r *MemRunRepo) Update(run *Run) (*Run, error) {
+	r.Lock()
+	defer r.Unlock()
+	for i, existing := range r.runs {
+		if existing.ID == run.ID {
+			run.UpdatedAt = time.Now()
+			r.runs[i] = copyRun(run)
+			return run, nil
+		}
+	}
+	return nil, fmt.Errorf("Cannot find run with id %d", run.ID)
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunsFind(t *testing.T) {
+	one, two := NewJob("One"), NewJob("Two")
+	runRepo := NewMemRunRepo([]*Run{NewRun(one), NewRun(two), NewRun(one)})
+	runs, _ := runRepo.Find("one")
+	assert.Equal(t, 2, len(runs))
+}
+
+func TestRunsFindOne(t *testing.T) {
+	runRepo := NewMemRunRepo([]*Run{NewRun(NewJob("One"))})
+	run, err := runRepo.FindOne(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "one", run.JobSlug)
+	assert.Equal(t, Queued, run.State)
+}
+
+func TestRunsFindOneMissing(t *testing.T) {
+	runRepo := NewMemRunRepo([]*Run{})
+	run, err := runRepo.FindOne(17)
+	assert.Nil(t, run)
+	assert.EqualError(t, err, "No run found with id: 17")
+}
+
+func TestRunsUpdate(t *testing.T) {
+	runRepo := NewMemRunRepo([]*Run{NewRun(NewJob("One"))})
+	run, _ := runRepo.FindOne(1)
+	run.Start()
+	run.Finish(Failed, 3)
+	_, err := runRepo.Update(run)
+	assert.Nil(t, err)
+	run, _ = runRepo.FindOne(1)
+	assert.Equal(t, Failed, run.State)
+	assert.Equal(t, 3, run.ExitCode)
+	assert.NotNil(t, run.StartedAt)
+	assert.NotNil(t, run.EndedAt)
+}
+
+func TestRunsUpdateFail(t *testing.T) {
+	runRepo := NewMemRunRepo([]*Run{})
+	run, err := runRepo.Update(&Run{})
+	assert.Nil(t, run)
+	assert.EqualError(t, err, "Cannot find run with id 0")
+}
@@ -0,0 +1,236 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrSecretExists is returned when a secret is added with the slug of
+// another.
+var ErrSecretExists = errors.New("Secret already exists")
+
+// Secret is a credential given to the runs of a job, or of the jobs of a
+// team, as the environment variable Env. With AsFile the value is written
+// to a temporary file, and Env is its path. The value is kept encrypted in
+// Ciphertext, and is never returned by the API.
+type Secret struct {
+	gorm.Model
+	Name       string
+	Slug       string `gorm:"unique_index"`
+	Job        string `gorm:"index"`
+	Team       string `gorm:"index"`
+	Env        string
+	AsFile     bool
+	Ciphertext string `json:"-"`
+	// Value is only set when a secret is written.
+	Value string `gorm:"-" json:",omitempty"`
+}
+
+func (s *Secret) update(secret *Secret) {
+	if secret.Name != "" {
+		s.Name = secret.Name
+	}
+	if secret.Slug != "" {
+		s.Slug = secret.Slug
+	}
+	if secret.Job != "" || secret.Team != "" {
+		s.Job = secret.Job
+		s.Team = secret.Team
+	}
+	if secret.Env != "" {
+		s.Env = secret.Env
+	}
+	if secret.AsFile {
+		s.AsFile = secret.AsFile
+	}
+	if secret.Ciphertext != "" {
+		s.Ciphertext = secret.Ciphertext
+	}
+	s.UpdatedAt = time.Now()
+}
+
+func (s *Secret) Validate() error {
+	if (s.Job == "") == (s.Team == "") {
+		return fmt.Errorf("Secret must be scoped to either a job or a team")
+	}
+	if !validEnv(s.Env) {
+		return fmt.Errorf("Invalid environment variable name: %q", s.Env)
+	}
+	return nil
+}
+
+// Seal encrypts the value of the secret, bound to its slug, and clears it.
+func (s *Secret) Seal(c *Cipher) error {
+	ciphertext, err := c.Seal(s.Value, s.Slug)
+	if err != nil {
+		return err
+	}
+	s.Ciphertext = ciphertext
+	s.Value = ""
+	return nil
+}
+
+// Open returns the decrypted value of the secret.
+func (s *Secret) Open(c *Cipher) (string, error) {
+	return c.Open(s.Ciphertext, s.Slug)
+}
+
+// Scoped tells if the secret is given to the runs of the job.
+func (s *Secret) Scoped(job *Job) bool {
+	return s.Job != "" && s.Job == job.Slug || s.Team != "" && s.Team == job.Team
+}
+
+func ParseSecret(reader io.ReadCloser) (*Secret, error) {
+	if reader == nil {
+		return nil, fmt.Errorf("No body to parse")
+	}
+	decoder := json.NewDecoder(reader)
+	defer reader.Close() // errcheck-ignore
+	var secret Secret
+	if err := decoder.Decode(&secret); err != nil {
+		return nil, err
+	}
+	if secret.Slug == "" {
+		secret.Slug = slug(secret.Name)
+	}
+	if err := secret.Validate(); err != nil {
+		return nil, invalid(err)
+	}
+	return &secret, nil
+}
+
+type SecretRepo interface {
+	Find() ([]*Secret, error)
+	// FindScoped returns the secrets given to the runs of the job.
+	FindScoped(job *Job) ([]*Secret, error)
+	FindOne(slug string) (*Secret, error)
+	Add(secret *Secret) (*Secret, error)
+	Update(secret *Secret) (*Secret, error)
+	UpAdd(secret *Secret) (*Secret, error)
+	Delete(slug string) (*Secret, error)
+	// DeleteJob deletes the secrets scoped to the job, and returns them.
+	DeleteJob(job string) ([]*Secret, error)
+}
+
+type MemSecretRepo struct {
+	sync.Mutex
+	secrets []*Secret
+	nextID  uint
+}
+
+func NewMemSecretRepo(secrets []*Secret) *MemSecretRepo {
+	r := &MemSecretRepo{
+		secrets: []*Secret{},
+	}
+	for _, s := range secrets {
+		_, _ = r.Add(s)
+	}
+	return r
+}
+
+func (r *MemSecretRepo) Find() ([]*Secret, error) {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*Secret{}, r.secrets...), nil
+}
+
+func (r *MemSecretRepo) FindScoped(job *Job) ([]*Secret, error) {
+	r.Lock()
+	defer r.Unlock()
+	secrets := []*Secret{}
+	for _, s := range r.secrets {
+		if s.Scoped(job) {
+			secrets = append(secrets, s)
+		}
+	}
+	return secrets, nil
+}
+
+func (r *MemSecretRepo) FindOne(slug string) (*Secret, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.findOne(slug)
+}
+
+func (r *MemSecretRepo) findOne(slug string) (*Secret, error) {
+	for _, s := range r.secrets {
+		if s.Slug == slug {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("No secret found with slug: %s", slug)
+}
+
+func (r *MemSecretRepo) Add(secret *Secret) (*Secret, error) {
+	r.Lock()
+	defer r.Unlock()
+	if s, _ := r.findOne(secret.Slug); s != nil {
+		return nil, ErrSecretExists
+	}
+	return r.add(secret)
+}
+
+func (r *MemSecretRepo) add(secret *Secret) (*Secret, error) {
+	r.nextID++
+	now := time.Now()
+	secret.CreatedAt = now
+	secret.UpdatedAt = now
+	secret.ID = r.nextID
+	r.secrets = append(r.secrets, secret)
+	return secret, nil
+}
+
+func (r *MemSecretRepo) Update(secret *Secret) (*Secret, error) {
+	r.Lock()
+	defer r.Unlock()
+	s, _ := r.findOne(secret.Slug)
+	if s == nil {
+		return nil, fmt.Errorf("Cannot find secret with slug %s", secret.Slug)
+	}
+	s.update(secret)
+	return s, nil
+}
+
+func (r *MemSecretRepo) UpAdd(secret *Secret) (*Secret, error) {
+	r.Lock()
+	defer r.Unlock()
+	s, _ := r.findOne(secret.Slug)
+	if s == nil {
+		return r.add(secret)
+	}
+	s.update(secret)
+	return s, nil
+}
+
+func (r *MemSecretRepo) Delete(slug string) (*Secret, error) {
+	r.Lock()
+	defer r.Unlock()
+	for i, s := range r.secrets {
+		if s.Slug == slug {
+			r.secrets = append(r.secrets[:i], r.secrets[i+1:]...)
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("Cannot find secret with slug %s", slug)
+}
+
+func (r *MemSecretRepo) DeleteJob(job string) ([]*Secret, error) {
+	r.Lock()
+	defer r.Unlock()
+	deleted, kept := []*Secret{}, []*Secret{}
+	for _, s := range r.secrets {
+		if s.Job == job {
+			deleted = append(deleted, s)
+		} else {
+			kept = append(kept, s)
+		}
+	}
+	r.secrets = kept
+	return deleted, nil
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+func TestCipher(t *testing.T) {
+	cipher, err := NewCipher(testKey)
+	assert.Nil(t, err)
+	sealed, err := cipher.Seal("hunter2", "db-password")
+	assert.Nil(t, err)
+	assert.NotContains(t, sealed, "hunter2")
+	other, _ := cipher.Seal("hunter2", "db-password")
+	assert.NotEqual(t, sealed, other)
+
+	value, err := cipher.Open(sealed, "db-password")
+	assert.Nil(t, err)
+	assert.Equal(t, "hunter2", value)
+	_, err = cipher.Open(sealed, "api-token")
+	assert.EqualError(t, err, "Failed to decrypt secret")
+	_, err = cipher.Open("short", "db-password")
+	assert.EqualError(t, err, "Failed to decrypt secret")
+}
+
+func TestNewCipherInvalidKey(t *testing.T) {
+	_, err := NewCipher("c2hvcnQ=")
+	assert.EqualError(t, err, "Master key must be 32 bytes encoded in base64")
+}
+
+func TestParseSecretInvalid(t *testing.T) {
+	cases := map[string]string{
+		`{"Name": "a", "Env": "A"}`:                              "Secret must be scoped to either a job or a team",
+		`{"Name": "a", "Env": "A", "Job": "one", "Team": "ops"}`: "Secret must be scoped to either a job or a team",
+		`{"Name": "a", "Env": "A=B", "Job": "one"}`:              `Invalid environment variable name: "A=B"`,
+	}
+	for body, msg := range cases {
+		secret, err := ParseSecret(ioutil.NopCloser(strings.NewReader(body)))
+		assert.Nil(t, secret)
+		assert.EqualError(t, err, msg)
+	}
+}
+
+func TestSecretsFindScoped(t *testing.T) {
+	repo := NewMemSecretRepo([]*Secret{
+		{Slug: "a", Job: "one", Env: "A"},
+		{Slug: "b", Team: "ops", Env: "B"},
+		{Slug: "c", Job: "two", Env: "C"},
+	})
+	job := NewJob("One")
+	job.Team = "ops"
+	secrets, err := repo.FindScoped(job)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(secrets))
+	assert.Equal(t, "a", secrets[0].Slug)
+	assert.Equal(t, "b", secrets[1].Slug)
+	secrets, _ = repo.FindScoped(NewJob("Three"))
+	assert.Equal(t, 0, len(secrets))
+}
+
+func TestSecretsDeleteJob(t *testing.T) {
+	repo := NewMemSecretRepo([]*Secret{
+		{Slug: "a", Job: "one", Env: "A"},
+		{Slug: "b", Team: "ops", Env: "B"},
+		{Slug: "c", Job: "two", Env: "C"},
+	})
+	secrets, err := repo.DeleteJob("one")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(secrets))
+	assert.Equal(t, "a", secrets[0].Slug)
+	secrets, _ = repo.Find()
+	assert.Equal(t, 2, len(secrets))
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Team owns jobs, see Job.Team. The queue is shared between the teams in
+// proportion to their weights.
+type Team struct {
+	gorm.Model
+	Name   string
+	Slug   string
+	Weight int
+}
+
+func NewTeam(name string, weight int) *Team {
+	return &Team{Name: name, Slug: slug(name), Weight: weight}
+}
+
+func (t *Team) update(team *Team) {
+	if team.Name != "" {
+		t.Name = team.Name
+	}
+	if team.Slug != "" {
+		t.Slug = team.Slug
+	}
+	if team.Weight != 0 {
+		t.Weight = team.Weight
+	}
+	t.UpdatedAt = time.Now()
+}
+
+func (t *Team) Validate() error {
+	if t.Weight < 1 {
+		return fmt.Errorf("Team weight must be at least 1: %d", t.Weight)
+	}
+	return nil
+}
+
+func ParseTeam(reader io.ReadCloser) (*Team, error) {
+	if reader == nil {
+		return nil, fmt.Errorf("No body to parse")
+	}
+	decoder := json.NewDecoder(reader)
+	defer reader.Close() // errcheck-ignore
+	var team Team
+	if err := decoder.Decode(&team); err != nil {
+		return nil, err
+	}
+	if team.Slug == "" {
+		team.Slug = slug(team.Name)
+	}
+	if err := team.Validate(); err != nil {
+		return nil, invalid(err)
+	}
+	return &team, nil
+}
+
+type TeamRepo interface {
+	Find() ([]*Team, error)
+	FindOne(slug string) (*Team, error)
+	Add(team *Team) (*Team, error)
+	Update(team *Team) (*Team, error)
+	UpAdd(team *Team) (*Team, error)
+	Delete(slug string) (*Team, error)
+}
+
+type MemTeamRepo struct {
+	teams  []*Team
+	nextID uint
+}
+
+func NewMemTeamRepo(teams []*Team) *MemTeamRepo {
+	r := &MemTeamRepo{
+		teams: []*Team{},
+	}
+	for _, t := range teams {
+		_, _ = r.Add(t)
+	}
+	return r
+}
+
+func (r *MemTeamRepo) Find() ([]*Team, error) {
+	return r.teams, nil
+}
+
+func (r *MemTeamRepo) FindOne(slug string) (*Team, error) {
+	for _, t := range r.teams {
+		if t.Slug == slug {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("No team found with slug: %s", slug)
+}
+
+func (r *MemTeamRepo) Add(team *Team) (*Team, error) {
+	r.nextID++
+	now := time.Now()
+	team.CreatedAt = now
+	team.UpdatedAt = now
+	team.ID = r.nextID
+	r.teams = append(r.teams, team)
+	return team, nil
+}
+
+func (r *MemTeamRepo) Update(team *Team) (*Team, error) {
+	t, _ := r.FindOne(team.Slug)
+	if t == nil {
+		return nil, fmt.Errorf("Cannot find team with slug %s", team.Slug)
+	}
+	t.update(team)
+	return t, nil
+}
+
+func (r *MemTeamRepo) UpAdd(team *Team) (*Team, error) {
+	t, _ := r.FindOne(team.Slug)
+	if t == nil {
+		return r.Add(team)
+	}
+	t.update(team)
+	return t, nil
+}
+
+func (r *MemTeamRepo) Delete(slug string) (*Team, error) {
+	for i, t := range r.teams {
+		if t.Slug == slug {
+			r.teams = append(r.teams[:i], r.teams[i+1:]...)
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("Cannot find team with slug %s", slug)
+}
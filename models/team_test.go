@@ -0,0 +1,18 @@
+package models
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTeam(t *testing.T) {
+	team, err := ParseTeam(ioutil.NopCloser(strings.NewReader(`{"Name": "Web", "Weight": 3}`)))
+	assert.Nil(t, err)
+	assert.Equal(t, "web", team.Slug)
+	assert.Equal(t, 3, team.Weight)
+	_, err = ParseTeam(ioutil.NopCloser(strings.NewReader(`{"Name": "Web", "Weight": -1}`)))
+	assert.EqualError(t, err, "Team weight must be at least 1: -1")
+}
@@ -0,0 +1,215 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	ErrInvalidToken = errors.New("Invalid token")
+	ErrTokenExpired = errors.New("Token has expired")
+	ErrTokenRevoked = errors.New("Token has been revoked")
+)
+
+// Token is an API credential of a user, given as "KeyID.secret". It is
+// sent as a bearer token, or used by machine clients to sign requests, see
+// Signature. Only a hash of the secret is kept for bearer tokens, and the
+// secret itself encrypted, if there is a master key, for signing.
+type Token struct {
+	gorm.Model
+	Name      string
+	KeyID     string `gorm:"unique_index"`
+	User      string
+	ExpiresAt *time.Time
+	RevokedAt *time.Time
+	Hash      string `json:"-"`
+	Sealed    string `json:"-"`
+	// Token is only set when the token is created.
+	Token string `gorm:"-" json:",omitempty"`
+}
+
+// NewToken creates a token for the user with a random secret. The secret is
+// sealed with cipher, unless it is nil.
+func NewToken(name, user string, expiresAt *time.Time, cipher *Cipher) (*Token, error) {
+	keyID, err := randomString(8, hex.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, err
+	}
+	t := &Token{
+		Name:      name,
+		KeyID:     keyID,
+		User:      user,
+		ExpiresAt: expiresAt,
+		Hash:      hexSHA256(secret),
+		Token:     keyID + "." + secret,
+	}
+	if cipher != nil {
+		if t.Sealed, err = cipher.Seal(secret, keyID); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func randomString(size int, encode func([]byte) string) (string, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
+
+// SplitToken returns the key id and secret of a token.
+func SplitToken(token string) (keyID, secret string, err error) {
+	i := strings.Index(token, ".")
+	if i < 1 || i == len(token)-1 {
+		return "", "", ErrInvalidToken
+	}
+	return token[:i], token[i+1:], nil
+}
+
+// Valid tells why the token cannot be used at the time, if it cannot.
+func (t *Token) Valid(now time.Time) error {
+	if t.RevokedAt != nil {
+		return ErrTokenRevoked
+	}
+	if t.ExpiresAt != nil && !now.Before(*t.ExpiresAt) {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+// Verify checks the secret of a bearer token.
+func (t *Token) Verify(secret string) error {
+	if subtle.ConstantTimeCompare([]byte(hexSHA256(secret)), []byte(t.Hash)) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// Secret returns the secret of the token, to check signatures with.
+func (t *Token) Secret(cipher *Cipher) (string, error) {
+	if t.Sealed == "" || cipher == nil {
+		return "", fmt.Errorf("Token %s cannot sign requests", t.KeyID)
+	}
+	return cipher.Open(t.Sealed, t.KeyID)
+}
+
+// SignatureScheme names the Authorization scheme of signed requests:
+//
+//	Authorization: JOBS-HMAC-SHA256 Credential=<key id>, Signature=<hex>
+//
+// with the time of the request in the X-Jobs-Date header, as
+// 20060102T150405Z.
+const SignatureScheme = "JOBS-HMAC-SHA256"
+
+const SignatureDateFormat = "20060102T150405Z"
+
+// Signature returns the HMAC-SHA256, in hex, of the method, request URI,
+// date and SHA-256 of the body of a request, signed with the secret.
+func Signature(secret, method, uri, date string, body []byte) string {
+	sum := sha256.Sum256(body)
+	data := strings.Join([]string{method, uri, date, hex.EncodeToString(sum[:])}, "\n")
+	return hex.EncodeToString(hmacSHA256([]byte(secret), data))
+}
+
+func ParseToken(reader io.ReadCloser) (*Token, error) {
+	if reader == nil {
+		return nil, fmt.Errorf("No body to parse")
+	}
+	decoder := json.NewDecoder(reader)
+	defer reader.Close() // errcheck-ignore
+	var token Token
+	if err := decoder.Decode(&token); err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt != nil && !token.ExpiresAt.After(time.Now()) {
+		return nil, invalid(fmt.Errorf("Token must expire in the future: %s", token.ExpiresAt))
+	}
+	return &token, nil
+}
+
+type TokenRepo interface {
+	Find() ([]*Token, error)
+	FindOne(keyID string) (*Token, error)
+	Add(token *Token) (*Token, error)
+	Update(token *Token) (*Token, error)
+}
+
+type MemTokenRepo struct {
+	sync.Mutex
+	tokens []*Token
+	nextID uint
+}
+
+func NewMemTokenRepo(tokens []*Token) *MemTokenRepo {
+	r := &MemTokenRepo{
+		tokens: []*Token{},
+	}
+	for _, t := range tokens {
+		_, _ = r.Add(t)
+	}
+	return r
+}
+
+func (r *MemTokenRepo) Find() ([]*Token, error) {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*Token{}, r.tokens...), nil
+}
+
+func (r *MemTokenRepo) FindOne(keyID string) (*Token, error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, t := range r.tokens {
+		if t.KeyID == keyID {
+			c := *t
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("No token found with key id: %s", keyID)
+}
+
+func (r *MemTokenRepo) Add(token *Token) (*Token, error) {
+	r.Lock()
+	defer r.Unlock()
+	r.nextID++
+	now := time.Now()
+	token.CreatedAt = now
+	token.UpdatedAt = now
+	token.ID = r.nextID
+	c := *token
+	c.Token = ""
+	r.tokens = append(r.tokens, &c)
+	return token, nil
+}
+
+func (r *MemTokenRepo) Update(token *Token) (*Token, error) {
+	r.Lock()
+	defer r.Unlock()
+	for i, t := range r.tokens {
+		if t.KeyID == token.KeyID {
+			c := *token
+			c.UpdatedAt = time.Now()
+			r.tokens[i] = &c
+			return &c, nil
+		}
+	}
+	return nil, fmt.Errorf("Cannot find token with key id %s", token.KeyID)
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewToken(t *testing.T) {
+	cipher, _ := NewCipher(testKey)
+	token, err := NewToken("ci", "alice", nil, cipher)
+	assert.Nil(t, err)
+	keyID, secret, err := SplitToken(token.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, token.KeyID, keyID)
+	assert.NotContains(t, token.Hash, secret)
+	assert.Nil(t, token.Verify(secret))
+	assert.Equal(t, ErrInvalidToken, token.Verify("wrong"))
+	opened, err := token.Secret(cipher)
+	assert.Nil(t, err)
+	assert.Equal(t, secret, opened)
+
+	unsealed, _ := NewToken("ci", "alice", nil, nil)
+	_, err = unsealed.Secret(cipher)
+	assert.EqualError(t, err, "Token "+unsealed.KeyID+" cannot sign requests")
+}
+
+func TestSplitTokenInvalid(t *testing.T) {
+	for _, token := range []string{"", "abc", ".abc", "abc."} {
+		_, _, err := SplitToken(token)
+		assert.Equal(t, ErrInvalidToken, err, token)
+	}
+}
+
+func TestTokenValid(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	token := &Token{ExpiresAt: &later}
+	assert.Nil(t, token.Valid(now))
+	assert.Equal(t, ErrTokenExpired, token.Valid(later))
+	token.RevokedAt = &now
+	assert.Equal(t, ErrTokenRevoked, token.Valid(now))
+}
+
+func TestSignature(t *testing.T) {
+	s := Signature("secret", "POST", "/jobs/one/runs/?priority=1", "20261018T120000Z", []byte(`{}`))
+	assert.Equal(t, 64, len(s))
+	assert.Equal(t, s, Signature("secret", "POST", "/jobs/one/runs/?priority=1", "20261018T120000Z", []byte(`{}`)))
+	assert.NotEqual(t, s, Signature("secret", "POST", "/jobs/one/runs/?priority=2", "20261018T120000Z", []byte(`{}`)))
+	assert.NotEqual(t, s, Signature("secret", "POST", "/jobs/one/runs/?priority=1", "20261018T120000Z", []byte(`{"a":1}`)))
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal([]string(l))
+	return string(b), err
+}
+
+func (l *StringList) Scan(src interface{}) error {
+	return scanJson(src, l)
+}
+
+func (l StringList) contains(s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+type StringMap map[string]string
+
+func (m StringMap) Value() (driver.Value, error) {
+	if m == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(map[string]string(m))
+	return string(b), err
+}
+
+func (m *StringMap) Scan(src interface{}) error {
+	return scanJson(src, m)
+}
+
+func scanJson(src interface{}, dest interface{}) error {
+	switch s := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(s, dest)
+	case string:
+		return json.Unmarshal([]byte(s), dest)
+	}
+	return fmt.Errorf("Cannot scan %T as json", src)
+}
+
+// Duration is a time.Duration that is written as a string, "1m30s", in
+// JSON. When reading, a plain number is taken as seconds.
+type Duration time.Duration
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case nil:
+		*d = 0
+	case float64:
+		*d = Duration(value * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("Invalid duration: %s", string(b))
+	}
+	return nil
+}
+
+func (d Duration) Value() (driver.Value, error) {
+	return int64(d), nil
+}
+
+func (d *Duration) Scan(src interface{}) error {
+	switch s := src.(type) {
+	case nil:
+		*d = 0
+	case int64:
+		*d = Duration(s)
+	default:
+		return fmt.Errorf("Cannot scan %T as duration", src)
+	}
+	return nil
+}
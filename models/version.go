@@ -0,0 +1,160 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrVersionConflict is returned when a job was changed by someone else
+// since it was read.
+var ErrVersionConflict = errors.New("Job has been changed since it was read")
+
+// JobVersion is a revision of the definition of a job. The versions of a
+// job are numbered from 1, and Job.Version is that of its definition. Each
+// number is only kept once, even when versions are added concurrently.
+type JobVersion struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	JobSlug   string `gorm:"unique_index:idx_job_slug_version"`
+	Version   int    `gorm:"unique_index:idx_job_slug_version"`
+	Author    string
+	Job       JobDefinition `gorm:"type:text"`
+}
+
+// JobDefinition is what users define of a job, without the ids, times,
+// owner and version kept by the server.
+type JobDefinition Job
+
+func (d JobDefinition) Value() (driver.Value, error) {
+	b, err := json.Marshal(d)
+	return string(b), err
+}
+
+func (d *JobDefinition) Scan(src interface{}) error {
+	return scanJson(src, d)
+}
+
+// Definition returns the definition of the job.
+func (j *Job) Definition() JobDefinition {
+	d := JobDefinition(*j)
+	d.Model = gorm.Model{}
+	d.NextRunAt, d.LastRunAt = nil, nil
+	d.Owner = ""
+	d.Version = 0
+	return d
+}
+
+// Changes returns the changes of the definition from the job to next.
+func (j *Job) Changes(next *Job) Changes {
+	return Diff(Snapshot(j.Definition()), Snapshot(next.Definition()))
+}
+
+func NewJobVersion(job *Job, author string) *JobVersion {
+	return &JobVersion{
+		JobSlug: job.Slug,
+		Version: job.Version,
+		Author:  author,
+		Job:     job.Definition(),
+	}
+}
+
+// Restore returns the job with the definition of the version.
+func (v *JobVersion) Restore(job *Job) *Job {
+	restored := Job(v.Job)
+	return job.Replaced(&restored)
+}
+
+// Diff returns the changes of the definition from the version to the
+// other.
+func (v *JobVersion) Diff(other *JobVersion) Changes {
+	return Diff(Snapshot(v.Job), Snapshot(other.Job))
+}
+
+type JobVersionRepo interface {
+	// Find returns the versions of the job, oldest first.
+	Find(slug string) ([]*JobVersion, error)
+	FindOne(slug string, version int) (*JobVersion, error)
+	// Add fails with ErrVersionConflict if the job already has the version.
+	Add(version *JobVersion) (*JobVersion, error)
+	// Delete removes a version that the job could not be saved at.
+	Delete(slug string, version int) error
+}
+
+// NextVersion returns the number of the next version of the job. It
+// continues after the versions of a deleted job of the same slug.
+func NextVersion(versions JobVersionRepo, slug string) (int, error) {
+	found, err := versions.Find(slug)
+	if err != nil {
+		return 0, err
+	}
+	if len(found) == 0 {
+		return 1, nil
+	}
+	return found[len(found)-1].Version + 1, nil
+}
+
+type MemJobVersionRepo struct {
+	sync.Mutex
+	versions []*JobVersion
+	nextID   uint
+}
+
+func NewMemJobVersionRepo() *MemJobVersionRepo {
+	return &MemJobVersionRepo{versions: []*JobVersion{}}
+}
+
+func (r *MemJobVersionRepo) Find(slug string) ([]*JobVersion, error) {
+	r.Lock()
+	defer r.Unlock()
+	versions := []*JobVersion{}
+	for _, v := range r.versions {
+		if v.JobSlug == slug {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func (r *MemJobVersionRepo) FindOne(slug string, version int) (*JobVersion, error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, v := range r.versions {
+		if v.JobSlug == slug && v.Version == version {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("No version %d found of job %s", version, slug)
+}
+
+func (r *MemJobVersionRepo) Add(version *JobVersion) (*JobVersion, error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, v := range r.versions {
+		if v.JobSlug == version.JobSlug && v.Version == version.Version {
+			return nil, ErrVersionConflict
+		}
+	}
+	r.nextID++
+	version.ID = r.nextID
+	version.CreatedAt = time.Now()
+	r.versions = append(r.versions, version)
+	return version, nil
+}
+
+func (r *MemJobVersionRepo) Delete(slug string, version int) error {
+	r.Lock()
+	defer r.Unlock()
+	for i, v := range r.versions {
+		if v.JobSlug == slug && v.Version == version {
+			r.versions = append(r.versions[:i], r.versions[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("No version %d found of job %s", version, slug)
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobDefinition(t *testing.T) {
+	next := time.Now()
+	job := &Job{Name: "Deploy", Slug: "deploy", Command: "make", Owner: "alice", Version: 3, NextRunAt: &next}
+	job.ID = 7
+	d := job.Definition()
+	assert.Equal(t, uint(0), d.ID)
+	assert.Equal(t, "", d.Owner)
+	assert.Equal(t, 0, d.Version)
+	assert.Nil(t, d.NextRunAt)
+	assert.Equal(t, "make", d.Command)
+
+	assert.Equal(t, Changes{}, job.Changes(job.Merge(&Job{Command: "make", Version: 4})))
+	assert.Equal(t, Changes{"Command": {Before: "make", After: "make deploy"}}, job.Changes(job.Merge(&Job{Command: "make deploy"})))
+	assert.Equal(t, "make", job.Command)
+}
+
+func TestJobVersionRestore(t *testing.T) {
+	v := NewJobVersion(&Job{Slug: "deploy", Command: "make", Version: 1}, "alice")
+	next := time.Now()
+	job := &Job{Slug: "deploy", Command: "make deploy", Schedule: "@daily", Owner: "alice", Version: 2, NextRunAt: &next}
+	job.ID = 7
+	restored := v.Restore(job)
+	assert.Equal(t, uint(7), restored.ID)
+	assert.Equal(t, "make", restored.Command)
+	assert.Equal(t, "", restored.Schedule)
+	assert.Nil(t, restored.NextRunAt)
+	assert.Equal(t, "alice", restored.Owner)
+	assert.Equal(t, Changes{"Command": {Before: "make", After: "make deploy"}, "Schedule": {Before: "", After: "@daily"}},
+		v.Diff(NewJobVersion(job, "bob")))
+}
+
+func TestNextVersion(t *testing.T) {
+	repo := NewMemJobVersionRepo()
+	n, _ := NextVersion(repo, "deploy")
+	assert.Equal(t, 1, n)
+	_, _ = repo.Add(NewJobVersion(&Job{Slug: "deploy", Version: 1}, "alice"))
+	_, _ = repo.Add(NewJobVersion(&Job{Slug: "deploy", Version: 2}, "alice"))
+	n, _ = NextVersion(repo, "deploy")
+	assert.Equal(t, 3, n)
+	v, err := repo.FindOne("deploy", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", v.Author)
+	_, err = repo.FindOne("deploy", 3)
+	assert.EqualError(t, err, "No version 3 found of job deploy")
+	_, err = repo.Add(NewJobVersion(&Job{Slug: "deploy", Version: 2}, "bob"))
+	assert.Equal(t, ErrVersionConflict, err)
+	assert.Nil(t, repo.Delete("deploy", 2))
+	n, _ = NextVersion(repo, "deploy")
+	assert.Equal(t, 2, n)
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Worker is a worker process, as registered by itself. It executes up to
+// Capacity runs at a time, of jobs whose placement its labels match.
+type Worker struct {
+	ID          uint      `gorm:"primary_key"`
+	Name        string    `gorm:"unique_index"`
+	Labels      StringMap `gorm:"type:text"`
+	Capacity    int
+	StartedAt   time.Time
+	HeartbeatAt time.Time
+	// LeaseExpiresAt is when the worker is taken to have stopped, unless it
+	// heartbeats before.
+	LeaseExpiresAt time.Time
+	// Runs are the ids of the runs the worker is executing.
+	Runs []uint `gorm:"-"`
+}
+
+// Matches tells if the worker has all the labels the item requires, and
+// none of the labels it avoids.
+func (w *Worker) Matches(item *QueueItem) bool {
+	for k, v := range item.Labels {
+		if w.Labels[k] != v {
+			return false
+		}
+	}
+	for k, v := range item.AntiAffinity {
+		if l, ok := w.Labels[k]; ok && l == v {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLabels parses labels written as "os=linux,zone=a".
+func ParseLabels(s string) (StringMap, error) {
+	labels := StringMap{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("Invalid label: %q", pair)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
+
+func validateLabels(labels StringMap) error {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "" || strings.ContainsAny(k, "=,") {
+			return fmt.Errorf("Invalid label name: %q", k)
+		}
+	}
+	return nil
+}
+
+type WorkerRepo interface {
+	// Register adds the worker, or replaces the one with the same name,
+	// leased for lease. Workers whose leases have expired are removed.
+	Register(worker *Worker, lease time.Duration) error
+	// Heartbeat extends the lease of the worker by lease.
+	Heartbeat(name string, lease time.Duration) error
+	Remove(name string) error
+	// Find returns the workers whose leases have not expired.
+	Find() ([]*Worker, error)
+}
+
+type MemWorkerRepo struct {
+	sync.Mutex
+	workers []*Worker
+	nextID  uint
+}
+
+func NewMemWorkerRepo() *MemWorkerRepo {
+	return &MemWorkerRepo{
+		workers: []*Worker{},
+	}
+}
+
+func (r *MemWorkerRepo) Register(worker *Worker, lease time.Duration) error {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	worker.StartedAt = now
+	worker.HeartbeatAt = now
+	worker.LeaseExpiresAt = now.Add(lease)
+	r.nextID++
+	worker.ID = r.nextID
+	c := *worker
+	r.remove(worker.Name)
+	live := []*Worker{}
+	for _, w := range r.workers {
+		if w.LeaseExpiresAt.After(now) {
+			live = append(live, w)
+		}
+	}
+	r.workers = append(live, &c)
+	return nil
+}
+
+func (r *MemWorkerRepo) Heartbeat(name string, lease time.Duration) error {
+	r.Lock()
+	defer r.Unlock()
+	for _, w := range r.workers {
+		if w.Name == name {
+			w.HeartbeatAt = time.Now()
+			w.LeaseExpiresAt = w.HeartbeatAt.Add(lease)
+			return nil
+		}
+	}
+	return fmt.Errorf("No worker found with name: %s", name)
+}
+
+func (r *MemWorkerRepo) Remove(name string) error {
+	r.Lock()
+	defer r.Unlock()
+	r.remove(name)
+	return nil
+}
+
+func (r *MemWorkerRepo) remove(name string) {
+	for n, w := range r.workers {
+		if w.Name == name {
+			r.workers = append(r.workers[:n], r.workers[n+1:]...)
+			return
+		}
+	}
+}
+
+func (r *MemWorkerRepo) Find() ([]*Worker, error) {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	workers := []*Worker{}
+	for _, w := range r.workers {
+		if w.LeaseExpiresAt.After(now) {
+			c := *w
+			workers = append(workers, &c)
+		}
+	}
+	return workers, nil
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("os=linux, zone=a,,pool=")
+	assert.Nil(t, err)
+	assert.Equal(t, StringMap{"os": "linux", "zone": "a", "pool": ""}, labels)
+	_, err = ParseLabels("os")
+	assert.EqualError(t, err, `Invalid label: "os"`)
+}
+
+func TestWorkerMatches(t *testing.T) {
+	worker := &Worker{Labels: StringMap{"os": "linux", "zone": "a"}}
+	assert.True(t, worker.Matches(&QueueItem{}))
+	assert.True(t, worker.Matches(&QueueItem{Labels: StringMap{"os": "linux"}}))
+	assert.False(t, worker.Matches(&QueueItem{Labels: StringMap{"os": "windows"}}))
+	assert.False(t, worker.Matches(&QueueItem{Labels: StringMap{"pool": "gpu"}}))
+	assert.True(t, worker.Matches(&QueueItem{AntiAffinity: StringMap{"zone": "b"}}))
+	assert.False(t, worker.Matches(&QueueItem{AntiAffinity: StringMap{"zone": "a"}}))
+}
+
+func TestMemWorkerRepo(t *testing.T) {
+	repo := NewMemWorkerRepo()
+	assert.Nil(t, repo.Register(&Worker{Name: "a", Capacity: 2}, time.Minute))
+	assert.Nil(t, repo.Register(&Worker{Name: "b", Capacity: 1}, time.Minute))
+	assert.Nil(t, repo.Register(&Worker{Name: "a", Capacity: 4}, time.Minute))
+	workers, _ := repo.Find()
+	assert.Equal(t, 2, len(workers))
+	assert.Equal(t, "a", workers[1].Name)
+	assert.Equal(t, 4, workers[1].Capacity)
+	assert.NotZero(t, workers[1].HeartbeatAt)
+
+	assert.Nil(t, repo.Heartbeat("a", time.Minute))
+	assert.EqualError(t, repo.Heartbeat("c", time.Minute), "No worker found with name: c")
+	assert.Nil(t, repo.Remove("a"))
+	workers, _ = repo.Find()
+	assert.Equal(t, 1, len(workers))
+}
+
+func TestMemWorkerRepoExpires(t *testing.T) {
+	repo := NewMemWorkerRepo()
+	assert.Nil(t, repo.Register(&Worker{Name: "crashed"}, -time.Second))
+	assert.Nil(t, repo.Register(&Worker{Name: "live"}, time.Minute))
+	workers, _ := repo.Find()
+	assert.Equal(t, 1, len(workers))
+	assert.Equal(t, "live", workers[0].Name)
+	assert.EqualError(t, repo.Heartbeat("crashed", time.Minute), "No worker found with name: crashed")
+}
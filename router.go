@@ -16,3 +16,61 @@ func setupRouter(router *mux.Route, controller controllers.RestController) *mux.
 	subRouter.HandleFunc("/{slug}/edit", controller.Edit).Methods("GET")
 	return subRouter
 }
+
+func setupRunRouter(router *mux.Route, controller *controllers.RunController) *mux.Router {
+	var subRouter = router.Subrouter()
+	subRouter.HandleFunc("/", controller.Index).Methods("GET")
+	subRouter.HandleFunc("/", controller.Create).Methods("POST")
+	subRouter.HandleFunc("/{id:[0-9]+}", controller.Show).Methods("GET")
+	subRouter.HandleFunc("/{id:[0-9]+}", controller.Destroy).Methods("DELETE")
+	subRouter.HandleFunc("/{id:[0-9]+}/log", controller.Log).Methods("GET")
+	subRouter.HandleFunc("/{id:[0-9]+}/log/stream", controller.LogStream).Methods("GET")
+	return subRouter
+}
+
+// setupVersionRouter serves the versions of the job of the slug in the
+// prefix, and its rollback.
+func setupVersionRouter(router *mux.Route, controller *controllers.VersionController) *mux.Router {
+	var subRouter = router.Subrouter()
+	subRouter.HandleFunc("/versions/", controller.Index).Methods("GET")
+	subRouter.HandleFunc("/versions/{version:[0-9]+}", controller.Show).Methods("GET")
+	subRouter.HandleFunc("/versions/{version:[0-9]+}/diff", controller.Diff).Methods("GET")
+	subRouter.HandleFunc("/rollback", controller.Rollback).Methods("POST")
+	return subRouter
+}
+
+// setupArtifactRouter serves the artifacts of a run under prefix, and all of
+// them as prefix.tar.gz.
+func setupArtifactRouter(router *mux.Router, prefix string, controller *controllers.ArtifactController) *mux.Router {
+	router.HandleFunc(prefix+".tar.gz", controller.Archive).Methods("GET")
+	var subRouter = router.PathPrefix(prefix).Subrouter()
+	subRouter.HandleFunc("/", controller.Index).Methods("GET")
+	subRouter.HandleFunc("/{name:.+}", controller.Show).Methods("GET")
+	return subRouter
+}
+
+func setupPipelineRunRouter(router *mux.Route, controller *controllers.PipelineRunController) *mux.Router {
+	var subRouter = router.Subrouter()
+	subRouter.HandleFunc("/", controller.Index).Methods("GET")
+	subRouter.HandleFunc("/", controller.Create).Methods("POST")
+	subRouter.HandleFunc("/{id:[0-9]+}", controller.Show).Methods("GET")
+	return subRouter
+}
+
+func setupWorkerRouter(router *mux.Route, controller *controllers.WorkerController) *mux.Router {
+	var subRouter = router.Subrouter()
+	subRouter.HandleFunc("/", controller.Index).Methods("GET")
+	return subRouter
+}
+
+func setupQueueRouter(router *mux.Route, controller *controllers.QueueController) *mux.Router {
+	var subRouter = router.Subrouter()
+	subRouter.HandleFunc("/", controller.Index).Methods("GET")
+	return subRouter
+}
+
+func setupAuditRouter(router *mux.Route, controller *controllers.AuditController) *mux.Router {
+	var subRouter = router.Subrouter()
+	subRouter.HandleFunc("/", controller.Index).Methods("GET")
+	return subRouter
+}
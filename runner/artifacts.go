@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/andersjanmyr/jobs/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// storeArtifacts stores the files matching the job's artifact patterns,
+// whatever the outcome of the run. Files that fail to be stored are
+// reported in the log of the run.
+func (r *Runner) storeArtifacts(job *models.Job, run *models.Run, capture *logCapture) {
+	if len(job.Artifacts) == 0 || r.Artifacts == nil || r.Blobs == nil {
+		return
+	}
+	dir := job.Dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	stored := map[string]bool{}
+	for _, pattern := range job.Artifacts {
+		paths, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			capture.append(models.Stderr, fmt.Sprintf("Invalid artifact pattern %q: %s", pattern, err))
+			continue
+		}
+		for _, path := range paths {
+			name, err := filepath.Rel(dir, path)
+			if err != nil || stored[name] {
+				continue
+			}
+			stored[name] = true
+			if err := r.storeArtifact(run, filepath.ToSlash(name), path); err != nil {
+				log.Warn("Failed to store artifact ", name, " of run ", run.ID, ": ", err)
+				capture.append(models.Stderr, fmt.Sprintf("Failed to store artifact %s: %s", name, err))
+			}
+		}
+	}
+}
+
+// storeArtifact puts the file in the blob store, hashing it on the way, and
+// adds it as an artifact of the run. Anything but a regular file is skipped.
+func (r *Runner) storeArtifact(run *models.Run, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // errcheck-ignore
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return err
+	}
+	artifact := &models.Artifact{RunID: run.ID, Name: name, Size: info.Size()}
+	hash := sha256.New()
+	if err := r.Blobs.Put(artifact.Key(), io.TeeReader(f, hash), info.Size()); err != nil {
+		return err
+	}
+	artifact.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	_, err = r.Artifacts.Add(artifact)
+	return err
+}
@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// logCapture numbers the lines written to stdout and stderr of a run and
+// appends them to the log store.
+type logCapture struct {
+	sync.Mutex
+	store models.LogStore
+	runID uint
+	seq   int
+	wg    sync.WaitGroup
+	masks []string
+}
+
+// newLogCapture continues after the lines already stored, from earlier
+// executions of the run.
+func newLogCapture(store models.LogStore, runID uint) *logCapture {
+	lines, err := store.Read(runID, 0, 0)
+	if err != nil {
+		log.Error("Failed to read log of run ", runID, ": ", err)
+	}
+	return &logCapture{store: store, runID: runID, seq: len(lines)}
+}
+
+// pipe returns a writer for the stream. The lines are captured in the
+// background until the writer is closed.
+func (c *logCapture) pipe(stream models.Stream) io.WriteCloser {
+	pr, pw := io.Pipe()
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		reader := bufio.NewReader(pr)
+		for {
+			text, err := reader.ReadString('\n')
+			if text != "" {
+				c.append(stream, strings.TrimSuffix(text, "\n"))
+			}
+			if err != nil {
+				pr.Close() // errcheck-ignore
+				return
+			}
+		}
+	}()
+	return pw
+}
+
+// mask replaces the values, and each line of them, with *** in the lines
+// appended from now on.
+func (c *logCapture) mask(values []string) {
+	c.Lock()
+	defer c.Unlock()
+	for _, value := range values {
+		c.masks = append(c.masks, value)
+		c.masks = append(c.masks, strings.Split(value, "\n")...)
+	}
+	// Longer values first, so that a value containing another is masked
+	// as a whole.
+	sort.Slice(c.masks, func(i, j int) bool { return len(c.masks[i]) > len(c.masks[j]) })
+}
+
+// masked returns the text with the masked values replaced.
+func (c *logCapture) masked(text string) string {
+	c.Lock()
+	defer c.Unlock()
+	return c.replaceMasks(text)
+}
+
+func (c *logCapture) replaceMasks(text string) string {
+	for _, mask := range c.masks {
+		if mask != "" {
+			text = strings.Replace(text, mask, "***", -1)
+		}
+	}
+	return text
+}
+
+func (c *logCapture) append(stream models.Stream, text string) {
+	c.Lock()
+	defer c.Unlock()
+	text = c.replaceMasks(text)
+	line := &models.LogLine{
+		RunID:  c.runID,
+		Seq:    c.seq,
+		Time:   time.Now(),
+		Stream: stream,
+		Text:   text,
+	}
+	if err := c.store.Append(line); err != nil {
+		log.Error("Failed to store log line of run ", c.runID, ": ", err)
+		return
+	}
+	c.seq++
+}
+
+// wait blocks until all captured lines are stored.
+func (c *logCapture) wait() {
+	c.wg.Wait()
+}
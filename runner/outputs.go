@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+// OutputEnv names the environment variable with the path of the file that
+// the command of a run writes its outputs to, as name=value lines.
+const OutputEnv = "JOBS_OUTPUT"
+
+// maxOutputs is the most bytes of outputs read from the file.
+const maxOutputs = 1 << 20
+
+// newOutputFile creates an empty file for the outputs of an attempt.
+func newOutputFile() (string, error) {
+	f, err := ioutil.TempFile("", "jobs-output")
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// readOutputs reads the outputs written to the file. Lines that are not
+// name=value are reported to the log of the run and skipped. Later values
+// replace earlier ones of the same name. Values are masked as the log is.
+func readOutputs(path string, capture *logCapture) (models.StringMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // errcheck-ignore
+	outputs := models.StringMap{}
+	scanner := bufio.NewScanner(io.LimitReader(f, maxOutputs))
+	scanner.Buffer(make([]byte, 64*1024), maxOutputs)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 1 {
+			capture.append(models.Stderr, fmt.Sprintf("Invalid output on line %d, expected name=value: %q", n, line))
+			continue
+		}
+		outputs[line[:i]] = capture.masked(line[i+1:])
+	}
+	return outputs, scanner.Err()
+}
@@ -0,0 +1,308 @@
+package runner
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// PipelineLease is how long a pipeline run is held by the server executing
+// it, which renews the lease three times per lease while waiting.
+var PipelineLease = 30 * time.Second
+
+// NodeTimeout is how long a pipeline waits for the run of a node to finish,
+// before cancelling it and failing the node.
+var NodeTimeout = 24 * time.Hour
+
+// maxPollErrors is how many times in a row the run of a node can fail to be
+// read, before the node is failed.
+const maxPollErrors = 10
+
+type PipelineRunner struct {
+	sync.Mutex
+	// PollInterval is how often a pipeline first checks if the run of a
+	// node has finished. It checks half as often each time, down to a
+	// third of PipelineLease, when it also renews its lease.
+	PollInterval time.Duration
+	runner       *Runner
+	jobs         models.JobRepo
+	pipelineRuns models.PipelineRunRepo
+}
+
+func NewPipelineRunner(runner *Runner, jobs models.JobRepo, pipelineRuns models.PipelineRunRepo) *PipelineRunner {
+	return &PipelineRunner{
+		PollInterval: time.Second,
+		runner:       runner,
+		jobs:         jobs,
+		pipelineRuns: pipelineRuns,
+	}
+}
+
+// Start records a queued run of the pipeline and executes it in the
+// background.
+func (p *PipelineRunner) Start(pipeline *models.Pipeline) (*models.PipelineRun, error) {
+	if err := pipeline.Validate(); err != nil {
+		return nil, err
+	}
+	run := models.NewPipelineRun(pipeline)
+	expires := time.Now().Add(PipelineLease)
+	run.LeaseExpiresAt = &expires
+	run, err := p.pipelineRuns.Add(run)
+	if err != nil {
+		return nil, err
+	}
+	started := *run
+	started.Nodes = append(models.NodeRuns{}, run.Nodes...)
+	go p.Execute(pipeline, &started)
+	return run, nil
+}
+
+// Supervise resumes the unfinished pipeline runs that no server executes,
+// after a restart, at once and then every lease, until stop is closed.
+func (p *PipelineRunner) Supervise(pipelines models.PipelineRepo, stop <-chan struct{}) {
+	ticker := time.NewTicker(PipelineLease)
+	defer ticker.Stop()
+	for {
+		if err := p.Resume(pipelines); err != nil {
+			log.Error("Failed to resume pipeline runs: ", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Resume claims the unfinished pipeline runs whose leases have expired and
+// executes them in the background, from where they were left. A run whose
+// pipeline has been deleted, or has changed nodes, is failed.
+func (p *PipelineRunner) Resume(pipelines models.PipelineRepo) error {
+	runs, err := p.pipelineRuns.Unfinished()
+	if err != nil {
+		return err
+	}
+	for _, r := range runs {
+		run, err := p.pipelineRuns.Claim(r.ID, PipelineLease)
+		if err != nil {
+			return err
+		}
+		if run == nil {
+			continue
+		}
+		pipeline, err := pipelines.FindOne(run.PipelineSlug)
+		if err != nil || !sameNodes(pipeline, run) {
+			log.Warn("Failing pipeline run ", run.ID, " of ", run.PipelineSlug, ", its pipeline has changed")
+			p.fail(run)
+			continue
+		}
+		log.Info("Resuming pipeline run ", run.ID, " of ", run.PipelineSlug)
+		go p.Execute(pipeline, run)
+	}
+	return nil
+}
+
+// sameNodes tells if the run has the nodes of the pipeline.
+func sameNodes(pipeline *models.Pipeline, run *models.PipelineRun) bool {
+	if len(pipeline.Nodes) != len(run.Nodes) {
+		return false
+	}
+	for _, n := range pipeline.Nodes {
+		if node := run.Node(n.Name); node == nil || node.Job != n.Job {
+			return false
+		}
+	}
+	return true
+}
+
+// fail ends the run as failed, with the nodes that have not finished.
+func (p *PipelineRunner) fail(run *models.PipelineRun) {
+	for i := range run.Nodes {
+		if !run.Nodes[i].State.Finished() {
+			run.Nodes[i].State = models.Failed
+		}
+	}
+	now := time.Now()
+	run.State = models.Failed
+	run.EndedAt = &now
+	p.save(run)
+}
+
+// Execute runs each node of the pipeline as soon as the nodes it depends on
+// have succeeded, and records the state of the nodes on the run. Nodes that
+// have already finished, in an earlier execution, are not run again, and
+// the runs of running nodes are waited for.
+func (p *PipelineRunner) Execute(pipeline *models.Pipeline, run *models.PipelineRun) {
+	now := time.Now()
+	run.State = models.Running
+	if run.StartedAt == nil {
+		run.StartedAt = &now
+	}
+	p.save(run)
+
+	done := map[string]chan struct{}{}
+	for _, n := range pipeline.Nodes {
+		done[n.Name] = make(chan struct{})
+	}
+	var wg sync.WaitGroup
+	for _, n := range pipeline.Nodes {
+		wg.Add(1)
+		go func(node models.PipelineNode) {
+			defer wg.Done()
+			defer close(done[node.Name])
+			for _, d := range node.DependsOn {
+				<-done[d]
+			}
+			p.executeNode(node, run)
+		}(n)
+	}
+	wg.Wait()
+
+	p.Lock()
+	defer p.Unlock()
+	run.State = models.Succeeded
+	for _, n := range run.Nodes {
+		if n.State != models.Succeeded {
+			run.State = models.Failed
+		}
+	}
+	ended := time.Now()
+	run.EndedAt = &ended
+	if _, err := p.pipelineRuns.Update(run); err != nil {
+		log.Error("Failed to update pipeline run ", run.ID, ": ", err)
+	}
+}
+
+func (p *PipelineRunner) executeNode(node models.PipelineNode, run *models.PipelineRun) {
+	p.Lock()
+	started := *run.Node(node.Name)
+	p.Unlock()
+	if started.State.Finished() {
+		return
+	}
+	if started.State == models.Running && started.RunID != 0 {
+		p.wait(node, run, started.RunID)
+		return
+	}
+	for _, d := range node.DependsOn {
+		if p.nodeState(run, d) != models.Succeeded {
+			p.setNode(run, node.Name, 0, models.Skipped)
+			return
+		}
+	}
+	job, err := p.jobs.FindOne(node.Job)
+	if err != nil {
+		log.Error("Pipeline ", run.PipelineSlug, " node ", node.Name, ": ", err)
+		p.setNode(run, node.Name, 0, models.Failed)
+		return
+	}
+	inputs, err := p.inputs(node, run)
+	if err != nil {
+		log.Error("Pipeline ", run.PipelineSlug, " node ", node.Name, ": ", err)
+		p.setNode(run, node.Name, 0, models.Failed)
+		return
+	}
+	jobRun, err := p.runner.StartWith(job, job.Params.Typed(inputs))
+	if err != nil {
+		log.Error("Pipeline ", run.PipelineSlug, " node ", node.Name, ": ", err)
+		p.setNode(run, node.Name, 0, models.Failed)
+		return
+	}
+	p.setNode(run, node.Name, jobRun.ID, models.Running)
+	p.wait(node, run, jobRun.ID)
+}
+
+// wait records the state of the run of the node when it has finished. The
+// node fails if the run cannot be read maxPollErrors times in a row, or
+// has not finished after NodeTimeout, when it is cancelled.
+func (p *PipelineRunner) wait(node models.PipelineNode, run *models.PipelineRun, runID uint) {
+	deadline := time.Now().Add(NodeTimeout)
+	failures := 0
+	interval := p.PollInterval
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		if interval *= 2; interval > PipelineLease/3 {
+			interval = PipelineLease / 3
+		}
+		p.renew(run)
+		r, err := p.runner.runs.FindOne(runID)
+		if err != nil {
+			log.Error("Pipeline ", run.PipelineSlug, " node ", node.Name, ": ", err)
+			failures++
+			if failures >= maxPollErrors {
+				p.setNode(run, node.Name, runID, models.Failed)
+				return
+			}
+			continue
+		}
+		failures = 0
+		if r.State.Finished() {
+			p.setNode(run, node.Name, runID, r.State)
+			return
+		}
+	}
+	log.Error("Pipeline ", run.PipelineSlug, " node ", node.Name, ": run ", runID, " did not finish in ", NodeTimeout)
+	if _, err := p.runner.Cancel(runID, "pipeline "+run.PipelineSlug); err != nil && err != ErrCancelling {
+		log.Error("Failed to cancel run ", runID, ": ", err)
+	}
+	p.setNode(run, node.Name, runID, models.Failed)
+}
+
+// renew extends the lease on the run when a third of it has passed.
+func (p *PipelineRunner) renew(run *models.PipelineRun) {
+	p.Lock()
+	defer p.Unlock()
+	now := time.Now()
+	if run.LeaseExpiresAt != nil && run.LeaseExpiresAt.Sub(now) > PipelineLease*2/3 {
+		return
+	}
+	expires := now.Add(PipelineLease)
+	run.LeaseExpiresAt = &expires
+	p.save(run)
+}
+
+// inputs returns the outputs of the runs of earlier nodes that the node
+// takes as inputs.
+func (p *PipelineRunner) inputs(node models.PipelineNode, run *models.PipelineRun) (models.StringMap, error) {
+	inputs := models.StringMap{}
+	for param := range node.Inputs {
+		name, output := node.Input(param)
+		p.Lock()
+		runID := run.Node(name).RunID
+		p.Unlock()
+		r, err := p.runner.runs.FindOne(runID)
+		if err != nil {
+			return nil, err
+		}
+		value, ok := r.Outputs[output]
+		if !ok {
+			return nil, fmt.Errorf("Node %s has no output %s", name, output)
+		}
+		inputs[param] = value
+	}
+	return inputs, nil
+}
+
+func (p *PipelineRunner) nodeState(run *models.PipelineRun, name string) models.RunState {
+	p.Lock()
+	defer p.Unlock()
+	return run.Node(name).State
+}
+
+func (p *PipelineRunner) setNode(run *models.PipelineRun, name string, runID uint, state models.RunState) {
+	p.Lock()
+	defer p.Unlock()
+	n := run.Node(name)
+	n.RunID = runID
+	n.State = state
+	p.save(run)
+}
+
+func (p *PipelineRunner) save(run *models.PipelineRun) {
+	if _, err := p.pipelineRuns.Update(run); err != nil {
+		log.Error("Failed to update pipeline run ", run.ID, ": ", err)
+	}
+}
@@ -0,0 +1,155 @@
+package runner
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func shellJob(name, script string) *models.Job {
+	job := models.NewJob(name)
+	job.Command = "sh"
+	job.Args = models.StringList{"-c", script}
+	return job
+}
+
+func executePipeline(pipeline *models.Pipeline, jobs ...*models.Job) (*models.PipelineRun, *models.MemRunRepo) {
+	runner := startRunner(jobs...)
+	defer close(runner.stop)
+	// Nodes without dependencies run in parallel, so give them a worker each.
+	other := NewWorker("other", runner.Runner, runner.jobs, models.NewMemWorkerRepo())
+	other.PollInterval = 10 * time.Millisecond
+	go other.Run(runner.stop)
+	pipelineRuns := models.NewMemPipelineRunRepo([]*models.PipelineRun{})
+	p := NewPipelineRunner(runner.Runner, runner.jobs, pipelineRuns)
+	p.PollInterval = 10 * time.Millisecond
+	run, _ := pipelineRuns.Add(models.NewPipelineRun(pipeline))
+	p.Execute(pipeline, run)
+	run, _ = pipelineRuns.FindOne(run.ID)
+	return run, runner.runs
+}
+
+func TestExecutePipeline(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	pipeline := models.NewPipeline("Build",
+		models.PipelineNode{Name: "first", Job: "a"},
+		models.PipelineNode{Name: "second", Job: "b", DependsOn: []string{"first"}})
+	run, runs := executePipeline(pipeline,
+		shellJob("A", "sleep 0.2; touch "+dir+"/a"),
+		shellJob("B", "test -f "+dir+"/a"))
+	assert.Equal(t, models.Succeeded, run.State)
+	assert.Equal(t, models.Succeeded, run.Node("first").State)
+	assert.Equal(t, models.Succeeded, run.Node("second").State)
+	assert.NotZero(t, run.Node("second").RunID)
+	assert.NotNil(t, run.EndedAt)
+	b, _ := runs.Find("b")
+	assert.Equal(t, 1, len(b))
+}
+
+func TestExecutePipelineParallel(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// a and b can only both succeed if they run at the same time.
+	pipeline := models.NewPipeline("Fan",
+		models.PipelineNode{Name: "a", Job: "a"},
+		models.PipelineNode{Name: "b", Job: "b"})
+	run, _ := executePipeline(pipeline,
+		shellJob("A", "touch "+dir+"/a; for i in 1 2 3 4 5 6 7 8 9 10; do test -f "+dir+"/b && exit 0; sleep 0.1; done; exit 1"),
+		shellJob("B", "touch "+dir+"/b; for i in 1 2 3 4 5 6 7 8 9 10; do test -f "+dir+"/a && exit 0; sleep 0.1; done; exit 1"))
+	assert.Equal(t, models.Succeeded, run.State)
+}
+
+func TestExecutePipelineSkipsAfterFailure(t *testing.T) {
+	pipeline := models.NewPipeline("Build",
+		models.PipelineNode{Name: "a", Job: "a"},
+		models.PipelineNode{Name: "b", Job: "b", DependsOn: []string{"a"}},
+		models.PipelineNode{Name: "c", Job: "c", DependsOn: []string{"b"}},
+		models.PipelineNode{Name: "d", Job: "missing"})
+	run, _ := executePipeline(pipeline,
+		shellJob("A", "exit 1"), shellJob("B", "true"), shellJob("C", "true"))
+	assert.Equal(t, models.Failed, run.State)
+	assert.Equal(t, models.Failed, run.Node("a").State)
+	assert.Equal(t, models.Skipped, run.Node("b").State)
+	assert.Equal(t, models.Skipped, run.Node("c").State)
+	assert.Equal(t, models.Failed, run.Node("d").State)
+}
+
+func TestExecutePipelineOutputs(t *testing.T) {
+	pipeline := models.NewPipeline("Release",
+		models.PipelineNode{Name: "build", Job: "build"},
+		models.PipelineNode{Name: "deploy", Job: "deploy", DependsOn: []string{"build"},
+			Inputs: map[string]string{"version": "build.version", "replicas": "build.replicas"}})
+	deploy := shellJob("Deploy", `test "$version" = 1.2 && test {{.replicas}} = 3`)
+	deploy.Params = models.Params{
+		{Name: "version", Type: models.ParamString},
+		{Name: "replicas", Type: models.ParamInt},
+	}
+	run, runs := executePipeline(pipeline,
+		shellJob("Build", `echo version=1.2 >> "$JOBS_OUTPUT"; echo replicas=3 >> "$JOBS_OUTPUT"`), deploy)
+	assert.Equal(t, models.Succeeded, run.State)
+	r, _ := runs.FindOne(run.Node("deploy").RunID)
+	assert.Equal(t, models.StringMap{"version": "1.2", "replicas": "3"}, r.Params)
+}
+
+func TestExecutePipelineMissingOutput(t *testing.T) {
+	pipeline := models.NewPipeline("Release",
+		models.PipelineNode{Name: "build", Job: "build"},
+		models.PipelineNode{Name: "deploy", Job: "deploy", DependsOn: []string{"build"},
+			Inputs: map[string]string{"version": "build.version"}})
+	run, _ := executePipeline(pipeline, shellJob("Build", "true"), shellJob("Deploy", "true"))
+	assert.Equal(t, models.Failed, run.State)
+	assert.Equal(t, models.Succeeded, run.Node("build").State)
+	assert.Equal(t, models.Failed, run.Node("deploy").State)
+}
+
+func TestResumePipeline(t *testing.T) {
+	runner := startRunner(shellJob("A", "true"), shellJob("B", "true"))
+	defer close(runner.stop)
+	pipeline := models.NewPipeline("Build",
+		models.PipelineNode{Name: "first", Job: "a"},
+		models.PipelineNode{Name: "second", Job: "b", DependsOn: []string{"first"}})
+	pipelines := models.NewMemPipelineRepo([]*models.Pipeline{pipeline})
+	a, _ := runner.runs.Add(&models.Run{JobSlug: "a", State: models.Succeeded})
+	interrupted := models.NewPipelineRun(pipeline)
+	interrupted.State = models.Running
+	interrupted.Nodes[0].RunID, interrupted.Nodes[0].State = a.ID, models.Succeeded
+	leased := models.NewPipelineRun(pipeline)
+	expires := time.Now().Add(time.Minute)
+	leased.LeaseExpiresAt = &expires
+	deleted := models.NewPipelineRun(models.NewPipeline("Gone", models.PipelineNode{Name: "a", Job: "a"}))
+	pipelineRuns := models.NewMemPipelineRunRepo([]*models.PipelineRun{interrupted, leased, deleted})
+	p := NewPipelineRunner(runner.Runner, runner.jobs, pipelineRuns)
+	p.PollInterval = 10 * time.Millisecond
+
+	assert.Nil(t, p.Resume(pipelines))
+
+	run, _ := pipelineRuns.FindOne(3)
+	assert.Equal(t, models.Failed, run.State)
+	assert.Equal(t, models.Failed, run.Node("a").State)
+	run, _ = pipelineRuns.FindOne(1)
+	for i := 0; i < 100 && !run.State.Finished(); i++ {
+		time.Sleep(20 * time.Millisecond)
+		run, _ = pipelineRuns.FindOne(1)
+	}
+	assert.Equal(t, models.Succeeded, run.State)
+	assert.Equal(t, a.ID, run.Node("first").RunID)
+	runs, _ := runner.runs.Find("a")
+	assert.Equal(t, 1, len(runs))
+	run, _ = pipelineRuns.FindOne(2)
+	assert.Equal(t, models.Queued, run.State)
+}
+
+func TestExecutePipelineNodeTimeout(t *testing.T) {
+	NodeTimeout = 200 * time.Millisecond
+	defer func() { NodeTimeout = 24 * time.Hour }()
+	pipeline := models.NewPipeline("Slow", models.PipelineNode{Name: "a", Job: "a"})
+	run, runs := executePipeline(pipeline, shellJob("A", "sleep 10"))
+	assert.Equal(t, models.Failed, run.State)
+	assert.Equal(t, models.Failed, run.Node("a").State)
+	r, _ := runs.FindOne(run.Node("a").RunID)
+	assert.Equal(t, "pipeline slow", r.CancelledBy)
+}
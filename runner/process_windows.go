@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package runner
+
+import (
+	"os/exec"
+)
+
+// Windows has no process groups to signal, so only the process itself is
+// killed, at once.
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func terminate(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,405 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrFinished is returned when cancelling a run that has already finished.
+var ErrFinished = errors.New("Run has already finished")
+
+// ErrCancelling is returned when cancelling a run that a worker in another
+// process executes. The cancel is recorded, and the worker stops the run
+// when it next heartbeats.
+var ErrCancelling = errors.New("Run is being cancelled")
+
+// ErrInterrupted is returned by Execute when ctx was cancelled, rather than
+// the run. The run is left running, for the caller to requeue or fail.
+var ErrInterrupted = errors.New("Run was interrupted")
+
+type Runner struct {
+	sync.Mutex
+	runs       models.RunRepo
+	logs       models.LogStore
+	queue      models.Queue
+	executions map[uint]*execution
+	// Artifacts and Blobs keep the artifacts of runs. Artifacts are not
+	// stored unless both are set.
+	Artifacts models.ArtifactRepo
+	Blobs     models.BlobStore
+	// Secrets are given to the runs they are scoped to, decrypted with
+	// Cipher, and masked in their logs.
+	Secrets models.SecretRepo
+	Cipher  *models.Cipher
+}
+
+// execution tracks a run that is executing, or about to, so that it can be
+// cancelled.
+type execution struct {
+	cancel      context.CancelFunc
+	cancelledBy string
+	cancelledAt *time.Time
+	done        chan struct{}
+}
+
+func NewRunner(runs models.RunRepo, logs models.LogStore, queue models.Queue) *Runner {
+	return &Runner{
+		runs:       runs,
+		logs:       logs,
+		queue:      queue,
+		executions: map[uint]*execution{},
+	}
+}
+
+// Start records a queued run of the job and puts it in the queue, for a
+// worker to execute. Params of the job take their default values.
+func (r *Runner) Start(job *models.Job) (*models.Run, error) {
+	return r.StartWith(job, nil)
+}
+
+// StartWith starts a run of the job with values for its params. It returns a
+// ValidationError if the values are invalid.
+func (r *Runner) StartWith(job *models.Job, values map[string]interface{}) (*models.Run, error) {
+	if job.Command == "" {
+		return nil, fmt.Errorf("Job %s has no command", job.Slug)
+	}
+	params, err := job.Params.Resolve(values)
+	if err != nil {
+		return nil, err
+	}
+	run := models.NewRun(job)
+	run.Params = params
+	run, err = r.runs.Add(run)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.queue.Enqueue(job, run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// WaitingOn sets what the runs that are queued are waiting on.
+func (r *Runner) WaitingOn(runs ...*models.Run) error {
+	for _, run := range runs {
+		if run.State != models.Queued {
+			continue
+		}
+		waiting, err := r.queue.WaitingOn(run.ID)
+		if err != nil {
+			return err
+		}
+		run.WaitingOn = waiting
+	}
+	return nil
+}
+
+func (r *Runner) track(runID uint) *execution {
+	r.Lock()
+	defer r.Unlock()
+	e := r.executions[runID]
+	if e == nil {
+		e = &execution{done: make(chan struct{})}
+		r.executions[runID] = e
+	}
+	return e
+}
+
+func (r *Runner) untrack(runID uint) {
+	r.Lock()
+	defer r.Unlock()
+	if e := r.executions[runID]; e != nil {
+		delete(r.executions, runID)
+		close(e.done)
+	}
+}
+
+// Cancel stops the run and waits for it to finish as cancelled, if it is
+// executing here. A run that is queued is taken out of the queue and
+// marked as cancelled. A run executing in another process is returned as
+// it is, with ErrCancelling.
+func (r *Runner) Cancel(runID uint, by string) (*models.Run, error) {
+	run, err := r.runs.FindOne(runID)
+	if err != nil {
+		return nil, err
+	}
+	if run.State.Finished() {
+		return run, ErrFinished
+	}
+	if e := r.interrupt(runID, by); e != nil {
+		<-e.done
+		return r.runs.FindOne(runID)
+	}
+	claimed, err := r.queue.Cancel(runID, by)
+	if err != nil {
+		return nil, err
+	}
+	if claimed {
+		if run, err = r.runs.FindOne(runID); err != nil {
+			return nil, err
+		}
+		return run, ErrCancelling
+	}
+	if run, err = r.runs.FindOne(runID); err != nil {
+		return nil, err
+	}
+	if run.State.Finished() {
+		return run, ErrFinished
+	}
+	now := time.Now()
+	run.Finish(models.Cancelled, run.ExitCode)
+	run.CancelledBy = by
+	run.CancelledAt = &now
+	return r.runs.Update(run)
+}
+
+// interrupt cancels the run if it is executing here, and returns its
+// execution.
+func (r *Runner) interrupt(runID uint, by string) *execution {
+	now := time.Now()
+	r.Lock()
+	defer r.Unlock()
+	e := r.executions[runID]
+	if e != nil {
+		e.cancelledBy = by
+		e.cancelledAt = &now
+		if e.cancel != nil {
+			e.cancel()
+		}
+	}
+	return e
+}
+
+// Execute runs the job's command to completion, attempting it again
+// according to the job's retry policy, and records the outcome of each
+// attempt on the run. Cancelling ctx, or the run, stops the command.
+func (r *Runner) Execute(ctx context.Context, job *models.Job, run *models.Run) error {
+	e := r.track(run.ID)
+	ctx, cancel := context.WithCancel(ctx)
+	r.Lock()
+	e.cancel = cancel
+	if e.cancelledAt != nil {
+		cancel()
+	}
+	r.Unlock()
+	defer func() {
+		cancel()
+		r.untrack(run.ID)
+	}()
+
+	run.Start()
+	r.save(run)
+	capture := newLogCapture(r.logs, run.ID)
+	secrets, err := r.openSecrets(job)
+	if err != nil {
+		capture.append(models.Stderr, fmt.Sprintf("Failed to open secrets: %s", err))
+		run.Finish(models.Failed, -1)
+		r.save(run)
+		return nil
+	}
+	defer secrets.remove()
+	capture.mask(secrets.values)
+	o := outcome{state: models.Cancelled, exitCode: -1}
+retry:
+	for ctx.Err() == nil {
+		attempt := run.StartAttempt()
+		r.save(run)
+		o = r.execute(ctx, job, run.Params, secrets.env, capture)
+		attempt.Finish(o.state, o.exitCode)
+		attempt.Signal = o.signal
+		if o.state == models.Succeeded || o.state == models.Cancelled ||
+			!job.Retry.ShouldRetry(attempt.Number, o.exitCode) {
+			break
+		}
+		delay := job.Retry.Delay(attempt.Number)
+		capture.append(models.Stderr, fmt.Sprintf("Attempt %d %s with exit code %d, retrying in %s",
+			attempt.Number, o.state, o.exitCode, delay))
+		r.save(run)
+		select {
+		case <-ctx.Done():
+			o.state = models.Cancelled
+			break retry
+		case <-time.After(delay):
+		}
+	}
+	run.Signal = o.signal
+	run.Outputs = o.outputs
+	if o.state == models.Cancelled {
+		r.Lock()
+		run.CancelledBy = e.cancelledBy
+		run.CancelledAt = e.cancelledAt
+		r.Unlock()
+		if run.CancelledAt == nil {
+			r.save(run)
+			return ErrInterrupted
+		}
+		capture.append(models.Stderr, fmt.Sprintf("Cancelled by %s", run.CancelledBy))
+	}
+	r.storeArtifacts(job, run, capture)
+	run.Finish(o.state, o.exitCode)
+	r.save(run)
+	return nil
+}
+
+func (r *Runner) save(run *models.Run) {
+	if _, err := r.runs.Update(run); err != nil {
+		log.Error("Failed to update run ", run.ID, ": ", err)
+	}
+}
+
+// OutputDelay is how long the output of a process is read after it has
+// exited, before it is closed on the children it has left running.
+var OutputDelay = time.Second
+
+// outcome is how an attempt ended. Signal is set when the process group
+// was signalled to stop, and outputs when it exited by itself.
+type outcome struct {
+	state    models.RunState
+	exitCode int
+	signal   string
+	outputs  models.StringMap
+}
+
+func (r *Runner) execute(ctx context.Context, job *models.Job, params models.StringMap, secrets []string, capture *logCapture) outcome {
+	command, args, err := expand(job, params)
+	if err != nil {
+		capture.append(models.Stderr, err.Error())
+		return outcome{state: models.Failed, exitCode: -1}
+	}
+	outputs, err := newOutputFile()
+	if err != nil {
+		capture.append(models.Stderr, err.Error())
+		return outcome{state: models.Failed, exitCode: -1}
+	}
+	defer os.Remove(outputs) // errcheck-ignore
+	cmd := exec.Command(command, args...)
+	cmd.Dir = job.Dir
+	cmd.Env = append(environ(job.Env), pairs(params)...)
+	cmd.Env = append(cmd.Env, secrets...)
+	cmd.Env = append(cmd.Env, OutputEnv+"="+outputs)
+	setProcessGroup(cmd)
+	stdout, stderr := capture.pipe(models.Stdout), capture.pipe(models.Stderr)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	// Children left in the background would keep the output open, and the
+	// run from finishing, after the process has exited.
+	cmd.WaitDelay = OutputDelay
+	defer func() {
+		stdout.Close() // errcheck-ignore
+		stderr.Close() // errcheck-ignore
+		capture.wait()
+	}()
+	if err := cmd.Start(); err != nil {
+		log.Warn("Job ", job.Slug, " failed to start: ", err)
+		capture.append(models.Stderr, err.Error())
+		return outcome{state: models.Failed, exitCode: -1}
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var timeout <-chan time.Time
+	if job.Timeout > 0 {
+		timer := time.NewTimer(job.Timeout.Duration())
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case err := <-done:
+		o := exited(err)
+		if o.outputs, err = readOutputs(outputs, capture); err != nil {
+			capture.append(models.Stderr, fmt.Sprintf("Failed to read outputs: %s", err))
+		}
+		return o
+	case <-timeout:
+		capture.append(models.Stderr, fmt.Sprintf("Timed out after %s", job.Timeout))
+		o := stop(cmd, done, job.TerminationGrace())
+		o.state = models.TimedOut
+		return o
+	case <-ctx.Done():
+		o := stop(cmd, done, job.TerminationGrace())
+		o.state = models.Cancelled
+		return o
+	}
+}
+
+// stop sends SIGTERM to the process group and, if it is still running
+// after grace, SIGKILL.
+func stop(cmd *exec.Cmd, done <-chan error, grace time.Duration) outcome {
+	signal := "SIGTERM"
+	if err := terminate(cmd); err != nil {
+		log.Warn("Failed to terminate process ", cmd.Process.Pid, ": ", err)
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-done:
+	case <-timer.C:
+		signal = "SIGKILL"
+		if err := kill(cmd); err != nil {
+			log.Warn("Failed to kill process ", cmd.Process.Pid, ": ", err)
+		}
+		err = <-done
+	}
+	o := exited(err)
+	o.signal = signal
+	return o
+}
+
+func exited(err error) outcome {
+	// The process succeeded, but its output was closed after OutputDelay.
+	if err == nil || err == exec.ErrWaitDelay {
+		return outcome{state: models.Succeeded}
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return outcome{state: models.Failed, exitCode: exitErr.ExitCode()}
+	}
+	return outcome{state: models.Failed, exitCode: -1}
+}
+
+// expand returns the command and args of the job with the param values
+// templated into them.
+func expand(job *models.Job, params models.StringMap) (string, []string, error) {
+	if len(job.Params) == 0 {
+		return job.Command, job.Args, nil
+	}
+	command, err := job.Params.Expand(job.Command, params)
+	if err != nil {
+		return "", nil, err
+	}
+	args := make([]string, len(job.Args))
+	for i, arg := range job.Args {
+		if args[i], err = job.Params.Expand(arg, params); err != nil {
+			return "", nil, err
+		}
+	}
+	return command, args, nil
+}
+
+func environ(env models.StringMap) []string {
+	return append(os.Environ(), pairs(env)...)
+}
+
+// pairs returns the variables as key=value, sorted by key.
+func pairs(env models.StringMap) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	vars := make([]string, 0, len(keys))
+	for _, k := range keys {
+		vars = append(vars, k+"="+env[k])
+	}
+	return vars
+}
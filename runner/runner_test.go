@@ -0,0 +1,392 @@
+package runner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func command(name string, args ...string) *models.Job {
+	job := models.NewJob("Test")
+	job.Command = name
+	job.Args = args
+	return job
+}
+
+func newQueue() *models.MemQueue {
+	return models.NewMemQueue(models.NewMemPoolRepo([]*models.Pool{}), models.NewMemTeamRepo([]*models.Team{}))
+}
+
+func execute(job *models.Job) (*models.Run, []*models.LogLine) {
+	runs := models.NewMemRunRepo([]*models.Run{})
+	logs := models.NewMemLogStore()
+	run, _ := runs.Add(models.NewRun(job))
+	NewRunner(runs, logs, newQueue()).Execute(context.Background(), job, run)
+	run, _ = runs.FindOne(run.ID)
+	lines, _ := logs.Read(run.ID, 0, 0)
+	return run, lines
+}
+
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestExecuteSucceeded(t *testing.T) {
+	run, _ := execute(command("true"))
+	assert.Equal(t, models.Succeeded, run.State)
+	assert.Equal(t, 0, run.ExitCode)
+	assert.NotNil(t, run.StartedAt)
+	assert.NotNil(t, run.EndedAt)
+}
+
+func TestExecuteFailed(t *testing.T) {
+	run, _ := execute(command("sh", "-c", "exit 3"))
+	assert.Equal(t, models.Failed, run.State)
+	assert.Equal(t, 3, run.ExitCode)
+}
+
+func TestExecuteEnvAndDir(t *testing.T) {
+	job := command("sh", "-c", `test "$(pwd)" = / && test "$GREETING" = hello`)
+	job.Dir = "/"
+	job.Env = models.StringMap{"GREETING": "hello"}
+	run, _ := execute(job)
+	assert.Equal(t, models.Succeeded, run.State)
+}
+
+func TestExecuteParams(t *testing.T) {
+	job := command("sh", "-c", `test "$ENV" = prod && test "{{.count}}" = 3`)
+	job.Params = models.Params{
+		{Name: "ENV", Type: models.ParamEnum, Values: []string{"test", "prod"}},
+		{Name: "count", Type: models.ParamInt, Default: 3},
+	}
+	runs := models.NewMemRunRepo([]*models.Run{})
+	runner := NewRunner(runs, models.NewMemLogStore(), newQueue())
+	run, err := runner.StartWith(job, map[string]interface{}{"ENV": "prod"})
+	assert.Nil(t, err)
+	assert.Equal(t, models.StringMap{"ENV": "prod", "count": "3"}, run.Params)
+	runner.Execute(context.Background(), job, run)
+	run, _ = runs.FindOne(run.ID)
+	assert.Equal(t, models.Succeeded, run.State)
+}
+
+func TestExecuteStoresArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	job := command("sh", "-c", "mkdir dist && printf hello > dist/a.txt && printf bye > dist/b.log && exit 1")
+	job.Dir = dir
+	job.Artifacts = models.StringList{"dist/*.txt", "dist/*", "missing/*"}
+	runs := models.NewMemRunRepo([]*models.Run{})
+	runner := NewRunner(runs, models.NewMemLogStore(), newQueue())
+	runner.Artifacts = models.NewMemArtifactRepo()
+	runner.Blobs = models.NewMemBlobStore()
+	run, _ := runs.Add(models.NewRun(job))
+	runner.Execute(context.Background(), job, run)
+
+	artifacts, _ := runner.Artifacts.Find(run.ID)
+	assert.Equal(t, 2, len(artifacts))
+	assert.Equal(t, "dist/a.txt", artifacts[0].Name)
+	assert.Equal(t, int64(5), artifacts[0].Size)
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", artifacts[0].SHA256)
+	assert.Equal(t, "dist/b.log", artifacts[1].Name)
+	blob, err := runner.Blobs.Get(artifacts[0].Key())
+	assert.Nil(t, err)
+	b, _ := ioutil.ReadAll(blob)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestExecuteOutputs(t *testing.T) {
+	run, lines := execute(command("sh", "-c", `printf 'version=1.2=3\nbad\n\nurl=x\nurl=y' > "$JOBS_OUTPUT"`))
+	assert.Equal(t, models.Succeeded, run.State)
+	assert.Equal(t, models.StringMap{"version": "1.2=3", "url": "y"}, run.Outputs)
+	assert.Equal(t, 1, len(lines))
+	assert.Equal(t, `Invalid output on line 2, expected name=value: "bad"`, lines[0].Text)
+}
+
+func TestExecuteSecrets(t *testing.T) {
+	cipher, _ := models.NewCipher("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	token := &models.Secret{Slug: "token", Job: "test", Env: "TOKEN", Value: "s3cr3t"}
+	key := &models.Secret{Slug: "key", Job: "test", Env: "KEY_FILE", AsFile: true, Value: "line one\nline two"}
+	other := &models.Secret{Slug: "other", Job: "other", Env: "OTHER", Value: "x"}
+	for _, secret := range []*models.Secret{token, key, other} {
+		_ = secret.Seal(cipher)
+	}
+	job := command("sh", "-c", `echo "token $TOKEN"; cat "$KEY_FILE"; echo "key=$KEY_FILE" > "$JOBS_OUTPUT"; echo "token=$TOKEN" >> "$JOBS_OUTPUT"; test -z "$OTHER"`)
+	runs := models.NewMemRunRepo([]*models.Run{})
+	logs := models.NewMemLogStore()
+	runner := NewRunner(runs, logs, newQueue())
+	runner.Secrets = models.NewMemSecretRepo([]*models.Secret{token, key, other})
+	runner.Cipher = cipher
+	run, _ := runs.Add(models.NewRun(job))
+	runner.Execute(context.Background(), job, run)
+
+	run, _ = runs.FindOne(run.ID)
+	assert.Equal(t, models.Succeeded, run.State)
+	lines, _ := logs.Read(run.ID, 0, 0)
+	texts := []string{}
+	for _, line := range lines {
+		texts = append(texts, line.Text)
+	}
+	assert.Equal(t, []string{"token ***", "***", "***"}, texts)
+	assert.Equal(t, "***", run.Outputs["token"])
+	assert.NotEmpty(t, run.Outputs["key"])
+	_, err := os.Stat(run.Outputs["key"])
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExecuteSecretsWithoutCipher(t *testing.T) {
+	job := command("true")
+	runs := models.NewMemRunRepo([]*models.Run{})
+	logs := models.NewMemLogStore()
+	runner := NewRunner(runs, logs, newQueue())
+	runner.Secrets = models.NewMemSecretRepo([]*models.Secret{{Slug: "token", Job: "test", Env: "TOKEN"}})
+	run, _ := runs.Add(models.NewRun(job))
+	runner.Execute(context.Background(), job, run)
+
+	run, _ = runs.FindOne(run.ID)
+	assert.Equal(t, models.Failed, run.State)
+	lines, _ := logs.Read(run.ID, 0, 0)
+	assert.Equal(t, 1, len(lines))
+	assert.Equal(t, "Failed to open secrets: Job test has secrets, but no master key is set to open them", lines[0].Text)
+}
+
+func TestExecuteBackgroundChild(t *testing.T) {
+	OutputDelay = 100 * time.Millisecond
+	defer func() { OutputDelay = time.Second }()
+	started := time.Now()
+	run, lines := execute(command("sh", "-c", "echo started; sleep 10 &"))
+	assert.True(t, time.Since(started) < 5*time.Second)
+	assert.Equal(t, models.Succeeded, run.State)
+	assert.Equal(t, 1, len(lines))
+	assert.Equal(t, "started", lines[0].Text)
+}
+
+func TestExecuteMissingCommand(t *testing.T) {
+	run, lines := execute(command("/no/such/command"))
+	assert.Equal(t, models.Failed, run.State)
+	assert.Equal(t, -1, run.ExitCode)
+	assert.Equal(t, 1, len(lines))
+	assert.Equal(t, models.Stderr, lines[0].Stream)
+}
+
+func TestExecuteCapturesLog(t *testing.T) {
+	run, lines := execute(command("sh", "-c", "echo one; sleep 0.1; echo two >&2; sleep 0.1; printf three"))
+	assert.Equal(t, models.Succeeded, run.State)
+	assert.Equal(t, 3, len(lines))
+	for i, text := range []string{"one", "two", "three"} {
+		assert.Equal(t, i, lines[i].Seq)
+		assert.Equal(t, text, lines[i].Text)
+		assert.Equal(t, run.ID, lines[i].RunID)
+		assert.NotZero(t, lines[i].Time)
+	}
+	assert.Equal(t, models.Stdout, lines[0].Stream)
+	assert.Equal(t, models.Stderr, lines[1].Stream)
+	assert.Equal(t, models.Stdout, lines[2].Stream)
+}
+
+type testRunner struct {
+	*Runner
+	runs  *models.MemRunRepo
+	logs  *models.MemLogStore
+	queue *models.MemQueue
+	jobs  *models.MemJobRepo
+	stop  chan struct{}
+}
+
+// startRunner returns a runner with a worker executing its runs until
+// stop is closed.
+func startRunner(jobs ...*models.Job) *testRunner {
+	r := &testRunner{
+		runs:  models.NewMemRunRepo([]*models.Run{}),
+		logs:  models.NewMemLogStore(),
+		queue: newQueue(),
+		jobs:  models.NewMemJobRepo(jobs),
+		stop:  make(chan struct{}),
+	}
+	r.Runner = NewRunner(r.runs, r.logs, r.queue)
+	worker := NewWorker("test", r.Runner, r.jobs, models.NewMemWorkerRepo())
+	worker.PollInterval = 10 * time.Millisecond
+	go worker.Run(r.stop)
+	return r
+}
+
+func TestStart(t *testing.T) {
+	runs := models.NewMemRunRepo([]*models.Run{})
+	queue := newQueue()
+	run, err := NewRunner(runs, models.NewMemLogStore(), queue).Start(command("true"))
+	assert.Nil(t, err)
+	assert.Equal(t, models.Queued, run.State)
+	items, _ := queue.Find()
+	assert.Equal(t, 1, len(items))
+	assert.Equal(t, run.ID, items[0].RunID)
+}
+
+func TestStartWithoutCommand(t *testing.T) {
+	runs := models.NewMemRunRepo([]*models.Run{})
+	run, err := NewRunner(runs, models.NewMemLogStore(), newQueue()).Start(models.NewJob("Empty"))
+	assert.Nil(t, run)
+	assert.EqualError(t, err, "Job empty has no command")
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestExecuteRetries(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// Fails with 75 twice, then succeeds.
+	job := command("sh", "-c", "echo x >> "+dir+"/count; test $(wc -l < "+dir+"/count) -ge 3 || exit 75")
+	job.Retry = models.RetryPolicy{
+		MaxAttempts:  5,
+		InitialDelay: models.Duration(10 * time.Millisecond),
+		RetryOn:      []int{75},
+	}
+	run, lines := execute(job)
+	assert.Equal(t, models.Succeeded, run.State)
+	assert.Equal(t, 3, len(run.Attempts))
+	assert.Equal(t, models.Failed, run.Attempts[0].State)
+	assert.Equal(t, 75, run.Attempts[0].ExitCode)
+	assert.Equal(t, models.Succeeded, run.Attempts[2].State)
+	assert.Equal(t, 3, run.Attempts[2].Number)
+	assert.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[0].Text, "Attempt 1 failed with exit code 75")
+}
+
+func TestExecuteRetriesGiveUp(t *testing.T) {
+	job := command("sh", "-c", "exit 2")
+	job.Retry = models.RetryPolicy{MaxAttempts: 2, InitialDelay: models.Duration(time.Millisecond)}
+	run, _ := execute(job)
+	assert.Equal(t, models.Failed, run.State)
+	assert.Equal(t, 2, run.ExitCode)
+	assert.Equal(t, 2, len(run.Attempts))
+}
+
+func TestExecuteRetriesOnlyListedCodes(t *testing.T) {
+	job := command("sh", "-c", "exit 2")
+	job.Retry = models.RetryPolicy{MaxAttempts: 3, RetryOn: []int{75}}
+	run, _ := execute(job)
+	assert.Equal(t, models.Failed, run.State)
+	assert.Equal(t, 1, len(run.Attempts))
+}
+
+func TestExecuteTimeout(t *testing.T) {
+	job := command("sh", "-c", "echo started; sleep 10")
+	job.Timeout = models.Duration(200 * time.Millisecond)
+	start := time.Now()
+	run, lines := execute(job)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, models.TimedOut, run.State)
+	assert.Equal(t, "SIGTERM", run.Signal)
+	assert.Equal(t, models.TimedOut, run.Attempts[0].State)
+	assert.Equal(t, "SIGTERM", run.Attempts[0].Signal)
+	assert.Equal(t, "started", lines[0].Text)
+	assert.Equal(t, "Timed out after 200ms", lines[1].Text)
+}
+
+func TestExecuteTimeoutKillsProcessGroup(t *testing.T) {
+	// The background sleep keeps stdout open, so the run only ends when
+	// the whole group is gone.
+	job := command("sh", "-c", "sleep 10 & sleep 10")
+	job.Timeout = models.Duration(200 * time.Millisecond)
+	start := time.Now()
+	run, _ := execute(job)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, models.TimedOut, run.State)
+}
+
+func TestExecuteTimeoutKillsAfterGrace(t *testing.T) {
+	job := command("sh", "-c", "trap '' TERM; sleep 10")
+	job.Timeout = models.Duration(200 * time.Millisecond)
+	job.KillGrace = models.Duration(200 * time.Millisecond)
+	start := time.Now()
+	run, _ := execute(job)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, models.TimedOut, run.State)
+	assert.Equal(t, "SIGKILL", run.Signal)
+}
+
+func waitForState(runs models.RunRepo, id uint, state models.RunState) bool {
+	return waitFor(func() bool {
+		r, _ := runs.FindOne(id)
+		return r.State == state
+	})
+}
+
+func TestCancel(t *testing.T) {
+	job := command("sh", "-c", "echo started; sleep 10 & sleep 10")
+	runner := startRunner(job)
+	defer close(runner.stop)
+	logs := runner.logs
+	run, _ := runner.Start(job)
+	assert.True(t, waitFor(func() bool {
+		lines, _ := logs.Read(run.ID, 0, 0)
+		return len(lines) > 0
+	}))
+
+	start := time.Now()
+	cancelled, err := runner.Cancel(run.ID, "alice")
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Equal(t, models.Cancelled, cancelled.State)
+	assert.Equal(t, "alice", cancelled.CancelledBy)
+	assert.NotNil(t, cancelled.CancelledAt)
+	assert.Equal(t, "SIGTERM", cancelled.Signal)
+	lines, _ := logs.Read(run.ID, 0, 0)
+	assert.Equal(t, "Cancelled by alice", lines[len(lines)-1].Text)
+}
+
+func TestCancelDuringRetryDelay(t *testing.T) {
+	job := command("false")
+	job.Retry = models.RetryPolicy{MaxAttempts: 3, InitialDelay: models.Duration(time.Hour)}
+	runner := startRunner(job)
+	defer close(runner.stop)
+	run, _ := runner.Start(job)
+	assert.True(t, waitFor(func() bool {
+		r, _ := runner.runs.FindOne(run.ID)
+		return len(r.Attempts) == 1 && r.Attempts[0].State == models.Failed
+	}))
+	cancelled, err := runner.Cancel(run.ID, "bob")
+	assert.Nil(t, err)
+	assert.Equal(t, models.Cancelled, cancelled.State)
+	assert.Equal(t, 1, len(cancelled.Attempts))
+}
+
+func TestCancelFinished(t *testing.T) {
+	job := command("true")
+	runner := startRunner(job)
+	defer close(runner.stop)
+	run, _ := runner.Start(job)
+	assert.True(t, waitForState(runner.runs, run.ID, models.Succeeded))
+	_, err := runner.Cancel(run.ID, "alice")
+	assert.Equal(t, ErrFinished, err)
+}
+
+func TestCancelQueued(t *testing.T) {
+	runs := models.NewMemRunRepo([]*models.Run{})
+	queue := newQueue()
+	runner := NewRunner(runs, models.NewMemLogStore(), queue)
+	run, _ := runner.Start(command("true"))
+	cancelled, err := runner.Cancel(run.ID, "alice")
+	assert.Nil(t, err)
+	assert.Equal(t, models.Cancelled, cancelled.State)
+	assert.Equal(t, "alice", cancelled.CancelledBy)
+	items, _ := queue.Find()
+	assert.Equal(t, 0, len(items))
+}
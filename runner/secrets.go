@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/andersjanmyr/jobs/models"
+)
+
+// secrets are the secrets of a run, opened as environment variables and
+// temporary files.
+type secrets struct {
+	env    []string
+	values []string
+	files  []string
+}
+
+// openSecrets decrypts the secrets scoped to the job. The files of the
+// secrets are to be removed when the run finishes. It fails if the job has
+// secrets, but there is no Cipher to open them, rather than run the job
+// without them.
+func (r *Runner) openSecrets(job *models.Job) (*secrets, error) {
+	s := &secrets{}
+	if r.Secrets == nil {
+		return s, nil
+	}
+	scoped, err := r.Secrets.FindScoped(job)
+	if err != nil {
+		return nil, err
+	}
+	if len(scoped) > 0 && r.Cipher == nil {
+		return nil, fmt.Errorf("Job %s has secrets, but no master key is set to open them", job.Slug)
+	}
+	for _, secret := range scoped {
+		value, err := secret.Open(r.Cipher)
+		if err != nil {
+			s.remove()
+			return nil, err
+		}
+		s.values = append(s.values, value)
+		if !secret.AsFile {
+			s.env = append(s.env, secret.Env+"="+value)
+			continue
+		}
+		path, err := writeSecret(value)
+		if err != nil {
+			s.remove()
+			return nil, err
+		}
+		s.files = append(s.files, path)
+		s.env = append(s.env, secret.Env+"="+path)
+	}
+	return s, nil
+}
+
+// writeSecret writes the value to a file that only the owner can read.
+func writeSecret(value string) (string, error) {
+	f, err := ioutil.TempFile("", "jobs-secret")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()           // errcheck-ignore
+		os.Remove(f.Name()) // errcheck-ignore
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+func (s *secrets) remove() {
+	for _, path := range s.files {
+		os.Remove(path) // errcheck-ignore
+	}
+}
@@ -0,0 +1,230 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// Worker executes the runs it claims from the queue, up to its capacity at
+// a time, of the jobs its labels match. It holds a lease on each run while
+// executing it, kept alive by heartbeats. If the worker dies the lease
+// expires, and the next worker to claim the run requeues or fails it
+// according to the job's lost policy.
+type Worker struct {
+	models.Worker
+	PollInterval time.Duration
+	// Lease is how long a run is held without a heartbeat. The worker
+	// heartbeats three times per lease.
+	Lease   time.Duration
+	runner  *Runner
+	jobs    models.JobRepo
+	workers models.WorkerRepo
+}
+
+func NewWorker(name string, runner *Runner, jobs models.JobRepo, workers models.WorkerRepo) *Worker {
+	return &Worker{
+		Worker: models.Worker{
+			Name:     name,
+			Labels:   models.StringMap{},
+			Capacity: 1,
+		},
+		PollInterval: time.Second,
+		Lease:        30 * time.Second,
+		runner:       runner,
+		jobs:         jobs,
+		workers:      workers,
+	}
+}
+
+// Run registers the worker and works until stop is closed, polling the
+// queue when it is empty. The runs that are executing when stop is closed
+// are stopped, and requeued or failed.
+func (w *Worker) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	if err := w.workers.Register(&w.Worker, w.Lease); err != nil {
+		log.Error("Failed to register worker ", w.Name, ": ", err)
+	}
+	slots := w.Capacity
+	if slots < 1 {
+		slots = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < slots; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.poll(ctx)
+		}()
+	}
+	w.register(ctx)
+	wg.Wait()
+	if err := w.workers.Remove(w.Name); err != nil {
+		log.Error("Failed to remove worker ", w.Name, ": ", err)
+	}
+}
+
+// register heartbeats the registration of the worker until ctx is done.
+func (w *Worker) register(ctx context.Context) {
+	ticker := time.NewTicker(w.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := w.workers.Heartbeat(w.Name, w.Lease); err != nil {
+			log.Error("Worker ", w.Name, " failed to heartbeat: ", err)
+		}
+	}
+}
+
+// poll works until ctx is done, waiting PollInterval when the queue is
+// empty.
+func (w *Worker) poll(ctx context.Context) {
+	for {
+		worked, err := w.Work(ctx)
+		if err != nil {
+			log.Error("Worker ", w.Name, " failed: ", err)
+		}
+		wait := w.PollInterval
+		if worked {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Work claims a run from the queue and executes it, until it finishes or
+// ctx is cancelled. It tells if there was a run to claim.
+func (w *Worker) Work(ctx context.Context) (bool, error) {
+	r := w.runner
+	item, err := r.queue.Claim(&w.Worker, w.Lease)
+	if err != nil || item == nil {
+		return false, err
+	}
+	// Tracking the run before looking at its state makes sure that a
+	// cancel from now on is seen by Execute.
+	r.track(item.RunID)
+	job, run, err := w.prepare(item)
+	if run == nil {
+		r.untrack(item.RunID)
+		w.remove(item.RunID)
+		return true, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	lost := make(chan bool, 1)
+	go func() {
+		lost <- w.heartbeat(ctx, cancel, run.ID)
+	}()
+	err = r.Execute(ctx, job, run)
+	cancel()
+	if <-lost {
+		log.Warn("Worker ", w.Name, " lost the lease on run ", run.ID)
+		return true, nil
+	}
+	if err == ErrInterrupted && w.lost(job, run, fmt.Sprintf("Worker %s stopped", w.Name)) {
+		if err := r.queue.Release(run.ID); err != nil {
+			log.Error("Failed to release run ", run.ID, ": ", err)
+		}
+		return true, nil
+	}
+	w.remove(run.ID)
+	return true, nil
+}
+
+// prepare loads the job and run of the item. The run is nil when it is not
+// to be executed.
+func (w *Worker) prepare(item *models.QueueItem) (*models.Job, *models.Run, error) {
+	r := w.runner
+	run, err := r.runs.FindOne(item.RunID)
+	if err != nil || run.State.Finished() {
+		return nil, nil, err
+	}
+	if item.CancelledBy != "" {
+		// The run was cancelled while held by a worker that went away.
+		now := time.Now()
+		run.Finish(models.Cancelled, run.ExitCode)
+		run.CancelledBy = item.CancelledBy
+		run.CancelledAt = &now
+		r.save(run)
+		return nil, nil, nil
+	}
+	job, err := w.jobs.FindOne(run.JobSlug)
+	if err != nil {
+		run.Finish(models.Failed, -1)
+		r.save(run)
+		return nil, nil, err
+	}
+	if run.State == models.Running && !w.lost(job, run, "Worker lease expired") {
+		return nil, nil, nil
+	}
+	return job, run, nil
+}
+
+// lost records that the run was lost by its worker, and fails it if the
+// job says so. It tells if the run is to be executed again.
+func (w *Worker) lost(job *models.Job, run *models.Run, reason string) bool {
+	capture := newLogCapture(w.runner.logs, run.ID)
+	if job.OnLost == models.LostFail {
+		capture.append(models.Stderr, reason+", failing run")
+		run.Finish(models.Lost, -1)
+		w.runner.save(run)
+		return false
+	}
+	capture.append(models.Stderr, reason+", requeuing run")
+	run.State = models.Queued
+	w.runner.save(run)
+	return true
+}
+
+// heartbeat keeps the lease on the run until ctx is done, and stops the run
+// when it is cancelled from another process. It cancels ctx and returns
+// true if the lease is lost.
+func (w *Worker) heartbeat(ctx context.Context, cancel context.CancelFunc, runID uint) bool {
+	ticker := time.NewTicker(w.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		item, err := w.runner.queue.Heartbeat(runID, w.Name, w.Lease)
+		if err == models.ErrLeaseLost {
+			cancel()
+			return true
+		}
+		if err != nil {
+			log.Error("Worker ", w.Name, " failed to heartbeat run ", runID, ": ", err)
+			continue
+		}
+		if item.CancelledBy != "" {
+			w.runner.interrupt(runID, item.CancelledBy)
+		}
+	}
+}
+
+func (w *Worker) remove(runID uint) {
+	if err := w.runner.queue.Remove(runID); err != nil {
+		log.Error("Failed to remove run ", runID, " from queue: ", err)
+	}
+}
@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWork(t *testing.T) {
+	job := command("true")
+	runs := models.NewMemRunRepo([]*models.Run{})
+	queue := newQueue()
+	runner := NewRunner(runs, models.NewMemLogStore(), queue)
+	worker := NewWorker("test", runner, models.NewMemJobRepo([]*models.Job{job}), models.NewMemWorkerRepo())
+
+	run, _ := runner.Start(job)
+	worked, err := worker.Work(context.Background())
+	assert.True(t, worked)
+	assert.Nil(t, err)
+	run, _ = runs.FindOne(run.ID)
+	assert.Equal(t, models.Succeeded, run.State)
+	items, _ := queue.Find()
+	assert.Equal(t, 0, len(items))
+
+	worked, err = worker.Work(context.Background())
+	assert.False(t, worked)
+	assert.Nil(t, err)
+}
+
+func TestWorkMissingJob(t *testing.T) {
+	runs := models.NewMemRunRepo([]*models.Run{})
+	runner := NewRunner(runs, models.NewMemLogStore(), newQueue())
+	worker := NewWorker("test", runner, models.NewMemJobRepo([]*models.Job{}), models.NewMemWorkerRepo())
+
+	run, _ := runner.Start(command("true"))
+	worked, err := worker.Work(context.Background())
+	assert.True(t, worked)
+	assert.NotNil(t, err)
+	run, _ = runs.FindOne(run.ID)
+	assert.Equal(t, models.Failed, run.State)
+}
+
+// claimAndDie claims the run as a worker that goes away, leaving it running
+// with an expired lease.
+func claimAndDie(t *testing.T, runner *Runner, run *models.Run) {
+	_, err := runner.queue.Claim(&models.Worker{Name: "dead"}, -time.Second)
+	assert.Nil(t, err)
+	run.Start()
+	runner.save(run)
+}
+
+func TestWorkExpiredLeaseRequeues(t *testing.T) {
+	job := command("true")
+	runs := models.NewMemRunRepo([]*models.Run{})
+	logs := models.NewMemLogStore()
+	runner := NewRunner(runs, logs, newQueue())
+	worker := NewWorker("test", runner, models.NewMemJobRepo([]*models.Job{job}), models.NewMemWorkerRepo())
+
+	run, _ := runner.Start(job)
+	claimAndDie(t, runner, run)
+	worked, err := worker.Work(context.Background())
+	assert.True(t, worked)
+	assert.Nil(t, err)
+	run, _ = runs.FindOne(run.ID)
+	assert.Equal(t, models.Succeeded, run.State)
+	lines, _ := logs.Read(run.ID, 0, 0)
+	assert.Equal(t, "Worker lease expired, requeuing run", lines[0].Text)
+}
+
+func TestWorkExpiredLeaseFails(t *testing.T) {
+	job := command("true")
+	job.OnLost = models.LostFail
+	runs := models.NewMemRunRepo([]*models.Run{})
+	queue := newQueue()
+	runner := NewRunner(runs, models.NewMemLogStore(), queue)
+	worker := NewWorker("test", runner, models.NewMemJobRepo([]*models.Job{job}), models.NewMemWorkerRepo())
+
+	run, _ := runner.Start(job)
+	claimAndDie(t, runner, run)
+	_, _ = worker.Work(context.Background())
+	run, _ = runs.FindOne(run.ID)
+	assert.Equal(t, models.Lost, run.State)
+	items, _ := queue.Find()
+	assert.Equal(t, 0, len(items))
+}
+
+func TestWorkerStopRequeues(t *testing.T) {
+	job := command("sleep", "10")
+	runs := models.NewMemRunRepo([]*models.Run{})
+	queue := newQueue()
+	runner := NewRunner(runs, models.NewMemLogStore(), queue)
+	worker := NewWorker("test", runner, models.NewMemJobRepo([]*models.Job{job}), models.NewMemWorkerRepo())
+
+	run, _ := runner.Start(job)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		worker.Run(stop)
+		close(done)
+	}()
+	assert.True(t, waitForState(runs, run.ID, models.Running))
+	close(stop)
+	<-done
+	run, _ = runs.FindOne(run.ID)
+	assert.Equal(t, models.Queued, run.State)
+	items, _ := queue.Find()
+	assert.Equal(t, 1, len(items))
+	assert.False(t, items[0].Claimed())
+}
+
+func TestWorkCancelledFromAnotherProcess(t *testing.T) {
+	job := command("sleep", "10")
+	runs := models.NewMemRunRepo([]*models.Run{})
+	queue := newQueue()
+	jobs := models.NewMemJobRepo([]*models.Job{job})
+	worker := NewWorker("test", NewRunner(runs, models.NewMemLogStore(), queue), jobs, models.NewMemWorkerRepo())
+	worker.Lease = 30 * time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+	go worker.Run(stop)
+
+	// The server has a runner of its own, without workers.
+	server := NewRunner(runs, models.NewMemLogStore(), queue)
+	run, _ := server.Start(job)
+	assert.True(t, waitForState(runs, run.ID, models.Running))
+	cancelling, err := server.Cancel(run.ID, "alice")
+	assert.Equal(t, ErrCancelling, err)
+	assert.Equal(t, models.Running, cancelling.State)
+	// The worker stops the run when it next heartbeats.
+	assert.True(t, waitForState(runs, run.ID, models.Cancelled))
+	cancelled, _ := runs.FindOne(run.ID)
+	assert.Equal(t, "alice", cancelled.CancelledBy)
+}
+
+func TestWorkerCapacity(t *testing.T) {
+	job := command("sleep", "10")
+	runs := models.NewMemRunRepo([]*models.Run{})
+	workers := models.NewMemWorkerRepo()
+	runner := NewRunner(runs, models.NewMemLogStore(), newQueue())
+	worker := NewWorker("test", runner, models.NewMemJobRepo([]*models.Job{job}), workers)
+	worker.Capacity = 2
+	worker.PollInterval = 10 * time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+
+	first, _ := runner.Start(job)
+	second, _ := runner.Start(job)
+	third, _ := runner.Start(job)
+	go worker.Run(stop)
+	assert.True(t, waitForState(runs, first.ID, models.Running))
+	assert.True(t, waitForState(runs, second.ID, models.Running))
+	time.Sleep(50 * time.Millisecond)
+	run, _ := runs.FindOne(third.ID)
+	assert.Equal(t, models.Queued, run.State)
+	registered, _ := workers.Find()
+	assert.Equal(t, 1, len(registered))
+	assert.Equal(t, 2, registered[0].Capacity)
+}
@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"time"
+
+	"github.com/andersjanmyr/jobs/cron"
+	"github.com/andersjanmyr/jobs/models"
+	"github.com/andersjanmyr/jobs/runner"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxCatchUp bounds the number of missed runs started for a job with the
+// CatchUpAll policy.
+const maxCatchUp = 100
+
+// Scheduler starts runs of the jobs that have a schedule when they are due.
+// Fires that are more than Grace late were missed, because the server was
+// down, and are handled according to the job's catch-up policy.
+type Scheduler struct {
+	jobs     models.JobRepo
+	runner   *runner.Runner
+	Interval time.Duration
+	Grace    time.Duration
+	// Lock, if set, is held by the one server that schedules runs. The
+	// others wait to take it over.
+	Lock    models.Lock
+	holding bool
+}
+
+func NewScheduler(jobs models.JobRepo, runner *runner.Runner) *Scheduler {
+	return &Scheduler{
+		jobs:     jobs,
+		runner:   runner,
+		Interval: time.Second,
+		Grace:    time.Minute,
+	}
+}
+
+// Run ticks every Interval until stop is closed.
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if s.hold() {
+				s.Tick(now)
+			}
+		}
+	}
+}
+
+// hold tells if the scheduler holds its lock, or has none.
+func (s *Scheduler) hold() bool {
+	if s.Lock == nil {
+		return true
+	}
+	holding, err := s.Lock.Hold()
+	if err != nil {
+		log.Error("Scheduler failed to take its lock: ", err)
+	}
+	if holding != s.holding {
+		if holding {
+			log.Info("Scheduler started on this server")
+		} else {
+			log.Info("Scheduler stopped on this server")
+		}
+		s.holding = holding
+	}
+	return holding
+}
+
+// Tick starts the runs that are due at now.
+func (s *Scheduler) Tick(now time.Time) {
+	scheduled := true
+	jobs, err := s.jobs.Find(&models.JobQuery{Scheduled: &scheduled})
+	if err != nil {
+		log.Error("Scheduler failed to find jobs: ", err)
+		return
+	}
+	for _, job := range jobs {
+		if err := s.tick(job, now); err != nil {
+			log.Error("Scheduler failed for job ", job.Slug, ": ", err)
+		}
+	}
+}
+
+func (s *Scheduler) tick(job *models.Job, now time.Time) error {
+	schedule, err := cron.Parse(job.Schedule)
+	if err != nil {
+		return err
+	}
+	now = now.In(job.Location())
+	lastRunAt := job.LastRunAt
+	if job.NextRunAt != nil {
+		if job.NextRunAt.After(now) {
+			return nil
+		}
+		fires := append([]time.Time{*job.NextRunAt},
+			schedule.Between(*job.NextRunAt, now, maxCatchUp)...)
+		for _, fire := range s.due(job, fires, now) {
+			log.Info("Starting scheduled run of ", job.Slug, " for ", fire)
+			if _, err := s.runner.Start(job); err != nil {
+				log.Error("Failed to start scheduled run of ", job.Slug, ": ", err)
+			}
+		}
+		lastRunAt = &fires[len(fires)-1]
+	}
+	var nextRunAt *time.Time
+	if next := schedule.Next(now); !next.IsZero() {
+		nextRunAt = &next
+	}
+	// Only the run times are written, since the job may have been changed
+	// since it was read.
+	return s.jobs.UpdateRunTimes(job.Slug, nextRunAt, lastRunAt)
+}
+
+// due picks the fires to start runs for.
+func (s *Scheduler) due(job *models.Job, fires []time.Time, now time.Time) []time.Time {
+	var onTime, missed []time.Time
+	for _, fire := range fires {
+		if now.Sub(fire) <= s.Grace {
+			onTime = append(onTime, fire)
+		} else {
+			missed = append(missed, fire)
+		}
+	}
+	switch job.CatchUp {
+	case models.CatchUpAll:
+		return append(missed, onTime...)
+	case models.CatchUpOnce:
+		if len(missed) > 0 && len(onTime) == 0 {
+			return missed[len(missed)-1:]
+		}
+	}
+	return onTime
+}
@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andersjanmyr/jobs/models"
+	"github.com/andersjanmyr/jobs/runner"
+	"github.com/stretchr/testify/assert"
+)
+
+func scheduledJob(schedule string, catchUp models.CatchUpPolicy) *models.Job {
+	job := models.NewJob("Tick")
+	job.Command = "true"
+	job.Schedule = schedule
+	job.CatchUp = catchUp
+	return job
+}
+
+func setup(job *models.Job) (*Scheduler, *models.MemJobRepo, *models.MemRunRepo) {
+	jobs := models.NewMemJobRepo([]*models.Job{job})
+	runs := models.NewMemRunRepo([]*models.Run{})
+	queue := models.NewMemQueue(models.NewMemPoolRepo([]*models.Pool{}), models.NewMemTeamRepo([]*models.Team{}))
+	return NewScheduler(jobs, runner.NewRunner(runs, models.NewMemLogStore(), queue)), jobs, runs
+}
+
+func at(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestTickSetsNextRunAt(t *testing.T) {
+	scheduler, jobs, runs := setup(scheduledJob("0 * * * *", ""))
+	scheduler.Tick(at("2017-06-01T10:30:00Z"))
+	job, _ := jobs.FindOne("tick")
+	assert.Equal(t, at("2017-06-01T11:00:00Z"), job.NextRunAt.UTC())
+	assert.Nil(t, job.LastRunAt)
+	found, _ := runs.Find("tick")
+	assert.Equal(t, 0, len(found))
+}
+
+func TestTickStartsDueRun(t *testing.T) {
+	scheduler, jobs, runs := setup(scheduledJob("0 * * * *", ""))
+	scheduler.Tick(at("2017-06-01T10:30:00Z"))
+	scheduler.Tick(at("2017-06-01T10:59:59Z"))
+	found, _ := runs.Find("tick")
+	assert.Equal(t, 0, len(found))
+
+	scheduler.Tick(at("2017-06-01T11:00:00Z"))
+	job, _ := jobs.FindOne("tick")
+	assert.Equal(t, at("2017-06-01T11:00:00Z"), job.LastRunAt.UTC())
+	assert.Equal(t, at("2017-06-01T12:00:00Z"), job.NextRunAt.UTC())
+	found, _ = runs.Find("tick")
+	assert.Equal(t, 1, len(found))
+}
+
+func TestTickTimezone(t *testing.T) {
+	job := scheduledJob("0 0 9 * * *", "")
+	job.Timezone = "Europe/Stockholm"
+	scheduler, jobs, _ := setup(job)
+	scheduler.Tick(at("2017-06-01T10:30:00Z"))
+	job, _ = jobs.FindOne("tick")
+	assert.Equal(t, at("2017-06-02T07:00:00Z"), job.NextRunAt.UTC())
+}
+
+func missedRuns(catchUp models.CatchUpPolicy) int {
+	scheduler, _, runs := setup(scheduledJob("0 * * * *", catchUp))
+	scheduler.Tick(at("2017-06-01T10:30:00Z"))
+	// Down from 10:30 to 14:30, missing 11, 12, 13 and 14.
+	scheduler.Tick(at("2017-06-01T14:30:00Z"))
+	found, _ := runs.Find("tick")
+	return len(found)
+}
+
+func TestTickCatchUp(t *testing.T) {
+	assert.Equal(t, 0, missedRuns(""))
+	assert.Equal(t, 0, missedRuns(models.CatchUpSkip))
+	assert.Equal(t, 1, missedRuns(models.CatchUpOnce))
+	assert.Equal(t, 4, missedRuns(models.CatchUpAll))
+}
+
+func TestTickKeepsConcurrentChanges(t *testing.T) {
+	scheduler, jobs, _ := setup(scheduledJob("0 * * * *", ""))
+	read, _ := jobs.FindOne("tick")
+	changed := *read
+	changed.Command = "make"
+	changed.Version = 2
+	_, _ = jobs.Replace(&changed)
+	assert.Nil(t, scheduler.tick(read, at("2017-06-01T10:30:00Z")))
+	job, _ := jobs.FindOne("tick")
+	assert.Equal(t, "make", job.Command)
+	assert.Equal(t, 2, job.Version)
+	assert.Equal(t, at("2017-06-01T11:00:00Z"), job.NextRunAt.UTC())
+}
+
+type lock bool
+
+func (l lock) Hold() (bool, error) {
+	return bool(l), nil
+}
+
+func TestRunHoldsLock(t *testing.T) {
+	for _, held := range []bool{false, true} {
+		scheduler, jobs, _ := setup(scheduledJob("0 * * * *", ""))
+		scheduler.Interval = 10 * time.Millisecond
+		scheduler.Lock = lock(held)
+		stop := make(chan struct{})
+		go scheduler.Run(stop)
+		time.Sleep(50 * time.Millisecond)
+		close(stop)
+		job, _ := jobs.FindOne("tick")
+		assert.Equal(t, held, job.NextRunAt != nil)
+	}
+}